@@ -1,12 +1,32 @@
-import { useState } from 'react'
+import { useState, useEffect } from 'react'
 import './SongEditor.css'
-import { updateSong } from './api'
+import { fetchSong, updateSong } from './api'
 
 function SongEditor({ song, onSongUpdated, onClose }) {
   const [title, setTitle] = useState(song.title || '')
   const [trackNumber, setTrackNumber] = useState(song.track_number || '')
   const [updating, setUpdating] = useState(false)
   const [error, setError] = useState(null)
+  const [etag, setEtag] = useState(null)
+
+  // Edit the latest version of the song, so that saving fails rather than
+  // overwriting changes made elsewhere after this one was loaded
+  useEffect(() => {
+    let cancelled = false
+    fetchSong(song.id)
+      .then(({ song: latest, etag }) => {
+        if (cancelled) return
+        setTitle(latest.title || '')
+        setTrackNumber(latest.track_number || '')
+        setEtag(etag)
+      })
+      .catch((err) => {
+        if (!cancelled) setError(err.message)
+      })
+    return () => {
+      cancelled = true
+    }
+  }, [song.id])
 
   const handleSubmit = async (e) => {
     e.preventDefault()
@@ -20,14 +40,12 @@ function SongEditor({ song, onSongUpdated, onClose }) {
       setUpdating(true)
       setError(null)
 
-      const songData = {
+      const changes = {
         title: title.trim(),
-        artist_id: song.artist_id,
-        album_id: song.album_id,
         track_number: trackNumber ? parseInt(trackNumber) : null,
       }
 
-      const updatedSong = await updateSong(song.id, songData)
+      const updatedSong = await updateSong(song.id, changes, etag)
 
       // Notify parent component
       if (onSongUpdated) {
@@ -1,4 +1,5 @@
 import { useState } from 'react'
+import { errorMessage } from './api'
 import './UploadSong.css'
 
 function UploadSong({ onUploadComplete, onClose }) {
@@ -45,8 +46,7 @@ function UploadSong({ onUploadComplete, onClose }) {
       })
 
       if (!response.ok) {
-        const errorText = await response.text()
-        throw new Error(errorText || 'Upload failed')
+        throw new Error(await errorMessage(response, 'Upload failed'))
       }
 
       const uploadedSong = await response.json()
@@ -2,10 +2,21 @@
 
 const API_BASE = '/api/v1'
 
+// Returns the message of an error response, which the server sends as
+// {"error": {"code", "message", ...}}, or fallback if there is none
+export const errorMessage = async (response, fallback) => {
+  try {
+    const body = await response.json()
+    return body?.error?.message || fallback
+  } catch {
+    return fallback
+  }
+}
+
 // Artists
 export const fetchArtists = async () => {
   const response = await fetch(`${API_BASE}/artists`)
-  if (!response.ok) throw new Error('Failed to fetch artists')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to fetch artists'))
   return response.json()
 }
 
@@ -15,7 +26,7 @@ export const createArtist = async (artistData) => {
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(artistData),
   })
-  if (!response.ok) throw new Error('Failed to create artist')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to create artist'))
   return response.json()
 }
 
@@ -25,7 +36,7 @@ export const updateArtist = async (id, artistData) => {
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(artistData),
   })
-  if (!response.ok) throw new Error('Failed to update artist')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to update artist'))
   return response.json()
 }
 
@@ -33,7 +44,7 @@ export const deleteArtist = async (id) => {
   const response = await fetch(`${API_BASE}/artists/${id}`, {
     method: 'DELETE',
   })
-  if (!response.ok) throw new Error('Failed to delete artist')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to delete artist'))
 }
 
 // Albums
@@ -42,7 +53,7 @@ export const fetchAlbums = async (artistId = null) => {
     ? `${API_BASE}/albums?artist_id=${artistId}`
     : `${API_BASE}/albums`
   const response = await fetch(url)
-  if (!response.ok) throw new Error('Failed to fetch albums')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to fetch albums'))
   return response.json()
 }
 
@@ -52,7 +63,7 @@ export const createAlbum = async (albumData) => {
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(albumData),
   })
-  if (!response.ok) throw new Error('Failed to create album')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to create album'))
   return response.json()
 }
 
@@ -62,7 +73,7 @@ export const updateAlbum = async (id, albumData) => {
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(albumData),
   })
-  if (!response.ok) throw new Error('Failed to update album')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to update album'))
   return response.json()
 }
 
@@ -70,7 +81,7 @@ export const deleteAlbum = async (id) => {
   const response = await fetch(`${API_BASE}/albums/${id}`, {
     method: 'DELETE',
   })
-  if (!response.ok) throw new Error('Failed to delete album')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to delete album'))
 }
 
 // Songs
@@ -82,7 +93,7 @@ export const fetchSongs = async (albumId = null, artistId = null) => {
   if (params.toString()) url += `?${params.toString()}`
 
   const response = await fetch(url)
-  if (!response.ok) throw new Error('Failed to fetch songs')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to fetch songs'))
   return response.json()
 }
 
@@ -91,20 +102,29 @@ export const uploadSong = async (formData) => {
     method: 'POST',
     body: formData,
   })
-  if (!response.ok) {
-    const errorText = await response.text()
-    throw new Error(errorText || 'Upload failed')
-  }
+  if (!response.ok) throw new Error(await errorMessage(response, 'Upload failed'))
   return response.json()
 }
 
-export const updateSong = async (id, songData) => {
+// Returns a song with its ETag, which updates send back so that they fail
+// if someone else changed the song in the meantime
+export const fetchSong = async (id) => {
+  const response = await fetch(`${API_BASE}/songs/${id}`)
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to fetch song'))
+  return { song: await response.json(), etag: response.headers.get('ETag') }
+}
+
+// Changes the fields of a song set in changes, keeping the others; null
+// clears a field. With the etag of fetchSong, fails if the song changed
+export const updateSong = async (id, changes, etag = null) => {
+  const headers = { 'Content-Type': 'application/merge-patch+json' }
+  if (etag) headers['If-Match'] = etag
   const response = await fetch(`${API_BASE}/songs/${id}`, {
-    method: 'PUT',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify(songData),
+    method: 'PATCH',
+    headers,
+    body: JSON.stringify(changes),
   })
-  if (!response.ok) throw new Error('Failed to update song')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to update song'))
   return response.json()
 }
 
@@ -112,5 +132,5 @@ export const deleteSong = async (id) => {
   const response = await fetch(`${API_BASE}/songs/${id}`, {
     method: 'DELETE',
   })
-  if (!response.ok) throw new Error('Failed to delete song')
+  if (!response.ok) throw new Error(await errorMessage(response, 'Failed to delete song'))
 }
@@ -0,0 +1,176 @@
+// Command dbctl manages the server's database. It reads the same
+// environment, and .env file, as the server.
+//
+// Usage:
+//
+//	dbctl migrate up [version]  apply pending migrations, up to version if given
+//	dbctl migrate down [steps]  revert the last steps migrations (default 1)
+//	dbctl migrate status        list migrations and whether they are applied
+//	dbctl backup                back up a SQLite database to S3 now
+//	dbctl backup list           list the backups in S3, newest first
+//	dbctl restore <backup>      replace a SQLite database with a backup, by
+//	                            key, name or "latest"; stop the server first
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"s3-music-streamer/internal/backup"
+	"s3-music-streamer/internal/config"
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/storage"
+)
+
+const usage = `usage:
+  dbctl migrate up [version]
+  dbctl migrate down [steps]
+  dbctl migrate status
+  dbctl backup
+  dbctl backup list
+  dbctl restore <backup>`
+
+func main() {
+	log.SetFlags(0)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+	var err error
+	switch args[0] {
+	case "migrate":
+		if len(args) < 2 {
+			log.Fatal(usage)
+		}
+		err = withDB(cfg, func(db *database.DB) error {
+			return migrate(ctx, db, args[1], args[2:])
+		})
+	case "backup":
+		err = backupCommand(ctx, cfg, args[1:])
+	case "restore":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		err = restore(ctx, cfg, args[1])
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func withDB(cfg *config.Config, f func(db *database.DB) error) error {
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	return f(db)
+}
+
+func migrate(ctx context.Context, db *database.DB, command string, args []string) error {
+	var n int
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments\n%s", usage)
+	}
+	if len(args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+			return fmt.Errorf("invalid number %q", args[0])
+		}
+	}
+
+	switch command {
+	case "up":
+		if err := db.MigrateUp(ctx, n); err != nil {
+			return err
+		}
+	case "down":
+		if err := db.MigrateDown(ctx, max(n, 1)); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, usage)
+	}
+	return printStatus(ctx, db)
+}
+
+func printStatus(ctx context.Context, db *database.DB) error {
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range status {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if m.Up == "" {
+			applied += " (unknown to this build)"
+		}
+		fmt.Printf("%04d  %-24s %s\n", m.Version, m.Name, applied)
+	}
+	return nil
+}
+
+func newS3Client(ctx context.Context, cfg *config.Config) (*storage.S3Client, error) {
+	return storage.NewS3Client(ctx, cfg.S3Region, cfg.S3Bucket, cfg.AWSAccessKey, cfg.AWSSecretKey)
+}
+
+func backupCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	s3Client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) == 0:
+		return withDB(cfg, func(db *database.DB) error {
+			b, err := backup.New(db, s3Client, cfg.BackupPrefix, cfg.BackupRetention).Create(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("backed up to %s (%d bytes)\n", b.Key, b.Size)
+			return nil
+		})
+	case len(args) == 1 && args[0] == "list":
+		backups, err := backup.List(ctx, s3Client, cfg.BackupPrefix)
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Printf("%s  %10d  %s\n", b.CreatedAt.Format("2006-01-02 15:04:05"), b.Size, b.Key)
+		}
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+func restore(ctx context.Context, cfg *config.Config, key string) error {
+	path, ok := database.SQLitePath(cfg.DatabaseURL)
+	if !ok {
+		return errors.New("only SQLite databases are restored by dbctl, restore PostgreSQL with pg_restore")
+	}
+	s3Client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	b, err := backup.Restore(ctx, s3Client, cfg.BackupPrefix, key, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s, previous database kept as %s.before-restore\n",
+		path, b.Key, path)
+	return nil
+}
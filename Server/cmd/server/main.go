@@ -6,37 +6,87 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"s3-music-streamer/internal/audio"
+	"s3-music-streamer/internal/backup"
 	"s3-music-streamer/internal/config"
 	"s3-music-streamer/internal/database"
 	"s3-music-streamer/internal/handlers"
+	"s3-music-streamer/internal/jobs"
+	"s3-music-streamer/internal/scrobble"
 	"s3-music-streamer/internal/storage"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// shutdownTimeout is how long requests in progress, such as streams, get to
+// finish at shutdown.
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	cfg := config.Load()
 
-	db, err := database.New(cfg.DatabasePath)
+	db, err := database.New(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
 
-	if err := db.InitSchema(); err != nil {
-		log.Fatalf("Failed to initialize schema: %v", err)
+	// Stop on SIGINT or SIGTERM, letting requests and jobs in progress finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := db.Migrate(ctx); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
-	ctx := context.Background()
 	s3Client, err := storage.NewS3Client(ctx, cfg.S3Region, cfg.S3Bucket, cfg.AWSAccessKey, cfg.AWSSecretKey)
 	if err != nil {
 		log.Fatalf("Failed to create S3 client: %v", err)
 	}
 
-	handler := handlers.New(db, s3Client)
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	services := []scrobble.Service{scrobble.NewListenBrainz(cfg.ListenBrainzURL, httpClient)}
+	if cfg.LastFMAPIKey != "" {
+		services = append(services, scrobble.NewLastFM(cfg.LastFMURL, cfg.LastFMAPIKey, cfg.LastFMSecret, httpClient))
+	}
+	tokens, err := scrobble.NewTokenCipher(cfg.ScrobbleTokenKey)
+	if err != nil {
+		log.Fatalf("Invalid SCROBBLE_TOKEN_KEY: %v", err)
+	}
+	if tokens == nil {
+		log.Println("SCROBBLE_TOKEN_KEY is not set, scrobbling tokens are stored unencrypted")
+	}
+	scrobbler := scrobble.NewForwarder(db, tokens, services...)
+	if err := scrobbler.SealTokens(ctx); err != nil {
+		log.Fatalf("Failed to encrypt scrobbling tokens: %v", err)
+	}
+	go scrobbler.Run(ctx)
+
+	queue := jobs.NewQueue(db, cfg.JobWorkers)
+	handler := handlers.New(db, s3Client, scrobbler, audio.NewDecoder(cfg.FFmpegPath), queue, handlers.Options{
+		RequireIfMatch:     cfg.RequireIfMatch,
+		StreamCacheControl: cfg.StreamCacheControl,
+	})
+	jobsDone := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(jobsDone)
+	}()
+
+	if cfg.BackupInterval > 0 {
+		if db.Dialect == database.SQLite {
+			backups := backup.New(db, s3Client, cfg.BackupPrefix, cfg.BackupRetention)
+			go backups.Run(ctx, cfg.BackupInterval)
+		} else {
+			log.Println("Backups are only made of SQLite databases, back up PostgreSQL with pg_dump")
+		}
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -49,19 +99,42 @@ func main() {
 	})
 
 	r.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(handler.NotFound)
+		r.MethodNotAllowed(handler.MethodNotAllowed)
+		r.Use(handlers.Conditional)
+
 		// Artist routes
 		r.Get("/artists", handler.ListArtists)
 		r.Post("/artists", handler.CreateArtist)
 		r.Get("/artists/{id}", handler.GetArtist)
 		r.Put("/artists/{id}", handler.UpdateArtist)
+		r.Patch("/artists/{id}", handler.PatchArtist)
 		r.Delete("/artists/{id}", handler.DeleteArtist)
+		r.Put("/artists/{id}/star", handler.Star("artist"))
+		r.Delete("/artists/{id}/star", handler.Star("artist"))
+		r.Put("/artists/{id}/rating", handler.Rate("artist"))
+		r.Delete("/artists/{id}/rating", handler.Rate("artist"))
+		r.Put("/artists/{id}/image", handler.UploadArtistImage)
+		r.Get("/artists/{id}/image", handler.GetArtistImage)
+		r.Delete("/artists/{id}/image", handler.DeleteArtistImage)
+		r.Put("/artists/{id}/tags", handler.SetTags("artist"))
 
 		// Album routes
 		r.Get("/albums", handler.ListAlbums)
 		r.Post("/albums", handler.CreateAlbum)
 		r.Get("/albums/{id}", handler.GetAlbum)
 		r.Put("/albums/{id}", handler.UpdateAlbum)
+		r.Patch("/albums/{id}", handler.PatchAlbum)
 		r.Delete("/albums/{id}", handler.DeleteAlbum)
+		r.Put("/albums/{id}/star", handler.Star("album"))
+		r.Delete("/albums/{id}/star", handler.Star("album"))
+		r.Put("/albums/{id}/rating", handler.Rate("album"))
+		r.Delete("/albums/{id}/rating", handler.Rate("album"))
+		r.Put("/albums/{id}/cover", handler.UploadAlbumCover)
+		r.Get("/albums/{id}/cover", handler.GetAlbumCover)
+		r.Delete("/albums/{id}/cover", handler.DeleteAlbumCover)
+		r.Put("/albums/{id}/genres", handler.SetGenres("album"))
+		r.Put("/albums/{id}/tags", handler.SetTags("album"))
 
 		// Song routes
 		r.Get("/songs", handler.ListSongs)
@@ -69,8 +142,69 @@ func main() {
 		r.Post("/songs/upload", handler.UploadSong)
 		r.Get("/songs/{id}", handler.GetSong)
 		r.Put("/songs/{id}", handler.UpdateSong)
+		r.Patch("/songs/{id}", handler.PatchSong)
 		r.Delete("/songs/{id}", handler.DeleteSong)
 		r.Get("/songs/{id}/stream", handler.StreamSong)
+		r.Post("/songs/{id}/analyze", handler.AnalyzeSong)
+		r.Get("/songs/{id}/waveform", handler.GetWaveform)
+		r.Post("/songs/{id}/plays", handler.RecordPlay)
+		r.Put("/songs/{id}/star", handler.Star("song"))
+		r.Delete("/songs/{id}/star", handler.Star("song"))
+		r.Put("/songs/{id}/rating", handler.Rate("song"))
+		r.Delete("/songs/{id}/rating", handler.Rate("song"))
+		r.Put("/songs/{id}/genres", handler.SetGenres("song"))
+		r.Put("/songs/{id}/tags", handler.SetTags("song"))
+		r.Get("/songs/{id}/lyrics", handler.GetLyrics)
+		r.Put("/songs/{id}/lyrics", handler.PutLyrics)
+		r.Delete("/songs/{id}/lyrics", handler.DeleteLyrics)
+
+		// Search routes
+		r.Get("/search", handler.Search)
+
+		// Genre and tag routes
+		r.Get("/genres", handler.ListGenres)
+		r.Post("/genres", handler.CreateGenre)
+		r.Get("/genres/{id}", handler.GetGenre)
+		r.Put("/genres/{id}", handler.UpdateGenre)
+		r.Delete("/genres/{id}", handler.DeleteGenre)
+		r.Get("/tags", handler.ListTags)
+
+		// Playlist routes
+		r.Get("/playlists", handler.ListPlaylists)
+		r.Post("/playlists", handler.CreatePlaylist)
+		r.Get("/playlists/{id}", handler.GetPlaylist)
+		r.Put("/playlists/{id}", handler.UpdatePlaylist)
+		r.Delete("/playlists/{id}", handler.DeletePlaylist)
+
+		// Smart playlist routes
+		r.Get("/smart-playlists", handler.ListSmartPlaylists)
+		r.Post("/smart-playlists", handler.CreateSmartPlaylist)
+		r.Get("/smart-playlists/{id}", handler.GetSmartPlaylist)
+		r.Put("/smart-playlists/{id}", handler.UpdateSmartPlaylist)
+		r.Delete("/smart-playlists/{id}", handler.DeleteSmartPlaylist)
+		r.Post("/smart-playlists/{id}/snapshot", handler.SnapshotSmartPlaylist)
+
+		// Listening history routes
+		r.Get("/users/{user}/plays", handler.ListUserPlays)
+		r.Get("/users/{user}/now-playing", handler.GetNowPlaying)
+
+		// Listening statistics routes
+		r.Get("/users/{user}/stats/top/{kind}", handler.TopItems)
+		r.Get("/users/{user}/stats/listening-time", handler.GetListeningTime)
+		r.Get("/users/{user}/stats/streaks", handler.GetStreaks)
+		r.Get("/users/{user}/wrapped/{year}", handler.GetYearInReview)
+
+		// Scrobble forwarding routes
+		r.Get("/users/{user}/integrations", handler.ListIntegrations)
+		r.Put("/users/{user}/integrations/{service}", handler.PutIntegration)
+		r.Delete("/users/{user}/integrations/{service}", handler.DeleteIntegration)
+		r.Post("/users/{user}/integrations/{service}/retry", handler.RetryIntegration)
+
+		// Admin routes
+		r.Get("/admin/jobs", handler.ListJobs)
+		r.Get("/admin/jobs/{id}", handler.GetJob)
+		r.Post("/admin/jobs/{id}/retry", handler.RetryJob)
+		r.Post("/admin/jobs/{id}/cancel", handler.CancelJob)
 	})
 
 	// Serve static files from Client/dist
@@ -96,8 +230,20 @@ func main() {
 	}
 
 	addr := fmt.Sprintf(":%s", cfg.ServerPort)
-	log.Printf("Starting server on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	server := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		log.Printf("Starting server on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
 	}
+	<-jobsDone
 }
@@ -0,0 +1,173 @@
+// Package audio decodes uploaded songs to PCM for analysis and transcodes
+// them for streaming. MP3 files are decoded in Go; other formats, and
+// transcoding, need ffmpeg.
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// ErrUnsupported is returned when a file can't be decoded without ffmpeg.
+var ErrUnsupported = errors.New("unsupported audio format")
+
+// ffmpegSampleRate is the rate ffmpeg resamples other formats to.
+const ffmpegSampleRate = 48000
+
+// Decoder decodes and transcodes audio, using ffmpeg when it is installed.
+type Decoder struct {
+	ffmpeg string // path of the ffmpeg binary, empty when unavailable
+}
+
+// NewDecoder returns a decoder using the ffmpeg binary at ffmpegPath, which
+// is looked up in PATH if it isn't a path. Without ffmpeg, only MP3 files
+// can be decoded and nothing can be transcoded.
+func NewDecoder(ffmpegPath string) *Decoder {
+	d := &Decoder{}
+	if ffmpegPath == "" {
+		return d
+	}
+	path, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		log.Printf("ffmpeg not found, only MP3 files will be analyzed: %v", err)
+		return d
+	}
+	d.ffmpeg = path
+	return d
+}
+
+// CanTranscode reports whether ffmpeg is available.
+func (d *Decoder) CanTranscode() bool {
+	return d.ffmpeg != ""
+}
+
+// Stream is decoded audio: 16-bit little-endian stereo samples.
+type Stream struct {
+	io.Reader
+	SampleRate int
+	close      func() error
+}
+
+// Close releases the decoder, waiting for ffmpeg to exit.
+func (s *Stream) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// Decode starts decoding the audio file in r.
+func (d *Decoder) Decode(ctx context.Context, r io.Reader) (*Stream, error) {
+	br := bufio.NewReader(r)
+	if isMP3(br) {
+		decoder, err := mp3.NewDecoder(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode MP3: %w", err)
+		}
+		return &Stream{Reader: decoder, SampleRate: decoder.SampleRate()}, nil
+	}
+
+	if d.ffmpeg == "" {
+		return nil, ErrUnsupported
+	}
+	cmd := exec.CommandContext(ctx, d.ffmpeg,
+		"-hide_banner", "-loglevel", "error", "-i", "pipe:0",
+		"-f", "s16le", "-acodec", "pcm_s16le", "-ac", "2", "-ar", strconv.Itoa(ffmpegSampleRate), "pipe:1",
+	)
+	cmd.Stdin = br
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return &Stream{
+		Reader:     stdout,
+		SampleRate: ffmpegSampleRate,
+		close: func() error {
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Transcode writes the audio file in r to w as MP3, with its volume changed
+// by gain decibels.
+func (d *Decoder) Transcode(ctx context.Context, r io.Reader, w io.Writer, gain float64) error {
+	if d.ffmpeg == "" {
+		return ErrUnsupported
+	}
+	cmd := exec.CommandContext(ctx, d.ffmpeg,
+		"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-map", "0:a",
+		"-af", fmt.Sprintf("volume=%.2fdB", gain), "-f", "mp3", "-b:a", "192k", "pipe:1",
+	)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// isMP3 sniffs an ID3v2 tag or an MPEG audio frame header.
+func isMP3(r *bufio.Reader) bool {
+	header, _ := r.Peek(3)
+	if bytes.Equal(header, []byte("ID3")) {
+		return true
+	}
+	// Frame sync and MPEG layer III
+	return len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0 && header[1]&0x06 == 0x02
+}
+
+// Frame is a stereo sample scaled to [-1, 1].
+type Frame [2]float64
+
+// Sink consumes decoded audio, such as a loudness meter.
+type Sink interface {
+	Write(frames []Frame)
+}
+
+// Process reads all of s, passing its frames to each sink, and returns how
+// many frames there were.
+func Process(s *Stream, sinks ...Sink) (int64, error) {
+	const framesPerRead = 4096
+	buf := make([]byte, framesPerRead*4)
+	frames := make([]Frame, framesPerRead)
+	var total int64
+	for {
+		n, err := io.ReadFull(s, buf)
+		if count := n / 4; count > 0 {
+			for i := range count {
+				frames[i][0] = float64(int16(binary.LittleEndian.Uint16(buf[4*i:]))) / 32768
+				frames[i][1] = float64(int16(binary.LittleEndian.Uint16(buf[4*i+2:]))) / 32768
+			}
+			for _, sink := range sinks {
+				sink.Write(frames[:count])
+			}
+			total += int64(count)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package audio
+
+import (
+	"math"
+)
+
+// Loudness is the result of an EBU R128 analysis.
+type Loudness struct {
+	Integrated float64 // LUFS
+	TruePeak   float64 // linear, 1 is digital full scale
+}
+
+// ReplayGain 2.0 targets -18 LUFS.
+const ReferenceLoudness = -18.0
+
+// Gain returns the gain in decibels bringing audio of the given integrated
+// loudness to the ReplayGain reference.
+func Gain(integrated float64) float64 {
+	return ReferenceLoudness - integrated
+}
+
+const (
+	absoluteGate = -70.0 // LUFS
+	relativeGate = -10.0 // LU below the ungated loudness
+
+	// Loudness is measured over 400 ms blocks overlapping by 75%, summed
+	// from 100 ms sub-blocks.
+	subBlocksPerBlock = 4
+	subBlockDuration  = 0.1 // seconds
+)
+
+// LoudnessMeter measures the integrated loudness and true peak of stereo
+// audio as specified by ITU-R BS.1770-4 and EBU R128.
+type LoudnessMeter struct {
+	filters  [2]kWeighting
+	peaks    [2]truePeakMeter
+	subBlock int       // frames per sub-block
+	frames   int       // frames in the current sub-block
+	energy   float64   // of the current sub-block, summed over channels
+	recent   []float64 // energies of the last sub-blocks
+	blocks   []float64 // mean square of each 400 ms block
+	truePeak float64
+}
+
+func NewLoudnessMeter(sampleRate int) *LoudnessMeter {
+	m := &LoudnessMeter{subBlock: int(math.Round(float64(sampleRate) * subBlockDuration))}
+	for channel := range m.filters {
+		m.filters[channel] = newKWeighting(float64(sampleRate))
+	}
+	return m
+}
+
+func (m *LoudnessMeter) Write(frames []Frame) {
+	for _, frame := range frames {
+		for channel, sample := range frame {
+			m.truePeak = max(m.truePeak, m.peaks[channel].process(sample))
+			filtered := m.filters[channel].process(sample)
+			m.energy += filtered * filtered
+		}
+
+		m.frames++
+		if m.frames == m.subBlock {
+			m.recent = append(m.recent, m.energy)
+			if len(m.recent) > subBlocksPerBlock {
+				m.recent = m.recent[1:]
+			}
+			if len(m.recent) == subBlocksPerBlock {
+				var sum float64
+				for _, energy := range m.recent {
+					sum += energy
+				}
+				m.blocks = append(m.blocks, sum/float64(subBlocksPerBlock*m.subBlock))
+			}
+			m.frames, m.energy = 0, 0
+		}
+	}
+}
+
+// Result returns the measurements of everything written so far. Silence
+// and audio too short for a single block measure as -70 LUFS.
+func (m *LoudnessMeter) Result() Loudness {
+	result := Loudness{Integrated: absoluteGate, TruePeak: m.truePeak}
+
+	var gated []float64
+	for _, block := range m.blocks {
+		if blockLoudness(block) > absoluteGate {
+			gated = append(gated, block)
+		}
+	}
+	if len(gated) == 0 {
+		return result
+	}
+
+	threshold := blockLoudness(mean(gated)) + relativeGate
+	var loud []float64
+	for _, block := range gated {
+		if blockLoudness(block) > threshold {
+			loud = append(loud, block)
+		}
+	}
+	if len(loud) > 0 {
+		result.Integrated = blockLoudness(mean(loud))
+	}
+	return result
+}
+
+func blockLoudness(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting is the BS.1770 K-weighting filter: a high shelf modelling the
+// head, then a high-pass filter. The coefficients are derived for any sample
+// rate from the analog prototypes, as in libebur128.
+type kWeighting struct {
+	shelf, highPass biquad
+}
+
+func newKWeighting(sampleRate float64) kWeighting {
+	const (
+		shelfFrequency = 1681.974450955533
+		shelfGain      = 3.999843853973347
+		shelfQ         = 0.7071752369554196
+		highPassCutoff = 38.13547087602444
+		highPassQ      = 0.5003270373238773
+	)
+
+	k := math.Tan(math.Pi * shelfFrequency / sampleRate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * highPassCutoff / sampleRate)
+	a0 = 1 + k/highPassQ + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+	return kWeighting{shelf: shelf, highPass: highPass}
+}
+
+func (k *kWeighting) process(x float64) float64 {
+	return k.highPass.process(k.shelf.process(x))
+}
+
+// truePeakMeter estimates the peak of the reconstructed signal by 4x
+// oversampling with a windowed sinc interpolator, catching inter-sample
+// peaks that the samples themselves miss.
+type truePeakMeter struct {
+	history [truePeakTaps]float64
+	pos     int
+}
+
+const (
+	oversampling  = 4
+	truePeakTaps  = 12 // input samples per interpolated output
+	truePeakTotal = oversampling * truePeakTaps
+)
+
+// truePeakFilter holds the polyphase coefficients of the interpolator, one
+// row per phase.
+var truePeakFilter = func() [oversampling][truePeakTaps]float64 {
+	var filter [oversampling][truePeakTaps]float64
+	for n := range truePeakTotal {
+		// Lowpass at the original Nyquist frequency, Hann windowed
+		t := float64(n) - float64(truePeakTotal-1)/2
+		sinc := 1.0
+		if t != 0 {
+			x := math.Pi * t / oversampling
+			sinc = math.Sin(x) / x
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(truePeakTotal-1))
+		filter[n%oversampling][n/oversampling] = sinc * window
+	}
+	return filter
+}()
+
+// process takes the next sample and returns the largest magnitude among it
+// and the interpolated values preceding it.
+func (m *truePeakMeter) process(x float64) float64 {
+	m.history[m.pos] = x
+	m.pos = (m.pos + 1) % truePeakTaps
+
+	peak := math.Abs(x)
+	for phase := range oversampling {
+		var y float64
+		for tap := range truePeakTaps {
+			// Newest sample first
+			y += truePeakFilter[phase][tap] * m.history[(m.pos-1-tap+2*truePeakTaps)%truePeakTaps]
+		}
+		peak = max(peak, math.Abs(y))
+	}
+	return peak
+}
@@ -0,0 +1,63 @@
+package audio
+
+import "math"
+
+// framesPerPeak is the resolution of the finest waveform kept while
+// decoding, about 6 ms at 44.1 kHz. Coarser waveforms are derived from it.
+const framesPerPeak = 256
+
+// WaveformBuilder records the minimum and maximum sample of every
+// framesPerPeak frames, mixed down to mono.
+type WaveformBuilder struct {
+	sampleRate int
+	mins, maxs []float64
+	frames     int // in the current peak
+}
+
+func NewWaveformBuilder(sampleRate int) *WaveformBuilder {
+	return &WaveformBuilder{sampleRate: sampleRate}
+}
+
+func (b *WaveformBuilder) Write(frames []Frame) {
+	for _, frame := range frames {
+		sample := (frame[0] + frame[1]) / 2
+		if b.frames == 0 {
+			b.mins = append(b.mins, sample)
+			b.maxs = append(b.maxs, sample)
+		} else {
+			last := len(b.mins) - 1
+			b.mins[last] = min(b.mins[last], sample)
+			b.maxs[last] = max(b.maxs[last], sample)
+		}
+		b.frames = (b.frames + 1) % framesPerPeak
+	}
+}
+
+// Waveform is a downsampled waveform: a minimum and maximum per pixel,
+// scaled to signed 8 bits.
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Mins, Maxs      []int8
+}
+
+// Waveform returns the waveform at a resolution of about pixels peaks. It
+// has fewer for audio shorter than pixels times framesPerPeak frames.
+func (b *WaveformBuilder) Waveform(pixels int) Waveform {
+	group := max(int(math.Ceil(float64(len(b.mins))/float64(pixels))), 1)
+	waveform := Waveform{SampleRate: b.sampleRate, SamplesPerPixel: group * framesPerPeak}
+	for start := 0; start < len(b.mins); start += group {
+		end := min(start+group, len(b.mins))
+		lo, hi := b.mins[start], b.maxs[start]
+		for i := start + 1; i < end; i++ {
+			lo, hi = min(lo, b.mins[i]), max(hi, b.maxs[i])
+		}
+		waveform.Mins = append(waveform.Mins, toInt8(lo))
+		waveform.Maxs = append(waveform.Maxs, toInt8(hi))
+	}
+	return waveform
+}
+
+func toInt8(sample float64) int8 {
+	return int8(max(min(math.Round(sample*128), 127), -128))
+}
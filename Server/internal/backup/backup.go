@@ -0,0 +1,310 @@
+// Package backup copies the SQLite database to S3 while the server runs,
+// keeping the most recent copies, and restores the database from them.
+//
+// Backups are gzipped snapshots named <prefix>music-<time>.db.gz, with the
+// time in UTC, so that they list in order of age.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/storage"
+)
+
+const (
+	timeLayout = "20060102T150405Z"
+
+	// retryDelay is how long Run waits after a failed backup before trying
+	// again.
+	retryDelay = 10 * time.Minute
+)
+
+// Store is where backups are kept, the media bucket's S3 client.
+type Store interface {
+	ListObjects(ctx context.Context, prefix string) ([]storage.ObjectInfo, error)
+	OpenObject(ctx context.Context, key string) (*storage.Object, error)
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// Backup is a copy of the database in S3.
+type Backup struct {
+	Key       string
+	Size      int64 // compressed
+	CreatedAt time.Time
+}
+
+// Backups makes backups of a database under a prefix of the bucket,
+// deleting all but the latest keep.
+type Backups struct {
+	db     *database.DB
+	s3     Store
+	prefix string
+	keep   int
+}
+
+func New(db *database.DB, s3 Store, prefix string, keep int) *Backups {
+	return &Backups{db: db, s3: s3, prefix: prefix, keep: keep}
+}
+
+// Run backs up the database every interval until ctx is done. The first
+// backup is made once the latest one is interval old, so restarts don't
+// add backups.
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	var wait time.Duration
+	backups, err := List(ctx, b.s3, b.prefix)
+	if err != nil {
+		log.Printf("Failed to list backups: %v", err)
+	} else if len(backups) > 0 {
+		wait = time.Until(backups[0].CreatedAt.Add(interval))
+	}
+
+	for {
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backup, err := b.Create(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to back up database: %v", err)
+			wait = min(retryDelay, interval)
+			continue
+		}
+		log.Printf("Backed up database to %s (%d bytes)", backup.Key, backup.Size)
+		wait = interval
+	}
+}
+
+// Create backs up the database now, then deletes old backups.
+func (b *Backups) Create(ctx context.Context) (*Backup, error) {
+	dir, err := os.MkdirTemp("", "music-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	snapshot := filepath.Join(dir, "music.db")
+	if err := b.db.Snapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	compressed, err := compress(snapshot, snapshot+".gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress backup: %w", err)
+	}
+	defer compressed.Close()
+	info, err := compressed.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{
+		Key:       b.prefix + "music-" + createdAt.Format(timeLayout) + ".db.gz",
+		Size:      info.Size(),
+		CreatedAt: createdAt,
+	}
+	if err := b.s3.PutObject(ctx, backup.Key, compressed, "application/gzip"); err != nil {
+		return nil, err
+	}
+
+	// The backup is made either way
+	if err := b.prune(ctx); err != nil {
+		log.Printf("Failed to delete old backups: %v", err)
+	}
+	return backup, nil
+}
+
+// compress gzips the file at src into dst, returning dst open at its
+// start.
+func compress(src, dst string) (*os.File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// prune deletes all but the latest keep backups.
+func (b *Backups) prune(ctx context.Context) error {
+	if b.keep <= 0 {
+		return nil
+	}
+	backups, err := List(ctx, b.s3, b.prefix)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[min(b.keep, len(backups)):] {
+		if err := b.s3.DeleteObject(ctx, backup.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the backups under prefix, newest first. Other objects under
+// prefix are ignored.
+func List(ctx context.Context, s3 Store, prefix string) ([]Backup, error) {
+	objects, err := s3.ListObjects(ctx, prefix+"music-")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, object := range objects {
+		if backup, ok := parse(prefix, object.Key); ok {
+			backup.Size = object.Size
+			backups = append(backups, backup)
+		}
+	}
+	slices.SortFunc(backups, func(a, b Backup) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return backups, nil
+}
+
+// Restore replaces the SQLite database at path with the backup at key, a
+// key under prefix or its name within it, or with the latest backup if key
+// is "latest". The server must not be running.
+//
+// The backup is checked before it replaces the database, which is kept
+// alongside it with the suffix .before-restore.
+func Restore(ctx context.Context, s3 Store, prefix, key, path string) (*Backup, error) {
+	backup, err := find(ctx, s3, prefix, key)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := s3.OpenObject(ctx, backup.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("backup %s not found", backup.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	// Decompress next to the database, so that it can be renamed into place
+	restored, err := os.CreateTemp(filepath.Dir(path), ".restore-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(restored.Name())
+	if err := decompress(restored, object.Body); err != nil {
+		restored.Close()
+		return nil, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	if err := restored.Close(); err != nil {
+		return nil, err
+	}
+	if err := check(ctx, restored.Name()); err != nil {
+		return nil, err
+	}
+
+	// The -wal and -shm files belong with the database they were written for
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(path+suffix, path+".before-restore"+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to keep current database: %w", err)
+		}
+	}
+	if err := os.Rename(restored.Name(), path); err != nil {
+		return nil, err
+	}
+	backup.Size = object.ContentLength
+	return backup, nil
+}
+
+// find returns the backup named by key, as described by Restore.
+func find(ctx context.Context, s3 Store, prefix, key string) (*Backup, error) {
+	if key == "latest" {
+		backups, err := List(ctx, s3, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(backups) == 0 {
+			return nil, fmt.Errorf("no backups under %q", prefix)
+		}
+		return &backups[0], nil
+	}
+	if !strings.HasPrefix(key, prefix) {
+		key = prefix + key
+	}
+	backup, ok := parse(prefix, key)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a backup", key)
+	}
+	return &backup, nil
+}
+
+// parse returns the backup at key, if it is named like one under prefix.
+func parse(prefix, key string) (Backup, bool) {
+	name, ok := strings.CutPrefix(key, prefix+"music-")
+	if !ok {
+		return Backup{}, false
+	}
+	name, ok = strings.CutSuffix(name, ".db.gz")
+	if !ok {
+		return Backup{}, false
+	}
+	createdAt, err := time.Parse(timeLayout, name)
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Key: key, CreatedAt: createdAt}, true
+}
+
+func decompress(dst io.Writer, src io.Reader) error {
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = io.Copy(dst, zr)
+	return err
+}
+
+// check opens the SQLite database at path and checks its integrity.
+func check(ctx context.Context, path string) error {
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+	return db.CheckIntegrity(ctx)
+}
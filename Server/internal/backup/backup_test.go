@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+	"s3-music-streamer/internal/storage"
+)
+
+// fakeStore keeps objects in memory.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeStore(keys ...string) *fakeStore {
+	s := &fakeStore{objects: make(map[string][]byte)}
+	for _, key := range keys {
+		s.objects[key] = []byte(key)
+	}
+	return s
+}
+
+func (s *fakeStore) ListObjects(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []storage.ObjectInfo
+	for key, data := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	slices.SortFunc(objects, func(a, b storage.ObjectInfo) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+func (s *fakeStore) OpenObject(ctx context.Context, key string) (*storage.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &storage.Object{Body: io.NopCloser(bytes.NewReader(data)), ContentLength: int64(len(data))}, nil
+}
+
+func (s *fakeStore) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *fakeStore) DeleteObject(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *fakeStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		key  string
+		want time.Time
+		ok   bool
+	}{
+		{"backups/music-20240501T200000Z.db.gz", time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC), true},
+		{"backups/music-20240501T200000Z.db", time.Time{}, false},
+		{"backups/music-yesterday.db.gz", time.Time{}, false},
+		{"backups/music-2024-05-01.db.gz", time.Time{}, false},
+		{"other/music-20240501T200000Z.db.gz", time.Time{}, false},
+		{"backups/nested/music-20240501T200000Z.db.gz", time.Time{}, false},
+	}
+	for _, test := range tests {
+		backup, ok := parse("backups/", test.key)
+		if ok != test.ok || !backup.CreatedAt.Equal(test.want) || (ok && backup.Key != test.key) {
+			t.Errorf("parse(%q) = %+v, %t", test.key, backup, ok)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	s3 := newFakeStore(
+		"backups/music-20240502T000000Z.db.gz",
+		"backups/music-20231231T235959Z.db.gz",
+		"backups/music-20240501T120000Z.db.gz",
+		"backups/music-notes.txt",
+		"backups/readme.txt",
+		"songs/1/song.mp3",
+	)
+	backups, err := List(context.Background(), s3, "backups/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Newest first
+	want := []string{
+		"backups/music-20240502T000000Z.db.gz",
+		"backups/music-20240501T120000Z.db.gz",
+		"backups/music-20231231T235959Z.db.gz",
+	}
+	if len(backups) != len(want) {
+		t.Fatalf("listed %+v", backups)
+	}
+	for i, backup := range backups {
+		if backup.Key != want[i] || backup.Size != int64(len(want[i])) {
+			t.Errorf("backup %d is %+v, want %s", i, backup, want[i])
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	keys := []string{
+		"backups/music-20240501T000000Z.db.gz",
+		"backups/music-20240502T000000Z.db.gz",
+		"backups/music-20240503T000000Z.db.gz",
+		"backups/music-20240504T000000Z.db.gz",
+		"backups/readme.txt",
+	}
+	tests := []struct {
+		keep int
+		want []string
+	}{
+		{2, []string{keys[2], keys[3], keys[4]}},
+		{10, keys},
+		{0, keys},
+	}
+	for _, test := range tests {
+		s3 := newFakeStore(keys...)
+		b := New(nil, s3, "backups/", test.keep)
+		if err := b.prune(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := s3.keys(); !slices.Equal(got, test.want) {
+			t.Errorf("keeping %d left %v, want %v", test.keep, got, test.want)
+		}
+	}
+}
+
+// openDatabase returns a SQLite database at path holding a song.
+func openDatabase(t *testing.T, path, title string) *database.DB {
+	t.Helper()
+	db, err := database.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO songs (title) VALUES (?)", title); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func songTitle(t *testing.T, path string) string {
+	t.Helper()
+	db, err := database.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var title string
+	if err := db.QueryRow("SELECT title FROM songs").Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	return title
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeStore("backups/music-20200101T000000Z.db.gz", "backups/music-20200102T000000Z.db.gz")
+	dir := t.TempDir()
+	db := openDatabase(t, filepath.Join(dir, "backed-up.db"), "Sinnerman")
+
+	// Making a backup deletes the oldest beyond retention
+	created, err := New(db, s3, "backups/", 2).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, "backups/music-") || created.Size == 0 {
+		t.Errorf("created %+v", created)
+	}
+	if got := s3.keys(); len(got) != 2 || got[0] != "backups/music-20200102T000000Z.db.gz" || got[1] != created.Key {
+		t.Errorf("backups are %v", got)
+	}
+
+	path := filepath.Join(dir, "music.db")
+	openDatabase(t, path, "Feeling Good").Close()
+	name := strings.TrimPrefix(created.Key, "backups/")
+	for _, key := range []string{created.Key, name, "latest"} {
+		restored, err := Restore(ctx, s3, "backups/", key, path)
+		if err != nil {
+			t.Fatalf("restoring %s: %v", key, err)
+		}
+		if restored.Key != created.Key {
+			t.Errorf("restoring %s restored %s", key, restored.Key)
+		}
+		if got := songTitle(t, path); got != "Sinnerman" {
+			t.Errorf("restored database holds %q", got)
+		}
+	}
+	// The database replaced by the first restore was kept, and then the
+	// restored one
+	if got := songTitle(t, path+".before-restore"); got != "Sinnerman" {
+		t.Errorf("kept database holds %q", got)
+	}
+}
+
+func TestRestoreRefusesBadBackups(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := dbtest.OpenMigrated(t, database.SQLite).Snapshot(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite every page but the header's
+	corrupt := slices.Clone(data)
+	for i := 4096; i < len(corrupt); i++ {
+		corrupt[i] = byte(i * 7)
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	s3 := newFakeStore()
+	s3.objects["backups/music-20240501T000000Z.db.gz"] = gzipped(corrupt)
+	s3.objects["backups/music-20240502T000000Z.db.gz"] = []byte("not gzipped")
+	s3.objects["backups/music-20240503T000000Z.db.gz"] = gzipped([]byte("not a database"))
+
+	path := filepath.Join(dir, "music.db")
+	openDatabase(t, path, "Sinnerman").Close()
+	for _, key := range []string{
+		"music-20240501T000000Z.db.gz",
+		"music-20240502T000000Z.db.gz",
+		"music-20240503T000000Z.db.gz",
+		"music-20240504T000000Z.db.gz",
+		"songs/1/song.mp3",
+	} {
+		if _, err := Restore(ctx, s3, "backups/", key, path); err == nil {
+			t.Errorf("%s was restored", key)
+		}
+	}
+
+	// The current database is left alone, without stray files
+	if got := songTitle(t, path); got != "Sinnerman" {
+		t.Errorf("database holds %q", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "restore") {
+			t.Errorf("%s was left behind", entry.Name())
+		}
+	}
+
+	if _, err := Restore(ctx, newFakeStore(), "backups/", "latest", path); err == nil {
+		t.Error("restoring the latest of no backups succeeded")
+	}
+}
@@ -3,17 +3,36 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DatabasePath string
+	DatabaseURL  string // postgres:// URL, or SQLite file path
 	S3Bucket     string
 	S3Region     string
 	ServerPort   string
 	AWSAccessKey string
 	AWSSecretKey string
+
+	ListenBrainzURL  string
+	LastFMURL        string
+	LastFMAPIKey     string
+	LastFMSecret     string
+	ScrobbleTokenKey string // Base64 AES key encrypting scrobbling tokens in the database, optional
+
+	FFmpegPath string // For decoding non-MP3 files and transcoding, optional
+
+	JobWorkers int // Background jobs run at the same time
+
+	BackupInterval  time.Duration // Between backups of a SQLite database to S3, 0 (the default) to disable
+	BackupPrefix    string        // Key prefix of backups in the bucket
+	BackupRetention int           // Backups kept, 0 to keep all
+
+	RequireIfMatch     bool   // Refuse writes to songs, albums and artists without an If-Match ETag
+	StreamCacheControl string // Cache-Control header of audio streams
 }
 
 func Load() *Config {
@@ -32,12 +51,29 @@ func Load() *Config {
 	}
 
 	return &Config{
-		DatabasePath: getEnv("DATABASE_PATH", "./music.db"),
+		DatabaseURL:  getEnv("DATABASE_URL", getEnv("DATABASE_PATH", "./music.db")),
 		S3Bucket:     getEnv("S3_BUCKET", ""),
 		S3Region:     getEnv("S3_REGION", "us-east-1"),
 		ServerPort:   getEnv("SERVER_PORT", "8080"),
 		AWSAccessKey: getEnv("AWS_ACCESS_KEY_ID", ""),
 		AWSSecretKey: getEnv("AWS_SECRET_ACCESS_KEY", ""),
+
+		ListenBrainzURL:  getEnv("LISTENBRAINZ_API_URL", "https://api.listenbrainz.org"),
+		LastFMURL:        getEnv("LASTFM_API_URL", "https://ws.audioscrobbler.com/2.0/"),
+		LastFMAPIKey:     getEnv("LASTFM_API_KEY", ""),
+		LastFMSecret:     getEnv("LASTFM_API_SECRET", ""),
+		ScrobbleTokenKey: getEnv("SCROBBLE_TOKEN_KEY", ""),
+
+		FFmpegPath: getEnv("FFMPEG_PATH", "ffmpeg"),
+
+		JobWorkers: getEnvInt("JOB_WORKERS", 2),
+
+		BackupInterval:  getEnvDuration("BACKUP_INTERVAL", 0),
+		BackupPrefix:    getEnv("BACKUP_PREFIX", "backups/"),
+		BackupRetention: getEnvInt("BACKUP_RETENTION", 14),
+
+		RequireIfMatch:     getEnvBool("REQUIRE_IF_MATCH", false),
+		StreamCacheControl: getEnv("STREAM_CACHE_CONTROL", "private, no-cache"),
 	}
 }
 
@@ -47,3 +83,42 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -1,76 +1,267 @@
+// Package database opens the server's database, SQLite or PostgreSQL, and
+// migrates its schema.
+//
+// Queries are written once, in the SQL both databases understand, with ?
+// placeholders. DB and Tx rewrite them for PostgreSQL, which numbers its
+// placeholders, and pass booleans as the 0 and 1 SQLite stores. What can't be
+// written portably, such as date arithmetic, goes through Dialect.
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"unicode"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Dialect identifies the database behind a DB.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DB is the server's database. With SQLite, writes go through a single
+// connection, since SQLite allows one writer at a time and would otherwise
+// fail concurrent ones with "database is locked", while reads share a pool
+// of read-only connections. PostgreSQL uses one pool for both.
 type DB struct {
-	*sql.DB
+	*sql.DB // reads
+	writer  *sql.DB
+	Dialect Dialect
 }
 
-func New(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// sqliteReaders is the most read connections open at once on SQLite.
+const sqliteReaders = 8
+
+// sqliteParams configures every SQLite connection: write-ahead logging, so
+// that reads don't block on the writer, enforced foreign keys, so that the
+// ON DELETE clauses of the schema apply, and waiting up to 5s for a lock
+// instead of failing at once.
+const sqliteParams = "_journal_mode=WAL&_synchronous=NORMAL&_foreign_keys=on&_busy_timeout=5000"
+
+// SQLitePath returns the path of the SQLite file at url, unless url is a
+// PostgreSQL URL.
+func SQLitePath(url string) (string, bool) {
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return "", false
+	}
+	return strings.TrimPrefix(url, "sqlite://"), true
+}
+
+// New opens the database at url: a postgres:// or postgresql:// URL, or
+// else the path of a SQLite file, optionally prefixed with sqlite://.
+func New(url string) (*DB, error) {
+	if _, ok := SQLitePath(url); !ok {
+		config, err := pgx.ParseConfig(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database URL: %w", err)
+		}
+		// Timestamps are stored in UTC, including CURRENT_TIMESTAMP defaults
+		config.RuntimeParams["timezone"] = "UTC"
+		db := stdlib.OpenDB(*config)
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database: %w", err)
+		}
+		return &DB{DB: db, writer: db, Dialect: Postgres}, nil
+	}
+
+	path, _ := SQLitePath(url)
+	// The writer is opened first, to create the file and switch it to WAL.
+	// Its transactions take the write lock as they begin, so that they
+	// wait for another process's instead of failing when they first write.
+	writer, err := openSQLite(path, "_txlock=immediate")
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+
+	reader, err := openSQLite(path, "_query_only=on")
+	if err != nil {
+		writer.Close()
+		return nil, err
 	}
+	reader.SetMaxOpenConns(sqliteReaders)
+	reader.SetMaxIdleConns(sqliteReaders)
 
+	db := &DB{DB: reader, writer: writer, Dialect: SQLite}
+	if err := db.checkForeignKeys(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openSQLite opens the SQLite file at path with sqliteParams and params.
+func openSQLite(path, params string) (*sql.DB, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", path+separator+sqliteParams+"&"+params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
+	return db, nil
+}
+
+// checkForeignKeys makes sure SQLite enforces foreign keys, which a build of
+// SQLite without them would silently ignore, and logs rows that broke them
+// before they were enforced.
+func (db *DB) checkForeignKeys() error {
+	for _, pool := range []*sql.DB{db.writer, db.DB} {
+		var enabled bool
+		if err := pool.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		if !enabled {
+			return errors.New("SQLite does not enforce foreign keys")
+		}
+	}
+
+	rows, err := db.writer.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	violations := make(map[string]int)
+	for rows.Next() {
+		var table, parent string
+		var rowID, index sql.NullInt64
+		if err := rows.Scan(&table, &rowID, &parent, &index); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		violations[table+" -> "+parent]++
+	}
+	for reference, count := range violations {
+		log.Printf("Warning: %d rows of %s refer to missing rows", count, reference)
+	}
+	return rows.Err()
+}
+
+// Close closes the read pool and the writer.
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.writer != db.DB {
+		if writerErr := db.writer.Close(); err == nil {
+			err = writerErr
+		}
+	}
+	return err
+}
+
+// isRead reports whether query only reads, and can go to the read pool.
+// Statements such as INSERT ... RETURNING are run through Query too, but
+// must go to the writer.
+func isRead(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	if end := strings.IndexFunc(query, func(r rune) bool { return !unicode.IsLetter(r) }); end >= 0 {
+		query = query[:end]
+	}
+	switch strings.ToUpper(query) {
+	case "SELECT", "WITH":
+		return true
+	}
+	return false
+}
+
+// pool returns the pool to run query on.
+func (db *DB) pool(query string) *sql.DB {
+	if isRead(query) {
+		return db.DB
+	}
+	return db.writer
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = db.Dialect.bind(query, args)
+	return db.writer.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	pool := db.pool(query)
+	query, args = db.Dialect.bind(query, args)
+	return pool.QueryContext(ctx, query, args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	pool := db.pool(query)
+	query, args = db.Dialect.bind(query, args)
+	return pool.QueryRowContext(ctx, query, args...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// BeginTx begins a transaction on the writer, or on the read pool if opts
+// makes it read-only.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	pool := db.writer
+	if opts != nil && opts.ReadOnly {
+		pool = db.DB
+	}
+	tx, err := pool.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect}, nil
+}
+
+// Tx is a transaction whose queries are written like those of DB.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = tx.Dialect.bind(query, args)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = tx.Dialect.bind(query, args)
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
 
-	return &DB{db}, nil
-}
-
-func (db *DB) InitSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS artists (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL UNIQUE,
-		bio TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS albums (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		artist_id INTEGER NOT NULL,
-		year INTEGER,
-		cover_art TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
-		UNIQUE(title, artist_id)
-	);
-
-	CREATE TABLE IF NOT EXISTS songs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		artist_id INTEGER,
-		album_id INTEGER,
-		track_number INTEGER,
-		duration INTEGER DEFAULT 0,
-		file_size INTEGER DEFAULT 0,
-		content_type TEXT DEFAULT 'audio/mpeg',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE SET NULL,
-		FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE SET NULL
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_songs_artist_id ON songs(artist_id);
-	CREATE INDEX IF NOT EXISTS idx_songs_album_id ON songs(album_id);
-	CREATE INDEX IF NOT EXISTS idx_songs_title ON songs(title);
-	CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
-	CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name);
-	`
-
-	if _, err := db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	return nil
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = tx.Dialect.bind(query, args)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
 }
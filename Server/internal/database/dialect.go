@@ -0,0 +1,103 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// bind adapts a query and its arguments to the dialect. PostgreSQL numbers
+// its placeholders ($1, $2...) and has no implicit conversion from booleans
+// to the integer columns that hold flags. Queries without arguments, such as
+// migrations, are left as they are.
+func (d Dialect) bind(query string, args []any) (string, []any) {
+	if d != Postgres || len(args) == 0 {
+		return query, args
+	}
+
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case bool:
+			converted[i] = boolInt(arg)
+		case *bool:
+			if arg != nil {
+				converted[i] = boolInt(*arg)
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return rebind(query), converted
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// rebind replaces the ? placeholders of a query with $1, $2... leaving
+// string literals, quoted identifiers and comments alone.
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				// Unterminated, for the database to report
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// DateBucket returns an expression truncating the timestamp expression
+// column to the start of its day, week (starting on Monday) or month, as a
+// YYYY-MM-DD string.
+func (d Dialect) DateBucket(interval, column string) (string, bool) {
+	if d == Postgres {
+		switch interval {
+		case "day", "week", "month":
+			return "to_char(date_trunc('" + interval + "', " + column + "), 'YYYY-MM-DD')", true
+		}
+		return "", false
+	}
+
+	switch interval {
+	case "day":
+		return "date(" + column + ")", true
+	case "week":
+		return "date(" + column + ", 'weekday 0', '-6 days')", true
+	case "month":
+		return "strftime('%Y-%m-01', " + column + ")", true
+	}
+	return "", false
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM songs WHERE id = ?", "SELECT * FROM songs WHERE id = $1"},
+		{"UPDATE songs SET title = ? WHERE id = ? AND version = ?", "UPDATE songs SET title = $1 WHERE id = $2 AND version = $3"},
+		{"SELECT '?', ? FROM t", "SELECT '?', $1 FROM t"},
+		{`SELECT "what?" FROM t WHERE a = ?`, `SELECT "what?" FROM t WHERE a = $1`},
+		{"SELECT 'it''s?', ?", "SELECT 'it''s?', $1"},
+		{"SELECT ? -- why?\nFROM t WHERE b = ?", "SELECT $1 -- why?\nFROM t WHERE b = $2"},
+		{"SELECT ? -- trailing?", "SELECT $1 -- trailing?"},
+		{"SELECT 'unterminated ?", "SELECT 'unterminated ?"},
+		{"SELECT a - ? FROM t", "SELECT a - $1 FROM t"},
+	}
+	for _, test := range tests {
+		if got := rebind(test.query); got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	yes, no := true, false
+	query, args := Postgres.bind("SELECT ? WHERE a = ? AND b = ? AND c = ?", []any{"x", true, &no, (*bool)(nil)})
+	if query != "SELECT $1 WHERE a = $2 AND b = $3 AND c = $4" {
+		t.Errorf("query is %q", query)
+	}
+	if want := []any{"x", 1, 0, nil}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args are %#v, want %#v", args, want)
+	}
+
+	// SQLite takes queries and booleans as they are
+	query, args = SQLite.bind("SELECT ?", []any{&yes})
+	if query != "SELECT ?" || args[0] != &yes {
+		t.Errorf("SQLite bound %q %v", query, args)
+	}
+
+	// Queries without arguments, such as migrations, may hold literal ?
+	if query, _ := Postgres.bind("SELECT '?' AS q -- ?", nil); query != "SELECT '?' AS q -- ?" {
+		t.Errorf("query without arguments became %q", query)
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Kinds of ConstraintError.
+const (
+	Unique     = "unique"
+	ForeignKey = "foreign_key"
+	NotNull    = "not_null"
+	Check      = "check"
+)
+
+// ConstraintError is a write refused by a constraint of the schema, in
+// either dialect.
+type ConstraintError struct {
+	Kind    string   // Unique, ForeignKey, NotNull or Check, or empty if unknown
+	Columns []string // table.column, when the database names them
+	err     error
+}
+
+func (e *ConstraintError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.err
+}
+
+// Message describes the error without the database's own wording.
+func (e *ConstraintError) Message() string {
+	switch e.Kind {
+	case Unique:
+		return "an item with the same values already exists"
+	case ForeignKey:
+		return "refers to an item that doesn't exist"
+	case NotNull:
+		return "a required value is missing"
+	case Check:
+		return "a value is out of range"
+	}
+	return "refused by a constraint"
+}
+
+// AsConstraintError returns the ConstraintError reported by err, if a
+// constraint refused the write that failed with it.
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		e := &ConstraintError{err: err}
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			e.Kind = Unique
+		case sqlite3.ErrConstraintForeignKey:
+			e.Kind = ForeignKey
+		case sqlite3.ErrConstraintNotNull:
+			e.Kind = NotNull
+		case sqlite3.ErrConstraintCheck:
+			e.Kind = Check
+		}
+		// As in "UNIQUE constraint failed: artists.name"
+		if _, columns, ok := strings.Cut(sqliteErr.Error(), "constraint failed: "); ok {
+			e.Columns = strings.Split(columns, ", ")
+		}
+		return e, true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23") {
+		e := &ConstraintError{err: err}
+		switch pgErr.Code {
+		case "23505":
+			e.Kind = Unique
+		case "23503":
+			e.Kind = ForeignKey
+		case "23502":
+			e.Kind = NotNull
+		case "23514":
+			e.Kind = Check
+		}
+		if pgErr.ColumnName != "" {
+			e.Columns = []string{pgErr.TableName + "." + pgErr.ColumnName}
+		}
+		return e, true
+	}
+	return nil, false
+}
@@ -0,0 +1,188 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+)
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	for _, dialect := range dbtest.Dialects {
+		t.Run(string(dialect), func(t *testing.T) {
+			db := dbtest.Open(t, dialect)
+			if err := db.Migrate(ctx); err != nil {
+				t.Fatal(err)
+			}
+			status, err := db.MigrationStatus(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range status {
+				if m.AppliedAt == nil {
+					t.Errorf("migration %d_%s was not applied", m.Version, m.Name)
+				}
+			}
+
+			// Every migration can be reverted, and applied again
+			if err := db.MigrateDown(ctx, len(status)); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.MigrateUp(ctx, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			// Migrating again does nothing
+			if err := db.Migrate(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		// Placeholders are numbered around literals and comments holding ?,
+		// and booleans are stored in integer columns
+		var id int64
+		err := db.QueryRow(`
+			INSERT INTO albums (title, compilation) VALUES (?, ?) -- or not?
+			RETURNING id
+		`, "Hits (why?)", true).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var title string
+		err = db.QueryRow("SELECT title FROM albums WHERE title <> 'why?' AND title = ? AND compilation = ? AND id = ?",
+			"Hits (why?)", true, id).Scan(&title)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("UPDATE albums SET compilation = ? WHERE id = ?", false, id); err != nil {
+			t.Fatal(err)
+		}
+		var compilation bool
+		if err := tx.QueryRow("SELECT compilation FROM albums WHERE id = ?", id).Scan(&compilation); err != nil {
+			t.Fatal(err)
+		}
+		if compilation {
+			t.Error("compilation is still set")
+		}
+	})
+}
+
+func TestAsConstraintError(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		if _, err := db.Exec("INSERT INTO artists (name) VALUES (?)", "Björk"); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name    string
+			query   string
+			args    []any
+			kind    string
+			columns []string
+		}{
+			{"unique", "INSERT INTO artists (name) VALUES (?)", []any{"Björk"}, database.Unique, nil},
+			{"foreign key", "INSERT INTO songs (title, album_id) VALUES (?, ?)", []any{"Joga", 999}, database.ForeignKey, nil},
+			{"not null", "INSERT INTO songs (title) VALUES (?)", []any{nil}, database.NotNull, []string{"songs.title"}},
+		}
+		for _, test := range tests {
+			_, err := db.Exec(test.query, test.args...)
+			if err == nil {
+				t.Errorf("%s: the write succeeded", test.name)
+				continue
+			}
+			constraintErr, ok := database.AsConstraintError(err)
+			if !ok {
+				t.Errorf("%s: %v is not a constraint error", test.name, err)
+				continue
+			}
+			if constraintErr.Kind != test.kind {
+				t.Errorf("%s: kind is %q, want %q", test.name, constraintErr.Kind, test.kind)
+			}
+			if test.columns != nil && !slices.Equal(constraintErr.Columns, test.columns) {
+				t.Errorf("%s: columns are %v, want %v", test.name, constraintErr.Columns, test.columns)
+			}
+			if !errors.Is(constraintErr, err) {
+				t.Errorf("%s: doesn't wrap the database's error", test.name)
+			}
+		}
+
+		if _, ok := database.AsConstraintError(errors.New("disk full")); ok {
+			t.Error("an unrelated error is a constraint error")
+		}
+	})
+}
+
+func TestNocaseCollation(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		var artistID int64
+		if err := db.QueryRow("INSERT INTO artists (name) VALUES (?) RETURNING id", "Prince").Scan(&artistID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO artist_aliases (alias, artist_id) VALUES (?, ?)", "The Artist", artistID); err != nil {
+			t.Fatal(err)
+		}
+
+		// Aliases, genres and tags are unique ignoring case
+		_, err := db.Exec("INSERT INTO artist_aliases (alias, artist_id) VALUES (?, ?)", "THE ARTIST", artistID)
+		if constraintErr, ok := database.AsConstraintError(err); !ok || constraintErr.Kind != database.Unique {
+			t.Errorf("adding an alias differing in case gave %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO genres (name) VALUES (?)", "Funk"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("INSERT INTO genres (name) VALUES (?)", "funk")
+		if constraintErr, ok := database.AsConstraintError(err); !ok || constraintErr.Kind != database.Unique {
+			t.Errorf("adding a genre differing in case gave %v", err)
+		}
+
+		// and compared ignoring case
+		var found int64
+		if err := db.QueryRow("SELECT artist_id FROM artist_aliases WHERE alias = ?", "the artist").Scan(&found); err != nil {
+			t.Fatal(err)
+		}
+		if found != artistID {
+			t.Errorf("alias found artist %d, want %d", found, artistID)
+		}
+		if err := db.QueryRow("SELECT id FROM artists WHERE name = ? COLLATE NOCASE", "PRINCE").Scan(&found); err != nil {
+			t.Fatal(err)
+		}
+
+		// and sorted ignoring case
+		for _, name := range []string{"blues", "Acid Jazz", "Cumbia"} {
+			if _, err := db.Exec("INSERT INTO genres (name) VALUES (?)", name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rows, err := db.Query("SELECT name FROM genres ORDER BY name COLLATE NOCASE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		if want := []string{"Acid Jazz", "blues", "Cumbia", "Funk"}; !slices.Equal(names, want) {
+			t.Errorf("genres are sorted %v, want %v", names, want)
+		}
+	})
+}
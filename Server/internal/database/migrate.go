@@ -0,0 +1,287 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as pairs of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, applied in order of
+// version. Every migration is written for each dialect, with the same
+// version and name. Once released, a migration must not be edited: add a
+// new one.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations for a dialect in order.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := "migrations/" + string(dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		sql, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Migrate applies every migration that hasn't been applied yet. It fails if
+// the database has migrations this build doesn't know, rather than run
+// against a newer schema.
+func (db *DB) Migrate(ctx context.Context) error {
+	return db.MigrateUp(ctx, 0)
+}
+
+// MigrateUp applies pending migrations up to and including version target,
+// or all of them if target is 0. Each migration runs in its own
+// transaction, so a failing migration leaves the schema at the previous
+// version.
+func (db *DB) MigrateUp(ctx context.Context, target int) error {
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range status {
+		if m.Up == "" {
+			return fmt.Errorf("database has migration %d, which is newer than this build", m.Version)
+		}
+	}
+	for _, m := range status {
+		if m.AppliedAt != nil {
+			continue
+		}
+		if target > 0 && m.Version > target {
+			break
+		}
+		if err := db.applyMigration(ctx, m.Migration, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first.
+func (db *DB) MigrateDown(ctx context.Context, steps int) error {
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(status) - 1; i >= 0 && steps > 0; i-- {
+		m := status[i]
+		if m.AppliedAt == nil {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("can't revert migration %d, which is newer than this build", m.Version)
+		}
+		if err := db.applyMigration(ctx, m.Migration, false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+// MigrationStatus lists the known migrations, and any applied migration
+// this build doesn't know (with no SQL), in order of version.
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var m MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&m.Version, &m.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		m.AppliedAt = &appliedAt
+		applied[m.Version] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			delete(applied, m.Version)
+		}
+		status = append(status, s)
+	}
+	for _, unknown := range applied {
+		status = append(status, unknown)
+	}
+	slices.SortFunc(status, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return status, nil
+}
+
+// applyMigration runs a migration up or down and records it, in a single
+// transaction.
+//
+// Some changes to a SQLite table, such as dropping NOT NULL, need it
+// rebuilt: created anew, filled from the old table, which is dropped, and
+// renamed. With foreign keys enforced, dropping the old table would run the
+// ON DELETE actions of the tables referring to it, so SQLite migrations run
+// with them off, which can't be changed inside a transaction, and are
+// checked for rows referring to missing rows before they commit.
+func (db *DB) applyMigration(ctx context.Context, m Migration, up bool) error {
+	if db.Dialect != SQLite {
+		return db.runMigration(ctx, db.writer, m, up)
+	}
+
+	conn, err := db.writer.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "PRAGMA foreign_keys = ON")
+	return db.runMigration(ctx, conn, m, up)
+}
+
+// beginner is a pool or a single connection of one.
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// runMigration runs a migration in a transaction begun on conn.
+func (db *DB) runMigration(ctx context.Context, conn beginner, m Migration, up bool) error {
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Tx: sqlTx, Dialect: db.Dialect}
+	defer tx.Rollback()
+
+	direction, query := "up", m.Up
+	if !up {
+		direction, query = "down", m.Down
+	}
+	var violations int
+	if db.Dialect == SQLite {
+		if violations, err = foreignKeyViolations(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("migration %d_%s failed (%s): %w", m.Version, m.Name, direction, err)
+	}
+	if db.Dialect == SQLite {
+		after, err := foreignKeyViolations(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if after > violations {
+			return fmt.Errorf("migration %d_%s failed (%s): it leaves %d rows referring to missing rows", m.Version, m.Name, direction, after-violations)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)
+		`, m.Version, m.Name, time.Now().UTC().Truncate(time.Second))
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// foreignKeyViolations counts the rows of a SQLite database referring to
+// missing rows. Databases from before foreign keys were enforced may have
+// some, which migrations leave alone.
+func foreignKeyViolations(ctx context.Context, tx *Tx) (int, error) {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema is the schema created by the server before it had
+// migrations, which databases from then still have.
+const baselineSchema = `
+CREATE TABLE IF NOT EXISTS artists (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	bio TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS albums (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	artist_id INTEGER NOT NULL,
+	year INTEGER,
+	cover_art TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
+	UNIQUE(title, artist_id)
+);
+
+CREATE TABLE IF NOT EXISTS songs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	artist_id INTEGER,
+	album_id INTEGER,
+	track_number INTEGER,
+	duration INTEGER DEFAULT 0,
+	file_size INTEGER DEFAULT 0,
+	content_type TEXT DEFAULT 'audio/mpeg',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE SET NULL,
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_songs_artist_id ON songs(artist_id);
+CREATE INDEX IF NOT EXISTS idx_songs_album_id ON songs(album_id);
+CREATE INDEX IF NOT EXISTS idx_songs_title ON songs(title);
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
+CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name);
+`
+
+func openSQLiteTest(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "music.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// columns returns the columns of a SQLite table, and whether each is NOT NULL.
+func columns(t *testing.T, db *DB, table string) map[string]bool {
+	t.Helper()
+	rows, err := db.Query("SELECT name, \"notnull\" FROM pragma_table_info(?)", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var notNull bool
+		if err := rows.Scan(&name, &notNull); err != nil {
+			t.Fatal(err)
+		}
+		cols[name] = notNull
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cols
+}
+
+func TestMigrateFromBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLiteTest(t)
+
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO artists (id, name) VALUES (1, 'Nina Simone')",
+		"INSERT INTO albums (id, title, artist_id, year) VALUES (1, 'Pastel Blues', 1, 1965)",
+		"INSERT INTO songs (id, title, artist_id, album_id, track_number) VALUES (1, 'Sinnerman', 1, 1, 9)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"artists": {"sort_name", "country", "formed_year", "disbanded_year", "image_key", "version"},
+		"albums":  {"compilation", "disc_total", "track_total", "cover_art_key", "loudness", "true_peak", "version"},
+		"songs":   {"disc_number", "disc_total", "track_total", "loudness", "true_peak", "analyzed_at", "version"},
+	}
+	for table, names := range want {
+		cols := columns(t, db, table)
+		for _, name := range names {
+			if _, ok := cols[name]; !ok {
+				t.Errorf("%s.%s is missing", table, name)
+			}
+		}
+	}
+	if columns(t, db, "albums")["artist_id"] {
+		t.Error("albums.artist_id is still NOT NULL")
+	}
+
+	// The rows survive, with their links, credited to their artist
+	var albumID sql.NullInt64
+	var title string
+	if err := db.QueryRow("SELECT title, album_id FROM songs WHERE id = 1").Scan(&title, &albumID); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Sinnerman" || albumID.Int64 != 1 {
+		t.Errorf("song is %q on album %v, want Sinnerman on album 1", title, albumID)
+	}
+	for _, table := range []string{"song_artists", "album_artists"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE artist_id = 1").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%s has %d credits of the artist, want 1", table, n)
+		}
+	}
+	var found int
+	if err := db.QueryRow("SELECT COUNT(*) FROM song_search WHERE song_search MATCH 'sinnerman'").Scan(&found); err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 {
+		t.Errorf("search finds the song %d times, want 1", found)
+	}
+
+	// A compilation, which has no artist, can now be stored
+	if _, err := db.Exec("INSERT INTO albums (title, compilation) VALUES ('Jazz Classics', 1)"); err != nil {
+		t.Errorf("failed to add a compilation: %v", err)
+	}
+
+	var enabled bool
+	if err := db.writer.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Error("foreign keys are still off after migrating")
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLiteTest(t)
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MigrateDown(ctx, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name <> 'schema_migrations' AND name NOT LIKE 'sqlite%'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables are left after reverting every migration", tables)
+	}
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d SQLite migrations, but %d PostgreSQL ones", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d_%s is %d_%s for PostgreSQL",
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
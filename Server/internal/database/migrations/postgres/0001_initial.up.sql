@@ -0,0 +1,43 @@
+-- The schema as it was before migrations, matching SQLite's. Flags are
+-- integers, as in SQLite. Later migrations take it to the current schema.
+
+CREATE TABLE IF NOT EXISTS artists (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	bio TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS albums (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	title TEXT NOT NULL,
+	artist_id BIGINT NOT NULL,
+	year INTEGER,
+	cover_art TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
+	UNIQUE(title, artist_id)
+);
+
+CREATE TABLE IF NOT EXISTS songs (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	title TEXT NOT NULL,
+	artist_id BIGINT,
+	album_id BIGINT,
+	track_number INTEGER,
+	duration INTEGER DEFAULT 0,
+	file_size BIGINT DEFAULT 0,
+	content_type TEXT DEFAULT 'audio/mpeg',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE SET NULL,
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_songs_artist_id ON songs(artist_id);
+CREATE INDEX IF NOT EXISTS idx_songs_album_id ON songs(album_id);
+CREATE INDEX IF NOT EXISTS idx_songs_title ON songs(title);
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
+CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name);
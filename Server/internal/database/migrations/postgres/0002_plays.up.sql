@@ -0,0 +1,24 @@
+-- plays records each time a user listens to a song, and now_playing what
+-- each user is listening to, until it expires.
+
+CREATE TABLE IF NOT EXISTS plays (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	song_id BIGINT NOT NULL,
+	user_id TEXT NOT NULL,
+	played_at TIMESTAMP NOT NULL,
+	duration_played INTEGER DEFAULT 0,
+	source TEXT NOT NULL DEFAULT 'api',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS now_playing (
+	user_id TEXT PRIMARY KEY,
+	song_id BIGINT NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_plays_user_played_at ON plays(user_id, played_at);
+CREATE INDEX IF NOT EXISTS idx_plays_song_id ON plays(song_id);
@@ -0,0 +1,33 @@
+-- scrobble_integrations holds each user's ListenBrainz and Last.fm
+-- accounts, and scrobble_queue the plays waiting to be sent to them.
+
+CREATE TABLE IF NOT EXISTS scrobble_integrations (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	service TEXT NOT NULL,
+	token TEXT NOT NULL,
+	username TEXT NOT NULL DEFAULT '',
+	enabled INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(user_id, service)
+);
+
+CREATE TABLE IF NOT EXISTS scrobble_queue (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	integration_id BIGINT NOT NULL,
+	artist_name TEXT NOT NULL,
+	track_name TEXT NOT NULL,
+	album_name TEXT NOT NULL DEFAULT '',
+	track_number INTEGER NOT NULL DEFAULT 0,
+	duration INTEGER NOT NULL DEFAULT 0,
+	listened_at TIMESTAMP NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (integration_id) REFERENCES scrobble_integrations(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrobble_queue_due ON scrobble_queue(status, next_attempt_at);
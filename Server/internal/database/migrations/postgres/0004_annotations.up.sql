@@ -0,0 +1,15 @@
+-- annotations holds each user's stars and ratings of songs, albums and
+-- artists.
+
+CREATE TABLE IF NOT EXISTS annotations (
+	user_id TEXT NOT NULL,
+	item_type TEXT NOT NULL,
+	item_id BIGINT NOT NULL,
+	starred INTEGER NOT NULL DEFAULT 0,
+	starred_at TIMESTAMP,
+	rating INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, item_type, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_annotations_item ON annotations(item_type, item_id);
@@ -0,0 +1,5 @@
+-- The S3 keys of uploaded artist images and album covers. Their thumbnails
+-- are stored next to them.
+
+ALTER TABLE artists ADD COLUMN image_key TEXT;
+ALTER TABLE albums ADD COLUMN cover_art_key TEXT;
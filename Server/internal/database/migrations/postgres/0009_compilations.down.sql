@@ -0,0 +1,8 @@
+-- Albums without an artist, such as compilations, can't be kept, and are
+-- deleted along with their songs' links to them.
+
+DROP TABLE IF EXISTS album_artists;
+
+DELETE FROM albums WHERE artist_id IS NULL;
+ALTER TABLE albums DROP COLUMN compilation;
+ALTER TABLE albums ALTER COLUMN artist_id SET NOT NULL;
@@ -0,0 +1,22 @@
+-- Compilations have no single album artist, so albums.artist_id becomes
+-- nullable, and album_artists credits albums to any number of artists.
+
+ALTER TABLE albums ALTER COLUMN artist_id DROP NOT NULL;
+ALTER TABLE albums ADD COLUMN compilation INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS album_artists (
+	album_id BIGINT NOT NULL,
+	artist_id BIGINT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'main',
+	position INTEGER NOT NULL,
+	PRIMARY KEY (album_id, position),
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_album_artists_artist_id ON album_artists(artist_id);
+
+-- Credit existing albums to their artist
+INSERT INTO album_artists (album_id, artist_id, role, position)
+SELECT id, artist_id, 'main', 0 FROM albums
+WHERE artist_id IS NOT NULL AND id NOT IN (SELECT album_id FROM album_artists);
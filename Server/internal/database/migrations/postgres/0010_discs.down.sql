@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_songs_album_id;
+CREATE INDEX idx_songs_album_id ON songs(album_id);
+
+DROP TABLE IF EXISTS album_discs;
+
+ALTER TABLE songs DROP COLUMN track_total;
+ALTER TABLE songs DROP COLUMN disc_total;
+ALTER TABLE songs DROP COLUMN disc_number;
+ALTER TABLE albums DROP COLUMN track_total;
+ALTER TABLE albums DROP COLUMN disc_total;
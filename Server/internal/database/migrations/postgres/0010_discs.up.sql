@@ -0,0 +1,19 @@
+-- Disc and track numbers, with the totals from tags, and the subtitles of
+-- an album's discs. Album tracks are ordered by disc, then track.
+
+ALTER TABLE albums ADD COLUMN disc_total INTEGER;
+ALTER TABLE albums ADD COLUMN track_total INTEGER;
+ALTER TABLE songs ADD COLUMN disc_number INTEGER;
+ALTER TABLE songs ADD COLUMN disc_total INTEGER;
+ALTER TABLE songs ADD COLUMN track_total INTEGER;
+
+CREATE TABLE IF NOT EXISTS album_discs (
+	album_id BIGINT NOT NULL,
+	disc_number INTEGER NOT NULL,
+	subtitle TEXT NOT NULL,
+	PRIMARY KEY (album_id, disc_number),
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE
+);
+
+DROP INDEX IF EXISTS idx_songs_album_id;
+CREATE INDEX idx_songs_album_id ON songs(album_id, disc_number, track_number);
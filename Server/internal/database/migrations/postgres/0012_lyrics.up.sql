@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS lyrics (
+	song_id BIGINT PRIMARY KEY,
+	text TEXT NOT NULL, -- LRC when synced
+	plain_text TEXT NOT NULL, -- without timestamps, for search
+	synced INTEGER NOT NULL DEFAULT 0,
+	language TEXT,
+	source TEXT NOT NULL, -- 'tags' or 'upload'
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS song_search_song ON songs;
+DROP TRIGGER IF EXISTS song_search_credit ON song_artists;
+DROP TRIGGER IF EXISTS song_search_artist_update ON artists;
+DROP TRIGGER IF EXISTS song_search_album_update ON albums;
+DROP TRIGGER IF EXISTS song_search_lyrics ON lyrics;
+DROP FUNCTION IF EXISTS song_search_song();
+DROP FUNCTION IF EXISTS song_search_song_id();
+DROP FUNCTION IF EXISTS song_search_artist();
+DROP FUNCTION IF EXISTS song_search_album();
+DROP FUNCTION IF EXISTS index_songs(BIGINT[]);
+DROP TABLE IF EXISTS song_search;
@@ -0,0 +1,89 @@
+-- song_search is the full-text index of song titles, artist names, album
+-- titles and lyrics searched by /search, one row per song. The columns are
+-- weighted A to D for ranking, and the triggers below keep it up to date.
+-- Existing songs are indexed at the end.
+
+CREATE TABLE IF NOT EXISTS song_search (
+	docid BIGINT PRIMARY KEY,
+	title TEXT NOT NULL,
+	artists TEXT NOT NULL,
+	album TEXT NOT NULL,
+	lyrics TEXT NOT NULL,
+	document TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', title), 'A') ||
+		setweight(to_tsvector('simple', artists), 'B') ||
+		setweight(to_tsvector('simple', album), 'C') ||
+		setweight(to_tsvector('simple', lyrics), 'D')
+	) STORED
+);
+
+CREATE INDEX IF NOT EXISTS idx_song_search_document ON song_search USING GIN (document);
+
+-- index_songs (re)indexes the songs with the given ids.
+CREATE OR REPLACE FUNCTION index_songs(ids BIGINT[]) RETURNS void AS $$
+BEGIN
+	DELETE FROM song_search WHERE docid = ANY(ids);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT string_agg(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id = ANY(ids);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION song_search_song() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		DELETE FROM song_search WHERE docid = OLD.id;
+	ELSE
+		PERFORM index_songs(ARRAY[NEW.id]);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION song_search_song_id() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		PERFORM index_songs(ARRAY[OLD.song_id]);
+	ELSE
+		PERFORM index_songs(ARRAY[NEW.song_id]);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION song_search_artist() RETURNS trigger AS $$
+BEGIN
+	PERFORM index_songs(ARRAY(SELECT song_id FROM song_artists WHERE artist_id = NEW.id));
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION song_search_album() RETURNS trigger AS $$
+BEGIN
+	PERFORM index_songs(ARRAY(SELECT id FROM songs WHERE album_id = NEW.id));
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER song_search_song AFTER INSERT OR DELETE OR UPDATE OF title, album_id ON songs
+	FOR EACH ROW EXECUTE FUNCTION song_search_song();
+CREATE TRIGGER song_search_credit AFTER INSERT OR DELETE ON song_artists
+	FOR EACH ROW EXECUTE FUNCTION song_search_song_id();
+CREATE TRIGGER song_search_artist_update AFTER UPDATE OF name ON artists
+	FOR EACH ROW EXECUTE FUNCTION song_search_artist();
+CREATE TRIGGER song_search_album_update AFTER UPDATE OF title ON albums
+	FOR EACH ROW EXECUTE FUNCTION song_search_album();
+CREATE TRIGGER song_search_lyrics AFTER INSERT OR UPDATE OR DELETE ON lyrics
+	FOR EACH ROW EXECUTE FUNCTION song_search_song_id();
+
+SELECT index_songs(ARRAY(SELECT id FROM songs WHERE id NOT IN (SELECT docid FROM song_search)));
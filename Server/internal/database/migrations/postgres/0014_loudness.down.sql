@@ -0,0 +1,5 @@
+ALTER TABLE songs DROP COLUMN analyzed_at;
+ALTER TABLE songs DROP COLUMN true_peak;
+ALTER TABLE songs DROP COLUMN loudness;
+ALTER TABLE albums DROP COLUMN true_peak;
+ALTER TABLE albums DROP COLUMN loudness;
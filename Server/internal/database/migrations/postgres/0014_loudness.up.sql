@@ -0,0 +1,8 @@
+-- Loudness is set by analysis, for ReplayGain: integrated loudness in LUFS
+-- and linear true peak, per song and over all analyzed tracks of an album.
+
+ALTER TABLE albums ADD COLUMN loudness DOUBLE PRECISION;
+ALTER TABLE albums ADD COLUMN true_peak DOUBLE PRECISION;
+ALTER TABLE songs ADD COLUMN loudness DOUBLE PRECISION;
+ALTER TABLE songs ADD COLUMN true_peak DOUBLE PRECISION;
+ALTER TABLE songs ADD COLUMN analyzed_at TIMESTAMP;
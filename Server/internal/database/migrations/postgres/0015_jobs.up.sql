@@ -0,0 +1,20 @@
+-- jobs is the queue of post-upload processing, such as loudness analysis.
+-- payload is JSON, and failed jobs are retried at run_at until they have
+-- had max_attempts.
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '{}',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	run_at TIMESTAMP NOT NULL,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(status, run_at);
@@ -0,0 +1,7 @@
+-- version counts the writes to each song, album and artist, so that an
+-- update can be made conditional on the row not having changed since it was
+-- read. Every UPDATE of these tables increments it.
+
+ALTER TABLE songs ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE albums ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE artists ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
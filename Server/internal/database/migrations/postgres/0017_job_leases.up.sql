@@ -0,0 +1,6 @@
+-- locked_until is when the lease of the worker running a job runs out.
+-- Workers renew it while the job runs, so a running job whose lease has
+-- expired was left by a worker that stopped, and is queued again, while jobs
+-- running on other servers sharing the database are left alone.
+
+ALTER TABLE jobs ADD COLUMN locked_until TIMESTAMP;
@@ -0,0 +1,3 @@
+ALTER TABLE albums DROP CONSTRAINT albums_artist_id_fkey;
+ALTER TABLE albums ADD CONSTRAINT albums_artist_id_fkey
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE;
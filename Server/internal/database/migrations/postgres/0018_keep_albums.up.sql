@@ -0,0 +1,7 @@
+-- Deleting an artist no longer deletes their albums, which may have other
+-- artists: the repository moves them to the next one, or they are left
+-- without an artist.
+
+ALTER TABLE albums DROP CONSTRAINT albums_artist_id_fkey;
+ALTER TABLE albums ADD CONSTRAINT albums_artist_id_fkey
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE SET NULL;
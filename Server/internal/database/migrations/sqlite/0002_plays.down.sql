@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS now_playing;
+DROP TABLE IF EXISTS plays;
@@ -0,0 +1,24 @@
+-- plays records each time a user listens to a song, and now_playing what
+-- each user is listening to, until it expires.
+
+CREATE TABLE IF NOT EXISTS plays (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	song_id INTEGER NOT NULL,
+	user_id TEXT NOT NULL,
+	played_at DATETIME NOT NULL,
+	duration_played INTEGER DEFAULT 0,
+	source TEXT NOT NULL DEFAULT 'api',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS now_playing (
+	user_id TEXT PRIMARY KEY,
+	song_id INTEGER NOT NULL,
+	started_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_plays_user_played_at ON plays(user_id, played_at);
+CREATE INDEX IF NOT EXISTS idx_plays_song_id ON plays(song_id);
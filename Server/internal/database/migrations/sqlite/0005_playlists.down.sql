@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS smart_playlists;
+DROP TABLE IF EXISTS playlist_songs;
+DROP TABLE IF EXISTS playlists;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS playlists (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS playlist_songs (
+	playlist_id INTEGER NOT NULL,
+	song_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (playlist_id, position),
+	FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+-- rules is the JSON a smart playlist's songs are selected by
+CREATE TABLE IF NOT EXISTS smart_playlists (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT,
+	rules TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_playlists_user_id ON playlists(user_id);
+CREATE INDEX IF NOT EXISTS idx_playlist_songs_song_id ON playlist_songs(song_id);
+CREATE INDEX IF NOT EXISTS idx_smart_playlists_user_id ON smart_playlists(user_id);
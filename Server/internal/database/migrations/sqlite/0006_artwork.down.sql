@@ -0,0 +1,2 @@
+ALTER TABLE albums DROP COLUMN cover_art_key;
+ALTER TABLE artists DROP COLUMN image_key;
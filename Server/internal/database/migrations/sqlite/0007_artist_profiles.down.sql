@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS artist_links;
+DROP TABLE IF EXISTS artist_aliases;
+
+ALTER TABLE artists DROP COLUMN disbanded_year;
+ALTER TABLE artists DROP COLUMN formed_year;
+ALTER TABLE artists DROP COLUMN country;
+ALTER TABLE artists DROP COLUMN sort_name;
@@ -0,0 +1,21 @@
+ALTER TABLE artists ADD COLUMN sort_name TEXT;
+ALTER TABLE artists ADD COLUMN country TEXT;
+ALTER TABLE artists ADD COLUMN formed_year INTEGER;
+ALTER TABLE artists ADD COLUMN disbanded_year INTEGER;
+
+CREATE TABLE IF NOT EXISTS artist_aliases (
+	alias TEXT PRIMARY KEY COLLATE NOCASE,
+	artist_id INTEGER NOT NULL,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS artist_links (
+	artist_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	url TEXT NOT NULL,
+	PRIMARY KEY (artist_id, position),
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_artist_aliases_artist_id ON artist_aliases(artist_id);
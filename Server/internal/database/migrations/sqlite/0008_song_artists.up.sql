@@ -0,0 +1,19 @@
+-- song_artists credits songs to any number of artists, in order. The first
+-- main artist is also kept in songs.artist_id.
+
+CREATE TABLE IF NOT EXISTS song_artists (
+	song_id INTEGER NOT NULL,
+	artist_id INTEGER NOT NULL,
+	role TEXT NOT NULL DEFAULT 'main',
+	position INTEGER NOT NULL,
+	PRIMARY KEY (song_id, position),
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_song_artists_artist_id ON song_artists(artist_id);
+
+-- Credit existing songs to their artist
+INSERT INTO song_artists (song_id, artist_id, role, position)
+SELECT id, artist_id, 'main', 0 FROM songs
+WHERE artist_id IS NOT NULL AND id NOT IN (SELECT song_id FROM song_artists);
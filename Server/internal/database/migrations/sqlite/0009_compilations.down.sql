@@ -0,0 +1,28 @@
+-- Albums without an artist, such as compilations, can't be kept, and are
+-- deleted along with their songs' links to them.
+
+DROP TABLE IF EXISTS album_artists;
+
+CREATE TABLE albums_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	artist_id INTEGER NOT NULL,
+	year INTEGER,
+	cover_art TEXT,
+	cover_art_key TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
+	UNIQUE(title, artist_id)
+);
+
+UPDATE songs SET album_id = NULL
+WHERE album_id IN (SELECT id FROM albums WHERE artist_id IS NULL);
+INSERT INTO albums_old (id, title, artist_id, year, cover_art, cover_art_key, created_at, updated_at)
+SELECT id, title, artist_id, year, cover_art, cover_art_key, created_at, updated_at
+FROM albums WHERE artist_id IS NOT NULL;
+
+DROP TABLE albums;
+ALTER TABLE albums_old RENAME TO albums;
+
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
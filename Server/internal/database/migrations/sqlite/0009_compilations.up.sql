@@ -0,0 +1,42 @@
+-- Compilations have no single album artist, so albums.artist_id becomes
+-- nullable, and album_artists credits albums to any number of artists.
+-- SQLite can't drop NOT NULL from a column, so albums is rebuilt.
+
+CREATE TABLE albums_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	artist_id INTEGER,
+	compilation INTEGER NOT NULL DEFAULT 0,
+	year INTEGER,
+	cover_art TEXT,
+	cover_art_key TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
+	UNIQUE(title, artist_id)
+);
+
+INSERT INTO albums_new (id, title, artist_id, year, cover_art, cover_art_key, created_at, updated_at)
+SELECT id, title, artist_id, year, cover_art, cover_art_key, created_at, updated_at FROM albums;
+
+DROP TABLE albums;
+ALTER TABLE albums_new RENAME TO albums;
+
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
+
+CREATE TABLE IF NOT EXISTS album_artists (
+	album_id INTEGER NOT NULL,
+	artist_id INTEGER NOT NULL,
+	role TEXT NOT NULL DEFAULT 'main',
+	position INTEGER NOT NULL,
+	PRIMARY KEY (album_id, position),
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_album_artists_artist_id ON album_artists(artist_id);
+
+-- Credit existing albums to their artist
+INSERT INTO album_artists (album_id, artist_id, role, position)
+SELECT id, artist_id, 'main', 0 FROM albums
+WHERE artist_id IS NOT NULL AND id NOT IN (SELECT album_id FROM album_artists);
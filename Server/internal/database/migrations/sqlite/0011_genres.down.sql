@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS item_tags;
+DROP TABLE IF EXISTS album_genres;
+DROP TABLE IF EXISTS song_genres;
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,40 @@
+-- Genres form a tree through parent_id, and are shared by all users. Tags
+-- are each user's own.
+
+CREATE TABLE IF NOT EXISTS genres (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	parent_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (parent_id) REFERENCES genres(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS song_genres (
+	song_id INTEGER NOT NULL,
+	genre_id INTEGER NOT NULL,
+	PRIMARY KEY (song_id, genre_id),
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE,
+	FOREIGN KEY (genre_id) REFERENCES genres(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS album_genres (
+	album_id INTEGER NOT NULL,
+	genre_id INTEGER NOT NULL,
+	PRIMARY KEY (album_id, genre_id),
+	FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+	FOREIGN KEY (genre_id) REFERENCES genres(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS item_tags (
+	user_id TEXT NOT NULL,
+	item_type TEXT NOT NULL,
+	item_id INTEGER NOT NULL,
+	tag TEXT NOT NULL COLLATE NOCASE,
+	PRIMARY KEY (user_id, item_type, item_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_genres_parent_id ON genres(parent_id);
+CREATE INDEX IF NOT EXISTS idx_song_genres_genre_id ON song_genres(genre_id);
+CREATE INDEX IF NOT EXISTS idx_album_genres_genre_id ON album_genres(genre_id);
+CREATE INDEX IF NOT EXISTS idx_item_tags_tag ON item_tags(user_id, tag);
+CREATE INDEX IF NOT EXISTS idx_item_tags_item ON item_tags(item_type, item_id);
@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS song_search_song_insert;
+DROP TRIGGER IF EXISTS song_search_song_update;
+DROP TRIGGER IF EXISTS song_search_song_delete;
+DROP TRIGGER IF EXISTS song_search_credit_insert;
+DROP TRIGGER IF EXISTS song_search_credit_delete;
+DROP TRIGGER IF EXISTS song_search_artist_update;
+DROP TRIGGER IF EXISTS song_search_album_update;
+DROP TRIGGER IF EXISTS song_search_lyrics_insert;
+DROP TRIGGER IF EXISTS song_search_lyrics_update;
+DROP TRIGGER IF EXISTS song_search_lyrics_delete;
+DROP TABLE IF EXISTS song_search;
@@ -0,0 +1,171 @@
+-- song_search is the full-text index of song titles, artist names, album
+-- titles and lyrics searched by /search. Its docid is the song id, and the
+-- triggers below keep it up to date. Existing songs are indexed at the end.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS song_search USING fts4(
+	title, artists, album, lyrics, tokenize=unicode61
+);
+
+CREATE TRIGGER IF NOT EXISTS song_search_song_insert AFTER INSERT ON songs BEGIN
+	DELETE FROM song_search WHERE docid IN (NEW.id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (NEW.id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_song_update AFTER UPDATE OF title, album_id ON songs BEGIN
+	DELETE FROM song_search WHERE docid IN (NEW.id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (NEW.id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_song_delete AFTER DELETE ON songs BEGIN
+	DELETE FROM song_search WHERE docid = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS song_search_credit_insert AFTER INSERT ON song_artists BEGIN
+	DELETE FROM song_search WHERE docid IN (NEW.song_id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (NEW.song_id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_credit_delete AFTER DELETE ON song_artists BEGIN
+	DELETE FROM song_search WHERE docid IN (OLD.song_id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (OLD.song_id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_artist_update AFTER UPDATE OF name ON artists BEGIN
+	DELETE FROM song_search WHERE docid IN (SELECT song_id FROM song_artists WHERE artist_id = NEW.id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (SELECT song_id FROM song_artists WHERE artist_id = NEW.id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_album_update AFTER UPDATE OF title ON albums BEGIN
+	DELETE FROM song_search WHERE docid IN (SELECT id FROM songs WHERE album_id = NEW.id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (SELECT id FROM songs WHERE album_id = NEW.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS song_search_lyrics_insert AFTER INSERT ON lyrics BEGIN
+	DELETE FROM song_search WHERE docid IN (NEW.song_id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (NEW.song_id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_lyrics_update AFTER UPDATE ON lyrics BEGIN
+	DELETE FROM song_search WHERE docid IN (NEW.song_id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (NEW.song_id);
+END;
+CREATE TRIGGER IF NOT EXISTS song_search_lyrics_delete AFTER DELETE ON lyrics BEGIN
+	DELETE FROM song_search WHERE docid IN (OLD.song_id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (OLD.song_id);
+END;
+
+INSERT INTO song_search (docid, title, artists, album, lyrics)
+SELECT s.id, s.title,
+       COALESCE((
+           SELECT group_concat(ar.name, ' ')
+           FROM song_artists sa
+           JOIN artists ar ON sa.artist_id = ar.id
+           WHERE sa.song_id = s.id
+       ), ''),
+       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+FROM songs s
+LEFT JOIN albums al ON s.album_id = al.id
+LEFT JOIN lyrics l ON l.song_id = s.id
+WHERE s.id NOT IN (SELECT docid FROM song_search);
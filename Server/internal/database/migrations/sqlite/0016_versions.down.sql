@@ -0,0 +1,3 @@
+ALTER TABLE songs DROP COLUMN version;
+ALTER TABLE albums DROP COLUMN version;
+ALTER TABLE artists DROP COLUMN version;
@@ -0,0 +1,53 @@
+-- Deleting an artist deletes their albums again.
+
+CREATE TABLE albums_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	artist_id INTEGER,
+	compilation INTEGER NOT NULL DEFAULT 0,
+	year INTEGER,
+	cover_art TEXT,
+	cover_art_key TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	disc_total INTEGER,
+	track_total INTEGER,
+	loudness REAL,
+	true_peak REAL,
+	version INTEGER NOT NULL DEFAULT 1,
+	FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE,
+	UNIQUE(title, artist_id)
+);
+
+INSERT INTO albums_new (id, title, artist_id, compilation, year, cover_art, cover_art_key, created_at, updated_at,
+	disc_total, track_total, loudness, true_peak, version)
+SELECT id, title, artist_id, compilation, year, cover_art, cover_art_key, created_at, updated_at,
+	disc_total, track_total, loudness, true_peak, version
+FROM albums;
+
+-- The search triggers on songs, artists and lyrics read albums, which
+-- renaming a table checks unless in legacy mode
+PRAGMA legacy_alter_table = ON;
+DROP TABLE albums;
+ALTER TABLE albums_new RENAME TO albums;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
+
+-- Dropping albums dropped its trigger keeping the search index up to date
+CREATE TRIGGER IF NOT EXISTS song_search_album_update AFTER UPDATE OF title ON albums BEGIN
+	DELETE FROM song_search WHERE docid IN (SELECT id FROM songs WHERE album_id = NEW.id);
+	INSERT INTO song_search (docid, title, artists, album, lyrics)
+	SELECT s.id, s.title,
+	       COALESCE((
+	           SELECT group_concat(ar.name, ' ')
+	           FROM song_artists sa
+	           JOIN artists ar ON sa.artist_id = ar.id
+	           WHERE sa.song_id = s.id
+	       ), ''),
+	       COALESCE(al.title, ''), COALESCE(l.plain_text, '')
+	FROM songs s
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN lyrics l ON l.song_id = s.id
+	WHERE s.id IN (SELECT id FROM songs WHERE album_id = NEW.id);
+END;
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoSnapshots is returned by Snapshot on PostgreSQL, whose backups are
+// left to its own tools, such as pg_dump.
+var ErrNoSnapshots = errors.New("snapshots are only supported on SQLite")
+
+// Snapshot writes a consistent copy of the SQLite database to path, which
+// must not exist or be empty, while the server keeps running. It reads
+// through the read pool, so writes go on meanwhile.
+func (db *DB) Snapshot(ctx context.Context, path string) error {
+	if db.Dialect != SQLite {
+		return ErrNoSnapshots
+	}
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// VACUUM INTO only writes to path, but query_only refuses it all the same
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = off"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "VACUUM INTO ?", path)
+	if _, resetErr := conn.ExecContext(context.Background(), "PRAGMA query_only = on"); resetErr != nil {
+		// Keep the connection out of the read pool
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	if err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
+
+// CheckIntegrity runs SQLite's integrity check, returning the problems it
+// reports.
+func (db *DB) CheckIntegrity(ctx context.Context) error {
+	if db.Dialect != SQLite {
+		return nil
+	}
+	rows, err := db.DB.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return err
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupt: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
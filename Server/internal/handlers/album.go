@@ -1,67 +1,61 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+	"s3-music-streamer/internal/tags"
+	"s3-music-streamer/internal/validate"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func (h *Handler) ListAlbums(w http.ResponseWriter, r *http.Request) {
-	// Support filtering by artist_id via query param
-	artistID := r.URL.Query().Get("artist_id")
-
-	query := `
-		SELECT a.id, a.title, a.artist_id, a.year, a.cover_art,
-		       a.created_at, a.updated_at, ar.name as artist_name
-		FROM albums a
-		JOIN artists ar ON a.artist_id = ar.id
-	`
-
-	var rows *sql.Rows
-	var err error
-
-	if artistID != "" {
-		query += " WHERE a.artist_id = ? ORDER BY a.year DESC, a.title ASC"
-		rows, err = h.db.Query(query, artistID)
-	} else {
-		query += " ORDER BY a.created_at DESC"
-		rows, err = h.db.Query(query)
-	}
-
+	// Support filtering by artist_id, appears_on, compilation, genre, tag,
+	// starred and min_rating via query params
+	annotations, err := annotationFilter(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer rows.Close()
-
-	albums := []models.Album{}
-	for rows.Next() {
-		var album models.Album
-		var year sql.NullInt64
-		var coverArt sql.NullString
-		if err := rows.Scan(
-			&album.ID, &album.Title, &album.ArtistID, &year, &coverArt,
-			&album.CreatedAt, &album.UpdatedAt, &album.ArtistName,
-		); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	filter := repository.AlbumFilter{
+		User:             currentUser(r),
+		AnnotationFilter: annotations,
+		Genre:            r.URL.Query().Get("genre"),
+		Tag:              r.URL.Query().Get("tag"),
+	}
+	if filter.ArtistID, err = queryID(r, "artist_id"); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.AppearsOn, err = queryID(r, "appears_on"); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if compilation := r.URL.Query().Get("compilation"); compilation != "" {
+		value, err := strconv.ParseBool(compilation)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "compilation must be true or false")
 			return
 		}
-		if year.Valid {
-			album.Year = int(year.Int64)
-		}
-		if coverArt.Valid {
-			album.CoverArt = coverArt.String
-		}
-		albums = append(albums, album)
+		filter.Compilation = &value
+	}
+
+	albums, err := h.albums.List(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err)
+		return
 	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.loadAlbumDetails(r.Context(), currentUser(r), albums); err != nil {
+		serverError(w, r, err)
 		return
 	}
 
@@ -73,148 +67,239 @@ func (h *Handler) GetAlbum(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid album id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid album id")
 		return
 	}
 
-	var album models.Album
-	var year sql.NullInt64
-	var coverArt sql.NullString
-	err = h.db.QueryRow(`
-		SELECT a.id, a.title, a.artist_id, a.year, a.cover_art,
-		       a.created_at, a.updated_at, ar.name as artist_name
-		FROM albums a
-		JOIN artists ar ON a.artist_id = ar.id
-		WHERE a.id = ?
-	`, id).Scan(
-		&album.ID, &album.Title, &album.ArtistID, &year, &coverArt,
-		&album.CreatedAt, &album.UpdatedAt, &album.ArtistName,
-	)
-	if err == sql.ErrNoRows {
-		http.Error(w, "album not found", http.StatusNotFound)
-		return
-	}
+	album, err := h.getAlbum(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "album")
 		return
 	}
-
-	if year.Valid {
-		album.Year = int(year.Int64)
-	}
-	if coverArt.Valid {
-		album.CoverArt = coverArt.String
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(album)
+	writeEntity(w, r, http.StatusOK, itemTag("album", album.ID, album.Version), album)
 }
 
 func (h *Handler) CreateAlbum(w http.ResponseWriter, r *http.Request) {
 	var album models.Album
 	if err := json.NewDecoder(r.Body).Decode(&album); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if album.Title == "" || album.ArtistID == 0 {
-		http.Error(w, "title and artist_id are required", http.StatusBadRequest)
+	if err := normalizeAlbum(&album); err != nil {
+		writeValidationError(w, r, err)
 		return
 	}
-
-	var year *int
-	if album.Year > 0 {
-		year = &album.Year
-	}
-	var coverArt *string
-	if album.CoverArt != "" {
-		coverArt = &album.CoverArt
+	if !h.validate(w, r, &album) {
+		return
 	}
 
-	result, err := h.db.Exec(`
-		INSERT INTO albums (title, artist_id, year, cover_art)
-		VALUES (?, ?, ?, ?)
-	`, album.Title, album.ArtistID, year, coverArt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.albums.Create(r.Context(), &album); err != nil {
+		writeRepositoryError(w, r, err, "album")
 		return
 	}
 
-	id, err := result.LastInsertId()
+	created, err := h.getAlbum(r.Context(), currentUser(r), album.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
-	album.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(album)
+	writeEntity(w, r, http.StatusCreated, itemTag("album", created.ID, created.Version), created)
 }
 
 func (h *Handler) UpdateAlbum(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid album id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid album id")
 		return
 	}
 
 	var album models.Album
 	if err := json.NewDecoder(r.Body).Decode(&album); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	var year *int
-	if album.Year > 0 {
-		year = &album.Year
+	current, err := h.getAlbum(r.Context(), currentUser(r), id)
+	if err != nil {
+		writeRepositoryError(w, r, err, "album")
+		return
 	}
-	var coverArt *string
-	if album.CoverArt != "" {
-		coverArt = &album.CoverArt
+	h.updateAlbum(w, r, current, album)
+}
+
+// PatchAlbum updates the fields of an album set by a JSON merge patch,
+// keeping the others.
+func (h *Handler) PatchAlbum(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid album id")
+		return
 	}
 
-	result, err := h.db.Exec(`
-		UPDATE albums
-		SET title = ?, artist_id = ?, year = ?, cover_art = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, album.Title, album.ArtistID, year, coverArt, id)
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.getAlbum(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "album")
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "album not found", http.StatusNotFound)
+	patchCredits(patch)
+	var album models.Album
+	if err := applyMergePatch(current, patch, &album); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	h.updateAlbum(w, r, current, album)
+}
 
-	album.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(album)
+// updateAlbum replaces current, as read by getAlbum, by album, the body of
+// an update.
+func (h *Handler) updateAlbum(w http.ResponseWriter, r *http.Request, current *models.Album, album models.Album) {
+	version, ok := h.checkIfMatch(w, r, itemTag("album", current.ID, current.Version), current.Version)
+	if !ok {
+		return
+	}
+
+	if err := normalizeAlbum(&album); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if !h.validate(w, r, &album) {
+		return
+	}
+
+	album.ID = current.ID
+	album.Version = version
+	if err := h.albums.Update(r.Context(), &album); err != nil {
+		writeRepositoryError(w, r, err, "album")
+		return
+	}
+
+	updated, err := h.getAlbum(r.Context(), currentUser(r), album.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, itemTag("album", updated.ID, updated.Version), updated)
 }
 
 func (h *Handler) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid album id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid album id")
 		return
 	}
 
-	result, err := h.db.Exec("DELETE FROM albums WHERE id = ?", id)
+	current, err := h.getAlbum(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "album")
+		return
+	}
+	version, ok := h.checkIfMatch(w, r, itemTag("album", current.ID, current.Version), current.Version)
+	if !ok {
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "album not found", http.StatusNotFound)
+	if err := h.albums.Delete(r.Context(), id, version); err != nil {
+		writeRepositoryError(w, r, err, "album")
 		return
 	}
+	if err := h.s3.DeletePrefix(r.Context(), albumCover.key(id)); err != nil {
+		log.Printf("Failed to delete cover of album %d: %v", id, err)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// getAlbum loads an album with its artists, discs, genres and the user's
+// tags, returning repository.ErrNotFound if there is no such album.
+func (h *Handler) getAlbum(ctx context.Context, user string, id int64) (*models.Album, error) {
+	album, err := h.albums.Get(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	albums := []models.Album{*album}
+	if err := h.loadAlbumDetails(ctx, user, albums); err != nil {
+		return nil, err
+	}
+	return &albums[0], nil
+}
+
+// normalizeAlbum normalizes the album artists of a create or update
+// request, and checks what its validate tags can't: only compilations may
+// have no album artist, and each disc is listed once.
+func normalizeAlbum(album *models.Album) error {
+	album.Artists = normalizeCredits(album.Artists, album.ArtistID)
+	album.ArtistID = primaryArtist(album.Artists)
+	if len(album.Artists) == 0 && !album.Compilation {
+		return validate.Field("artists", "is required unless the album is a compilation")
+	}
+	seen := make(map[int]bool)
+	for i, disc := range album.Discs {
+		if seen[disc.Number] {
+			return validate.Field(fmt.Sprintf("discs[%d].number", i), "repeats disc %d", disc.Number)
+		}
+		seen[disc.Number] = true
+	}
+	return nil
+}
+
+// tagAlbum sets the album of upload to the one an uploaded song's tags
+// name: by its album artist, or else by the song's artist, unless it is a
+// compilation.
+func tagAlbum(upload *repository.Upload, metadata *tags.Metadata) {
+	upload.Album = strings.TrimSpace(metadata.Album)
+	if upload.Album == "" {
+		return
+	}
+	if metadata.Year > 0 {
+		upload.Year = &metadata.Year
+	}
+
+	albumArtist := strings.TrimSpace(metadata.AlbumArtist)
+	if metadata.Compilation || strings.EqualFold(albumArtist, variousArtists) {
+		upload.Compilation = true
+		return
+	}
+	upload.AlbumArtist = albumArtist
+}
+
+// extractAlbumDiscs records the disc layout in an uploaded song's tags on
+// its album, without overwriting what is already known.
+func (h *Handler) extractAlbumDiscs(ctx context.Context, albumID int64, metadata *tags.Metadata) {
+	if metadata == nil {
+		return
+	}
+
+	// Track totals in tags count the tracks of one disc
+	var trackTotal int
+	if metadata.DiscTotal <= 1 {
+		trackTotal = metadata.TrackTotal
+	}
+	if _, err := h.db.ExecContext(ctx, `
+		UPDATE albums
+		SET disc_total = COALESCE(disc_total, ?), track_total = COALESCE(track_total, ?),
+		    version = version + 1
+		WHERE id = ?
+	`, nullInt(metadata.DiscTotal), nullInt(trackTotal), albumID); err != nil {
+		log.Printf("Failed to store disc totals of album %d: %v", albumID, err)
+	}
+
+	if metadata.DiscSubtitle != "" {
+		disc := max(metadata.Disc, 1)
+		if _, err := h.db.ExecContext(ctx, `
+			INSERT INTO album_discs (album_id, disc_number, subtitle)
+			VALUES (?, ?, ?)
+			ON CONFLICT (album_id, disc_number) DO NOTHING
+		`, albumID, disc, metadata.DiscSubtitle); err != nil {
+			log.Printf("Failed to store disc subtitle of album %d: %v", albumID, err)
+		}
+	}
+}
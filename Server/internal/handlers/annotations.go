@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"s3-music-streamer/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// annotatedTables maps the item types users can star and rate to the table
+// holding them.
+var annotatedTables = map[string]string{
+	"song":   "songs",
+	"album":  "albums",
+	"artist": "artists",
+}
+
+type ratingRequest struct {
+	Rating int `json:"rating" validate:"required,min=1,max=5"`
+}
+
+// annotationFilter parses the ?starred=true and ?min_rating=N list
+// filters.
+func annotationFilter(r *http.Request) (repository.AnnotationFilter, error) {
+	var filter repository.AnnotationFilter
+
+	if starredStr := r.URL.Query().Get("starred"); starredStr != "" {
+		starred, err := strconv.ParseBool(starredStr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid starred filter")
+		}
+		filter.Starred = &starred
+	}
+
+	if minRatingStr := r.URL.Query().Get("min_rating"); minRatingStr != "" {
+		minRating, err := strconv.Atoi(minRatingStr)
+		if err != nil || minRating < 1 || minRating > 5 {
+			return filter, fmt.Errorf("min_rating must be between 1 and 5")
+		}
+		filter.MinRating = minRating
+	}
+
+	return filter, nil
+}
+
+// Star returns a handler that stars (PUT) or unstars (DELETE) the item of
+// the given type identified by the {id} URL parameter.
+func (h *Handler) Star(itemType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.annotatedItemID(w, r, itemType)
+		if !ok {
+			return
+		}
+
+		starred := r.Method != http.MethodDelete
+		var starredAt *time.Time
+		if starred {
+			now := time.Now().UTC().Truncate(time.Second)
+			starredAt = &now
+		}
+
+		_, err := h.db.Exec(`
+			INSERT INTO annotations (user_id, item_type, item_id, starred, starred_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, item_type, item_id) DO UPDATE
+			SET starred = excluded.starred, starred_at = excluded.starred_at, updated_at = CURRENT_TIMESTAMP
+		`, currentUser(r), itemType, id, starred, starredAt)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Rate returns a handler that sets (PUT) or clears (DELETE) the user's 1-5
+// rating of the item of the given type identified by the {id} URL parameter.
+func (h *Handler) Rate(itemType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.annotatedItemID(w, r, itemType)
+		if !ok {
+			return
+		}
+
+		var req ratingRequest
+		if r.Method != http.MethodDelete {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			if !h.validate(w, r, &req) {
+				return
+			}
+		}
+
+		_, err := h.db.Exec(`
+			INSERT INTO annotations (user_id, item_type, item_id, rating)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, item_type, item_id) DO UPDATE
+			SET rating = excluded.rating, updated_at = CURRENT_TIMESTAMP
+		`, currentUser(r), itemType, id, req.Rating)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// annotatedItemID parses the {id} URL parameter and checks the item exists,
+// writing an error response if not.
+func (h *Handler) annotatedItemID(w http.ResponseWriter, r *http.Request, itemType string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid "+itemType+" id")
+		return 0, false
+	}
+
+	var exists bool
+	err = h.db.QueryRow("SELECT 1 FROM "+annotatedTables[itemType]+" WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, itemType+" not found")
+		return 0, false
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return 0, false
+	}
+
+	return id, true
+}
@@ -1,46 +1,44 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+	"s3-music-streamer/internal/validate"
 
 	"github.com/go-chi/chi/v5"
 )
 
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
 func (h *Handler) ListArtists(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.Query(`
-		SELECT id, name, bio, created_at, updated_at
-		FROM artists
-		ORDER BY name ASC
-	`)
+	// Support filtering by starred, min_rating and tag via query params
+	annotations, err := annotationFilter(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer rows.Close()
 
-	artists := []models.Artist{}
-	for rows.Next() {
-		var artist models.Artist
-		var bio sql.NullString
-		if err := rows.Scan(
-			&artist.ID, &artist.Name, &bio, &artist.CreatedAt, &artist.UpdatedAt,
-		); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if bio.Valid {
-			artist.Bio = bio.String
-		}
-		artists = append(artists, artist)
+	filter := repository.ArtistFilter{
+		User:             currentUser(r),
+		AnnotationFilter: annotations,
+		Tag:              r.URL.Query().Get("tag"),
 	}
-
-	if err := rows.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	artists, err := h.artists.List(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if err := h.loadArtistDetails(r.Context(), filter.User, artists); err != nil {
+		serverError(w, r, err)
 		return
 	}
 
@@ -52,132 +50,246 @@ func (h *Handler) GetArtist(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid artist id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid artist id")
 		return
 	}
 
-	var artist models.Artist
-	var bio sql.NullString
-	err = h.db.QueryRow(`
-		SELECT id, name, bio, created_at, updated_at
-		FROM artists WHERE id = ?
-	`, id).Scan(
-		&artist.ID, &artist.Name, &bio, &artist.CreatedAt, &artist.UpdatedAt,
-	)
-	if err == sql.ErrNoRows {
-		http.Error(w, "artist not found", http.StatusNotFound)
-		return
-	}
+	artist, err := h.getArtist(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "artist")
 		return
 	}
-
-	if bio.Valid {
-		artist.Bio = bio.String
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(artist)
+	writeEntity(w, r, http.StatusOK, itemTag("artist", artist.ID, artist.Version), artist)
 }
 
 func (h *Handler) CreateArtist(w http.ResponseWriter, r *http.Request) {
 	var artist models.Artist
 	if err := json.NewDecoder(r.Body).Decode(&artist); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if artist.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	if err := normalizeArtist(&artist); err != nil {
+		writeValidationError(w, r, err)
 		return
 	}
-
-	var bio *string
-	if artist.Bio != "" {
-		bio = &artist.Bio
+	if !h.validate(w, r, &artist) {
+		return
 	}
 
-	result, err := h.db.Exec(`
-		INSERT INTO artists (name, bio)
-		VALUES (?, ?)
-	`, artist.Name, bio)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.artists.Create(r.Context(), &artist); err != nil {
+		writeRepositoryError(w, r, err, "artist")
 		return
 	}
 
-	id, err := result.LastInsertId()
+	created, err := h.getArtist(r.Context(), currentUser(r), artist.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
-	artist.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(artist)
+	writeEntity(w, r, http.StatusCreated, itemTag("artist", created.ID, created.Version), created)
 }
 
 func (h *Handler) UpdateArtist(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid artist id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid artist id")
 		return
 	}
 
 	var artist models.Artist
 	if err := json.NewDecoder(r.Body).Decode(&artist); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	current, err := h.getArtist(r.Context(), currentUser(r), id)
+	if err != nil {
+		writeRepositoryError(w, r, err, "artist")
+		return
+	}
+	h.updateArtist(w, r, current, artist)
+}
 
-	var bio *string
-	if artist.Bio != "" {
-		bio = &artist.Bio
+// PatchArtist updates the fields of an artist set by a JSON merge patch,
+// keeping the others.
+func (h *Handler) PatchArtist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid artist id")
+		return
 	}
 
-	result, err := h.db.Exec(`
-		UPDATE artists
-		SET name = ?, bio = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, artist.Name, bio, id)
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.getArtist(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "artist")
+		return
+	}
+
+	var artist models.Artist
+	if err := applyMergePatch(current, patch, &artist); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	h.updateArtist(w, r, current, artist)
+}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "artist not found", http.StatusNotFound)
+// updateArtist replaces current, as read by getArtist, by artist, the body
+// of an update.
+func (h *Handler) updateArtist(w http.ResponseWriter, r *http.Request, current *models.Artist, artist models.Artist) {
+	version, ok := h.checkIfMatch(w, r, itemTag("artist", current.ID, current.Version), current.Version)
+	if !ok {
 		return
 	}
 
-	artist.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(artist)
+	if err := normalizeArtist(&artist); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if !h.validate(w, r, &artist) {
+		return
+	}
+
+	artist.ID = current.ID
+	artist.Version = version
+	if err := h.artists.Update(r.Context(), &artist); err != nil {
+		writeRepositoryError(w, r, err, "artist")
+		return
+	}
+
+	updated, err := h.getArtist(r.Context(), currentUser(r), artist.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, itemTag("artist", updated.ID, updated.Version), updated)
 }
 
 func (h *Handler) DeleteArtist(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid artist id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid artist id")
 		return
 	}
 
-	result, err := h.db.Exec("DELETE FROM artists WHERE id = ?", id)
+	current, err := h.getArtist(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "artist")
+		return
+	}
+	version, ok := h.checkIfMatch(w, r, itemTag("artist", current.ID, current.Version), current.Version)
+	if !ok {
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "artist not found", http.StatusNotFound)
+	if err := h.artists.Delete(r.Context(), id, version); err != nil {
+		writeRepositoryError(w, r, err, "artist")
 		return
 	}
+	if err := h.s3.DeletePrefix(r.Context(), artistImage.key(id)); err != nil {
+		log.Printf("Failed to delete image of artist %d: %v", id, err)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// getArtist loads an artist with its links, aliases and the user's tags,
+// returning repository.ErrNotFound if there is no such artist.
+func (h *Handler) getArtist(ctx context.Context, user string, id int64) (*models.Artist, error) {
+	artist, err := h.artists.Get(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	artists := []models.Artist{*artist}
+	if err := h.loadArtistDetails(ctx, user, artists); err != nil {
+		return nil, err
+	}
+	return &artists[0], nil
+}
+
+// loadArtistDetails fills in the user's tags on artists, and where their
+// images are served.
+func (h *Handler) loadArtistDetails(ctx context.Context, user string, artists []models.Artist) error {
+	ids := make([]int64, len(artists))
+	for i, artist := range artists {
+		ids[i] = artist.ID
+	}
+	tags, err := h.loadTags(ctx, user, "artist", ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range artists {
+		artists[i].Tags = tags[artists[i].ID]
+		setArtistImage(&artists[i])
+	}
+	return nil
+}
+
+// setArtistImage sets where the API serves the image of an artist.
+func setArtistImage(artist *models.Artist) {
+	artist.ImageURL = artistImage.url(artist.ID, artist.ImageKey)
+}
+
+// normalizeArtist trims the profile fields of a create or update request,
+// and checks what their validate tags can't.
+func normalizeArtist(artist *models.Artist) error {
+	artist.Name = strings.TrimSpace(artist.Name)
+	artist.SortName = strings.TrimSpace(artist.SortName)
+
+	artist.Country = strings.ToUpper(strings.TrimSpace(artist.Country))
+	if artist.Country != "" && !isCountryCode(artist.Country) {
+		return validate.Field("country", "must be a two-letter ISO 3166-1 code")
+	}
+
+	if artist.FormedYear != nil && artist.DisbandedYear != nil && *artist.DisbandedYear < *artist.FormedYear {
+		return validate.Field("disbanded_year", "must not be before formed_year")
+	}
+
+	for i := range artist.Links {
+		link := &artist.Links[i]
+		link.Type = strings.ToLower(strings.TrimSpace(link.Type))
+		link.URL = strings.TrimSpace(link.URL)
+	}
+
+	// Drop blanks, duplicates and the artist's own name
+	var aliases []string
+	seen := map[string]bool{strings.ToLower(artist.Name): true}
+	for _, alias := range artist.Aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || seen[strings.ToLower(alias)] {
+			continue
+		}
+		seen[strings.ToLower(alias)] = true
+		aliases = append(aliases, alias)
+	}
+	artist.Aliases = aliases
+	return nil
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// nullString stores empty optional text as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullInt stores unset optional numbers as NULL.
+func nullInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"s3-music-streamer/internal/images"
+	"s3-music-streamer/internal/storage"
+	"s3-music-streamer/internal/tags"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxArtworkSize = 10 << 20 // 10 MB
+
+// artwork describes an image attached to a catalog item: the table and
+// column recording its S3 key, and where the original and its thumbnails
+// are stored.
+type artwork struct {
+	name   string // for error messages
+	table  string
+	column string
+	prefix string // S3 key prefix, formatted with the item id
+	path   string // API path serving the image, formatted with the item id
+}
+
+var (
+	albumCover = artwork{
+		name:   "album",
+		table:  "albums",
+		column: "cover_art_key",
+		prefix: "albums/%d/cover",
+		path:   "/api/v1/albums/%d/cover",
+	}
+	artistImage = artwork{
+		name:   "artist",
+		table:  "artists",
+		column: "image_key",
+		prefix: "artists/%d/image",
+		path:   "/api/v1/artists/%d/image",
+	}
+)
+
+func (a artwork) key(id int64) string {
+	return fmt.Sprintf(a.prefix, id)
+}
+
+func (a artwork) thumbnailKey(id int64, size int, format string) string {
+	return fmt.Sprintf("%s_%d.%s", a.key(id), size, format)
+}
+
+// url returns where the API serves the artwork of item id, or "" if the
+// item has no artwork.
+func (a artwork) url(id int64, key string) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf(a.path, id)
+}
+
+func (h *Handler) UploadAlbumCover(w http.ResponseWriter, r *http.Request) {
+	h.uploadArtwork(w, r, albumCover)
+}
+
+func (h *Handler) GetAlbumCover(w http.ResponseWriter, r *http.Request) {
+	h.getArtwork(w, r, albumCover)
+}
+
+func (h *Handler) DeleteAlbumCover(w http.ResponseWriter, r *http.Request) {
+	h.deleteArtwork(w, r, albumCover)
+}
+
+func (h *Handler) UploadArtistImage(w http.ResponseWriter, r *http.Request) {
+	h.uploadArtwork(w, r, artistImage)
+}
+
+func (h *Handler) GetArtistImage(w http.ResponseWriter, r *http.Request) {
+	h.getArtwork(w, r, artistImage)
+}
+
+func (h *Handler) DeleteArtistImage(w http.ResponseWriter, r *http.Request) {
+	h.deleteArtwork(w, r, artistImage)
+}
+
+func (h *Handler) uploadArtwork(w http.ResponseWriter, r *http.Request, a artwork) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid "+a.name+" id")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArtworkSize+1<<20)
+	if err := r.ParseMultipartForm(maxArtworkSize); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.artworkKey(r.Context(), a, id); err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, a.name+" not found")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := h.storeArtwork(r.Context(), a, id, data); err != nil {
+		if errors.Is(err, images.ErrUnsupported) {
+			writeError(w, r, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+		serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getArtwork serves the original image, or with ?size=N a thumbnail no
+// larger than NxN. Thumbnails are JPEG unless ?format=webp is given, and are
+// generated once and cached in S3.
+func (h *Handler) getArtwork(w http.ResponseWriter, r *http.Request, a artwork) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid "+a.name+" id")
+		return
+	}
+
+	var size int
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid size")
+			return
+		}
+		size = images.ThumbnailSize(size)
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = images.FormatJPEG
+	}
+	if format != images.FormatJPEG && format != images.FormatWebP {
+		writeError(w, r, http.StatusBadRequest, "format must be jpeg or webp")
+		return
+	}
+
+	key, err := h.artworkKey(r.Context(), a, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, a.name+" not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if key == "" {
+		writeError(w, r, http.StatusNotFound, a.name+" has no image")
+		return
+	}
+
+	if size > 0 {
+		key = a.thumbnailKey(id, size, format)
+	}
+
+	object, err := h.s3.OpenObject(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) && size > 0 {
+		object, err = h.generateThumbnail(r.Context(), a, id, size, format)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, a.name+" has no image")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	if object.ContentLength > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	}
+	io.Copy(w, object.Body)
+}
+
+func (h *Handler) deleteArtwork(w http.ResponseWriter, r *http.Request, a artwork) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid "+a.name+" id")
+		return
+	}
+
+	result, err := h.db.Exec("UPDATE "+a.table+" SET "+a.column+" = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, a.name+" not found")
+		return
+	}
+
+	// The original and its thumbnails share the key prefix
+	if err := h.s3.DeletePrefix(r.Context(), a.key(id)); err != nil {
+		serverError(w, r, fmt.Errorf("failed to delete from S3: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// artworkKey returns the S3 key of an item's artwork, "" if it has none, or
+// sql.ErrNoRows if the item doesn't exist.
+func (h *Handler) artworkKey(ctx context.Context, a artwork, id int64) (string, error) {
+	var key sql.NullString
+	err := h.db.QueryRowContext(ctx, "SELECT "+a.column+" FROM "+a.table+" WHERE id = ?", id).Scan(&key)
+	return key.String, err
+}
+
+// storeArtwork replaces an item's artwork with the image in data, dropping
+// thumbnails generated from the previous one.
+func (h *Handler) storeArtwork(ctx context.Context, a artwork, id int64, data []byte) error {
+	contentType, err := images.Validate(data)
+	if err != nil {
+		return err
+	}
+
+	key := a.key(id)
+	if err := h.s3.DeletePrefix(ctx, key+"_"); err != nil {
+		return err
+	}
+	if err := h.s3.PutObject(ctx, key, bytes.NewReader(data), contentType); err != nil {
+		return err
+	}
+
+	_, err = h.db.ExecContext(ctx, "UPDATE "+a.table+" SET "+a.column+" = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?", key, id)
+	return err
+}
+
+func (h *Handler) generateThumbnail(ctx context.Context, a artwork, id int64, size int, format string) (*storage.Object, error) {
+	original, err := h.s3.OpenObject(ctx, a.key(id))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(original.Body)
+	original.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := images.Thumbnail(data, size, format)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := images.ContentType(format)
+	if err := h.s3.PutObject(ctx, a.thumbnailKey(id, size, format), bytes.NewReader(thumbnail), contentType); err != nil {
+		// Still serve the thumbnail, it will be generated again next time
+		log.Printf("Failed to cache thumbnail of %s %d: %v", a.name, id, err)
+	}
+
+	return &storage.Object{
+		Body:          io.NopCloser(bytes.NewReader(thumbnail)),
+		ContentType:   contentType,
+		ContentLength: int64(len(thumbnail)),
+	}, nil
+}
+
+// extractAlbumCover uses the picture embedded in an uploaded song as its
+// album's cover, unless the album already has one.
+func (h *Handler) extractAlbumCover(ctx context.Context, albumID int64, metadata *tags.Metadata) {
+	if metadata == nil || metadata.Picture == nil {
+		return
+	}
+
+	key, err := h.artworkKey(ctx, albumCover, albumID)
+	if err != nil || key != "" {
+		return
+	}
+
+	if err := h.storeArtwork(ctx, albumCover, albumID, metadata.Picture.Data); err != nil {
+		log.Printf("Failed to store embedded cover of album %d: %v", albumID, err)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonCacheControl lets clients keep JSON responses, but not use them
+// again without checking they are current.
+const jsonCacheControl = "private, no-cache"
+
+// Conditional answers GET requests for JSON with 304 Not Modified when the
+// client already has the response, as named by If-None-Match. Responses are
+// tagged with the ETag set by their handler, or a hash of their body, and
+// vary with X-User-ID, as they hold the user's stars, ratings and tags.
+// Other responses, such as streams and errors, pass through unchanged.
+func Conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &conditionalWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		cw.finish(r)
+	})
+}
+
+// conditionalWriter holds back successful JSON responses until they are
+// complete, to tag them. Anything else is written through.
+type conditionalWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (cw *conditionalWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	contentType := cw.Header().Get("Content-Type")
+	if status == http.StatusOK && strings.HasPrefix(contentType, "application/json") {
+		cw.buffering = true
+		return
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *conditionalWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		return cw.body.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *conditionalWriter) Flush() {
+	if cw.buffering {
+		return
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *conditionalWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish writes a held back response, or 304 if the client has it.
+func (cw *conditionalWriter) finish(r *http.Request) {
+	if !cw.buffering {
+		return
+	}
+	header := cw.Header()
+	etag := header.Get("ETag")
+	if etag == "" {
+		etag = bodyTag(cw.body.Bytes())
+		header.Set("ETag", etag)
+	}
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", jsonCacheControl)
+	}
+	header.Add("Vary", "X-User-ID")
+
+	if notModified(r, etag, time.Time{}) {
+		header.Del("Content-Type")
+		cw.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(cw.body.Len()))
+	cw.ResponseWriter.WriteHeader(http.StatusOK)
+	cw.ResponseWriter.Write(cw.body.Bytes())
+}
+
+// bodyTag returns an ETag for a response body, a hash of it.
+func bodyTag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the conditions of a GET request show that the
+// client has the representation with etag, last modified at lastModified,
+// if known. As in RFC 9110, If-Modified-Since only counts without
+// If-None-Match, which compares ETags weakly.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Last-Modified is sent in whole seconds
+	return !lastModified.Truncate(time.Second).After(since)
+}
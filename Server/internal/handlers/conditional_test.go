@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/storage"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 20, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name         string
+		headers      []string
+		etag         string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no conditions", nil, `"a"`, modified, false},
+		{"same ETag", []string{"If-None-Match", `"a"`}, `"a"`, time.Time{}, true},
+		{"other ETag", []string{"If-None-Match", `"b"`}, `"a"`, time.Time{}, false},
+		{"one of several", []string{"If-None-Match", `"b", "a" ,"c"`}, `"a"`, time.Time{}, true},
+		{"any", []string{"If-None-Match", "*"}, `"a"`, time.Time{}, true},
+		{"any without an ETag", []string{"If-None-Match", "*"}, "", time.Time{}, false},
+		{"weak tag of a strong ETag", []string{"If-None-Match", `W/"a"`}, `"a"`, time.Time{}, true},
+		{"strong tag of a weak ETag", []string{"If-None-Match", `"a"`}, `W/"a"`, time.Time{}, true},
+		{"weak tags", []string{"If-None-Match", `W/"a"`}, `W/"a"`, time.Time{}, true},
+		{"unmodified since", []string{"If-Modified-Since", "Wed, 01 May 2024 20:00:00 GMT"}, `"a"`, modified, true},
+		{"later", []string{"If-Modified-Since", "Thu, 02 May 2024 20:00:00 GMT"}, `"a"`, modified, true},
+		{"modified since", []string{"If-Modified-Since", "Wed, 01 May 2024 19:59:59 GMT"}, `"a"`, modified, false},
+		{"unknown modification time", []string{"If-Modified-Since", "Wed, 01 May 2024 20:00:00 GMT"}, `"a"`, time.Time{}, false},
+		{"invalid date", []string{"If-Modified-Since", "yesterday"}, `"a"`, modified, false},
+		{
+			"If-None-Match wins",
+			[]string{"If-None-Match", `"b"`, "If-Modified-Since", "Wed, 01 May 2024 20:00:00 GMT"},
+			`"a"`, modified, false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < len(test.headers); i += 2 {
+				r.Header.Set(test.headers[i], test.headers[i+1])
+			}
+			if got := notModified(r, test.etag, test.lastModified); got != test.want {
+				t.Errorf("notModified = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConditional(t *testing.T) {
+	handler := Conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tagged":
+			w.Header().Set("ETag", `"song-1-v2"`)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id": 1}`)
+		case "/list":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `[1, 2]`)
+		case "/missing":
+			writeError(w, r, http.StatusNotFound, "song not found")
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello")
+		}
+	}))
+
+	// Handlers' ETags are kept
+	w := request(handler, http.MethodGet, "/tagged", "")
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("ETag") != `"song-1-v2"` || w.Body.String() != `{"id": 1}` {
+		t.Errorf("got %s with ETag %s", w.Body, w.Header().Get("ETag"))
+	}
+	if got := w.Header().Get("Cache-Control"); got != jsonCacheControl {
+		t.Errorf("Cache-Control is %q", got)
+	}
+	for _, match := range []string{`"song-1-v2"`, `W/"song-1-v2"`, "*"} {
+		w = request(handler, http.MethodGet, "/tagged", "", "If-None-Match", match)
+		expectStatus(t, w, http.StatusNotModified)
+		if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" || w.Header().Get("ETag") != `"song-1-v2"` {
+			t.Errorf("304 for %s has body %q and headers %v", match, w.Body, w.Header())
+		}
+	}
+	expectStatus(t, request(handler, http.MethodGet, "/tagged", "", "If-None-Match", `"song-1-v1"`), http.StatusOK)
+
+	// Other JSON is tagged with a hash of its body
+	w = request(handler, http.MethodGet, "/list", "")
+	etag := w.Header().Get("ETag")
+	if etag != bodyTag([]byte(`[1, 2]`)) || w.Header().Get("Content-Length") != "6" {
+		t.Errorf("list has ETag %s and headers %v", etag, w.Header())
+	}
+	expectStatus(t, request(handler, http.MethodGet, "/list", "", "If-None-Match", etag), http.StatusNotModified)
+
+	// Errors, other content and other methods pass through untagged
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound},
+		{http.MethodGet, "/text", http.StatusOK},
+		{http.MethodPost, "/list", http.StatusOK},
+	} {
+		w := request(handler, test.method, test.path, "", "If-None-Match", "*")
+		expectStatus(t, w, test.status)
+		if w.Header().Get("ETag") != "" || w.Body.Len() == 0 {
+			t.Errorf("%s %s was tagged %q, with body %q", test.method, test.path, w.Header().Get("ETag"), w.Body)
+		}
+	}
+}
+
+func TestItemETags(t *testing.T) {
+	_, router := newTestHandler(t, Options{})
+	expectStatus(t, request(router, http.MethodPost, "/artists", `{"name": "Nina Simone"}`), http.StatusCreated)
+	w := request(router, http.MethodPost, "/songs", `{"title": "Sinnerman", "artist_id": 1}`)
+	expectStatus(t, w, http.StatusCreated)
+	created := w.Header().Get("ETag")
+	if !strings.HasPrefix(created, `"song-1-v1.`) {
+		t.Fatalf("created song has ETag %s", created)
+	}
+
+	get := func(headers ...string) *httptest.ResponseRecorder {
+		return request(router, http.MethodGet, "/songs/1", "", headers...)
+	}
+	w = get("X-User-ID", "nina")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "X-User-ID" {
+		t.Errorf("Vary is %v", vary)
+	}
+	expectStatus(t, get("X-User-ID", "nina", "If-None-Match", etag), http.StatusNotModified)
+
+	// What the version doesn't count still changes the ETag
+	changes := []struct {
+		name, method, target, body string
+	}{
+		{"starring the song", http.MethodPut, "/songs/1/star", ""},
+		{"setting its genres", http.MethodPut, "/songs/1/genres", `["jazz"]`},
+		{"renaming its artist", http.MethodPatch, "/artists/1", `{"name": "Eunice Waymon"}`},
+	}
+	for _, change := range changes {
+		headers := []string{"X-User-ID", "nina"}
+		if change.method == http.MethodPatch {
+			headers = append(headers, "Content-Type", mergePatchType)
+		}
+		w := request(router, change.method, change.target, change.body, headers...)
+		if w.Code >= 300 {
+			t.Fatalf("%s gave %d: %s", change.name, w.Code, w.Body)
+		}
+		w = get("X-User-ID", "nina", "If-None-Match", etag)
+		if w.Code != http.StatusOK {
+			t.Errorf("after %s, the old ETag gave %d", change.name, w.Code)
+			continue
+		}
+		etag = w.Header().Get("ETag")
+	}
+
+	// Other users' responses differ
+	w = get("X-User-ID", "hal", "If-None-Match", etag)
+	expectStatus(t, w, http.StatusOK)
+
+	// but not the version writes check
+	if !strings.HasPrefix(etag, `"song-1-v1.`) {
+		t.Fatalf("song has ETag %s", etag)
+	}
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		return request(router, http.MethodPatch, "/songs/1", `{"title": "Sinnerman (live)"}`,
+			"Content-Type", mergePatchType, "If-Match", ifMatch)
+	}
+	expectStatus(t, patch(`"song-1-v1.`), http.StatusPreconditionFailed)
+	expectStatus(t, patch("W/"+etag), http.StatusPreconditionFailed)
+	expectStatus(t, patch(created), http.StatusOK)
+	expectStatus(t, patch(etag), http.StatusPreconditionFailed)
+}
+
+func TestStreamSongConditional(t *testing.T) {
+	const audio = "ID3 not really audio"
+	const objectETag = `"5f0a3b2e"`
+	lastModified := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	var downloads atomic.Int32
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		truncated := strings.HasSuffix(r.URL.Path, "/songs/2/song.mp3")
+		if !strings.HasSuffix(r.URL.Path, "/songs/1/song.mp3") && !truncated {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", objectETag)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(audio)))
+		if r.Method != http.MethodGet {
+			return
+		}
+		downloads.Add(1)
+		if truncated {
+			// The connection drops halfway through the file
+			io.WriteString(w, audio[:len(audio)/2])
+			return
+		}
+		io.WriteString(w, audio)
+	}))
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL", s3.URL)
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	h, router := newTestHandler(t, Options{StreamCacheControl: "private, max-age=3600"})
+	client, err := storage.NewS3Client(context.Background(), "us-east-1", "music", "key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.s3 = client
+	// Large enough that the test's streams don't count as plays
+	for _, title := range []string{"Sinnerman", "Feeling Good"} {
+		song := &models.Song{Title: title, ContentType: "audio/mpeg", FileSize: 1 << 20}
+		if err := h.songs.Create(context.Background(), song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := request(router, http.MethodGet, "/songs/1/stream", "")
+	expectStatus(t, w, http.StatusOK)
+	headers := map[string]string{
+		"ETag":          objectETag,
+		"Last-Modified": "Wed, 01 May 2024 20:00:00 GMT",
+		"Cache-Control": "private, max-age=3600",
+		"Content-Type":  "audio/mpeg",
+	}
+	for name, want := range headers {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s is %q, want %q", name, got, want)
+		}
+	}
+	if w.Body.String() != audio {
+		t.Errorf("streamed %q", w.Body)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "" {
+		t.Errorf("Accept-Ranges is %q, but ranges aren't served", got)
+	}
+
+	tests := []struct {
+		headers []string
+		status  int
+	}{
+		{[]string{"If-None-Match", objectETag}, http.StatusNotModified},
+		{[]string{"If-None-Match", "W/" + objectETag}, http.StatusNotModified},
+		{[]string{"If-None-Match", `"other"`}, http.StatusOK},
+		{[]string{"If-Modified-Since", "Wed, 01 May 2024 20:00:00 GMT"}, http.StatusNotModified},
+		{[]string{"If-Modified-Since", "Tue, 30 Apr 2024 20:00:00 GMT"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		before := downloads.Load()
+		w := request(router, http.MethodGet, "/songs/1/stream", "", test.headers...)
+		if w.Code != test.status {
+			t.Errorf("%v gave %d, want %d", test.headers, w.Code, test.status)
+		}
+		if test.status == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != objectETag) {
+			t.Errorf("%v gave body %q and ETag %q", test.headers, w.Body, w.Header().Get("ETag"))
+		}
+		// Revalidating doesn't download the audio
+		if downloaded := downloads.Load() != before; downloaded != (test.status == http.StatusOK) {
+			t.Errorf("%v downloaded the audio: %t", test.headers, downloaded)
+		}
+	}
+
+	// A stream cut short keeps the status it started with, without an error
+	// appended to the audio
+	w = request(router, http.MethodGet, "/songs/2/stream", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Body.String(); !strings.HasPrefix(audio, got) {
+		t.Errorf("streamed %q", got)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"s3-music-streamer/internal/models"
+)
+
+const (
+	roleMain     = "main"
+	roleFeatured = "featured"
+
+	// variousArtists is shown for compilations without an album artist.
+	variousArtists = "Various Artists"
+)
+
+// maxQueryParams bounds the ids bound in one IN (...) list, well below
+// SQLite's limit on query parameters.
+const maxQueryParams = 500
+
+// creditTable is a join table crediting artists on songs or albums,
+// written by the repositories.
+type creditTable struct {
+	table  string
+	column string // id of the credited song or album
+}
+
+var (
+	songCredits  = creditTable{table: "song_artists", column: "song_id"}
+	albumCredits = creditTable{table: "album_artists", column: "album_id"}
+)
+
+// normalizeCredits cleans up the artists list of a create or update
+// request, defaulting roles to main and dropping repeated credits. Requests
+// without one credit artistID, if set, as main artist.
+func normalizeCredits(artists []models.ArtistCredit, artistID *int64) []models.ArtistCredit {
+	if artists == nil {
+		if artistID == nil {
+			return nil
+		}
+		return []models.ArtistCredit{{ID: *artistID, Role: roleMain}}
+	}
+
+	credits := make([]models.ArtistCredit, 0, len(artists))
+	seen := make(map[models.ArtistCredit]bool)
+	for _, credit := range artists {
+		credit.Name = ""
+		credit.Role = strings.ToLower(strings.TrimSpace(credit.Role))
+		if credit.Role == "" {
+			credit.Role = roleMain
+		}
+		if seen[credit] {
+			continue
+		}
+		seen[credit] = true
+		credits = append(credits, credit)
+	}
+	return credits
+}
+
+// primaryArtist is the artist stored in the artist_id column of songs and
+// albums: the first main artist, or the first credited one.
+func primaryArtist(credits []models.ArtistCredit) *int64 {
+	for _, credit := range credits {
+		if credit.Role == roleMain {
+			return &credit.ID
+		}
+	}
+	if len(credits) > 0 {
+		return &credits[0].ID
+	}
+	return nil
+}
+
+// loadCredits returns the artists credited on each of ids, in order.
+func (h *Handler) loadCredits(ctx context.Context, c creditTable, ids []int64) (map[int64][]models.ArtistCredit, error) {
+	credits := make(map[int64][]models.ArtistCredit, len(ids))
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), maxQueryParams)]
+		ids = ids[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT c.`+c.column+`, ar.id, ar.name, c.role
+			FROM `+c.table+` c
+			JOIN artists ar ON c.artist_id = ar.id
+			WHERE c.`+c.column+` IN (?`+strings.Repeat(", ?", len(batch)-1)+`)
+			ORDER BY c.`+c.column+`, c.position
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var credit models.ArtistCredit
+			if err := rows.Scan(&id, &credit.ID, &credit.Name, &credit.Role); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			credits[id] = append(credits[id], credit)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return credits, nil
+}
+
+// loadSongArtists fills in the credited artists and display string of songs.
+func (h *Handler) loadSongArtists(ctx context.Context, songs []models.Song) error {
+	ids := make([]int64, len(songs))
+	for i, song := range songs {
+		ids[i] = song.ID
+	}
+	credits, err := h.loadCredits(ctx, songCredits, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range songs {
+		songs[i].Artists = credits[songs[i].ID]
+		songs[i].ArtistDisplay = displayArtists(songs[i].Artists)
+		if songs[i].ArtistDisplay == "" {
+			songs[i].ArtistDisplay = songs[i].ArtistName
+		}
+	}
+	return nil
+}
+
+// loadAlbumArtists fills in the album artists and display string of albums.
+func (h *Handler) loadAlbumArtists(ctx context.Context, albums []models.Album) error {
+	ids := make([]int64, len(albums))
+	for i, album := range albums {
+		ids[i] = album.ID
+	}
+	credits, err := h.loadCredits(ctx, albumCredits, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range albums {
+		albums[i].Artists = credits[albums[i].ID]
+		albums[i].ArtistDisplay = displayArtists(albums[i].Artists)
+		if albums[i].ArtistDisplay == "" {
+			albums[i].ArtistDisplay = albums[i].ArtistName
+		}
+		if albums[i].ArtistDisplay == "" && albums[i].Compilation {
+			albums[i].ArtistDisplay = variousArtists
+		}
+	}
+	return nil
+}
+
+// displayArtists formats the main and featured artists of a credit list the
+// way they are usually printed, e.g. "A, B & C feat. D". Other roles are
+// left out.
+func displayArtists(credits []models.ArtistCredit) string {
+	var main, featured []string
+	for _, credit := range credits {
+		switch credit.Role {
+		case roleMain:
+			main = append(main, credit.Name)
+		case roleFeatured:
+			featured = append(featured, credit.Name)
+		}
+	}
+
+	display := joinNames(main)
+	if len(featured) > 0 {
+		if display != "" {
+			display += " "
+		}
+		display += "feat. " + joinNames(featured)
+	}
+	return display
+}
+
+func joinNames(names []string) string {
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " & " + names[len(names)-1]
+}
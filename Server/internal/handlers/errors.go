@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"s3-music-streamer/internal/database"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Error codes beyond those named after the HTTP status of a response.
+const (
+	codeValidation = "validation_failed"
+	codeConstraint = "constraint_violation"
+	codeInternal   = "internal_error"
+)
+
+// apiError is the body of every error response, as
+// {"error": {"code": ..., "message": ...}}. RequestID matches the server's
+// log of the request.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError replies with an error whose code is named after status, such
+// as not_found for 404, or validation_failed for 422.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeErrorDetails(w, r, status, statusCode(status), message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: middleware.GetReqID(r.Context()),
+	}})
+}
+
+// statusCode returns the error code of responses with status.
+func statusCode(status int) string {
+	if status == http.StatusUnprocessableEntity {
+		return codeValidation
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// serverError replies to a request that failed with err. Writes refused by
+// a constraint of the schema are conflicts; other errors are logged, but
+// not shown to the client, which gets the request id to report instead.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if constraint, ok := database.AsConstraintError(err); ok {
+		details := map[string]any{"constraint": constraint.Kind}
+		if len(constraint.Columns) > 0 {
+			details["fields"] = constraint.Columns
+		}
+		writeErrorDetails(w, r, http.StatusConflict, codeConstraint, constraint.Message(), details)
+		return
+	}
+	log.Printf("Request %s failed: %v", middleware.GetReqID(r.Context()), err)
+	writeErrorDetails(w, r, http.StatusInternalServerError, codeInternal, "internal server error", nil)
+}
+
+// NotFound replies to API requests for paths without a route.
+func (h *Handler) NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "no such endpoint")
+}
+
+// MethodNotAllowed replies to API requests using a method their path
+// doesn't support.
+func (h *Handler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
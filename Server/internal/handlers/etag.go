@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Songs, albums and artists are sent with a strong ETag naming the item, its
+// version, which every write of the item increments, and a hash of the
+// response, as in "song-12-v3.1f2e3d4c5b6a7980". The hash covers what the
+// version doesn't: the requesting user's stars, ratings and tags, genres
+// and the names of related artists and albums, so that If-None-Match sees
+// those change too. Updates and deletes sending the ETag back in If-Match
+// only go ahead if the item is still at that version, whatever the hash,
+// which the repositories check as they write.
+
+// itemTag returns the tag of item id of itemType at version, which ETags
+// of the item start with.
+func itemTag(itemType string, id, version int64) string {
+	return `"` + itemType + "-" + strconv.FormatInt(id, 10) + "-v" + strconv.FormatInt(version, 10) + `"`
+}
+
+// representationTag returns the ETag of body, a representation of the item
+// tagged version.
+func representationTag(version string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.TrimSuffix(version, `"`) + "." + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// writeEntity replies with v, a song, album or artist tagged version, and
+// its ETag.
+func writeEntity(w http.ResponseWriter, r *http.Request, status int, version string, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", representationTag(version, body))
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// matchesVersion reports whether candidate, from If-Match, is an ETag of
+// the item tagged version. Weak ETags never match, as If-Match compares
+// strongly.
+func matchesVersion(candidate, version string) bool {
+	if candidate == version {
+		return true
+	}
+	prefix := strings.TrimSuffix(version, `"`) + "."
+	return strings.HasPrefix(candidate, prefix) && len(candidate) > len(prefix) && strings.HasSuffix(candidate, `"`)
+}
+
+// checkIfMatch checks the If-Match header of an update or delete against
+// etag, the tag of the item at version. It returns the version the write
+// must find, or 0 if the request doesn't name one, and whether the request
+// can go on; if not, it has replied.
+func (h *Handler) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string, version int64) (int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if h.options.RequireIfMatch {
+			writeError(w, r, http.StatusPreconditionRequired, "If-Match is required, with the ETag of the item being changed")
+			return 0, false
+		}
+		return 0, true
+	}
+	if strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		if matchesVersion(strings.TrimSpace(candidate), etag) {
+			return version, true
+		}
+	}
+	writeError(w, r, http.StatusPreconditionFailed, "the item was changed since it was read")
+	return 0, false
+}
@@ -0,0 +1,638 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/tags"
+	"s3-music-streamer/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// genreSelect selects the columns read by scanGenre.
+const genreSelect = `
+	SELECT g.id, g.name, g.parent_id, g.created_at,
+	       (SELECT COUNT(*) FROM song_genres sg WHERE sg.genre_id = g.id),
+	       (SELECT COUNT(*) FROM album_genres ag WHERE ag.genre_id = g.id)
+	FROM genres g
+`
+
+// genreTable is a join table linking songs or albums to genres.
+type genreTable struct {
+	table  string
+	column string // id of the linked song or album
+}
+
+var (
+	songGenres  = genreTable{table: "song_genres", column: "song_id"}
+	albumGenres = genreTable{table: "album_genres", column: "album_id"}
+
+	genreTables = map[string]genreTable{
+		"song":  songGenres,
+		"album": albumGenres,
+	}
+)
+
+// set replaces the genres of item id, creating genres that don't exist yet.
+func (g genreTable) set(tx *database.Tx, id int64, names []string) error {
+	if _, err := tx.Exec("DELETE FROM "+g.table+" WHERE "+g.column+" = ?", id); err != nil {
+		return err
+	}
+	return g.add(tx, id, names)
+}
+
+// add links item id to the named genres, keeping the genres it already has.
+func (g genreTable) add(tx *database.Tx, id int64, names []string) error {
+	for _, name := range names {
+		genreID, err := ensureGenre(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO `+g.table+` (`+g.column+`, genre_id) VALUES (?, ?)
+			ON CONFLICT DO NOTHING
+		`, id, genreID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureGenre returns the id of the genre called name, creating it as a
+// top-level genre if there is none.
+func ensureGenre(tx *database.Tx, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM genres WHERE name = ?", name).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	err = tx.QueryRow("INSERT INTO genres (name) VALUES (?) RETURNING id", name).Scan(&id)
+	return id, err
+}
+
+// normalizeNames trims names and drops empty and duplicate ones, ignoring
+// case. It is used for both genres and tags.
+func normalizeNames(names []string) []string {
+	normalized := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
+
+type genreConflictError struct {
+	name    string
+	genreID int64
+}
+
+func (e *genreConflictError) Error() string {
+	return fmt.Sprintf("genre %q already exists with id %d", e.name, e.genreID)
+}
+
+// invalidParentError rejects the parent_id of a genre that doesn't exist or
+// would make the genre its own ancestor.
+type invalidParentError struct {
+	reason string
+}
+
+func (e *invalidParentError) Error() string {
+	return e.reason
+}
+
+func writeGenreError(w http.ResponseWriter, r *http.Request, err error) {
+	var conflict *genreConflictError
+	var parent *invalidParentError
+	switch {
+	case errors.As(err, &conflict):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.As(err, &parent):
+		writeValidationError(w, r, validate.Field("parent_id", "%s", parent.reason))
+	default:
+		serverError(w, r, err)
+	}
+}
+
+func scanGenre(row rowScanner) (models.Genre, error) {
+	var genre models.Genre
+	err := row.Scan(&genre.ID, &genre.Name, &genre.ParentID, &genre.CreatedAt, &genre.SongCount, &genre.AlbumCount)
+	return genre, err
+}
+
+// ListGenres returns the genre hierarchy: top-level genres with their
+// subgenres nested under children.
+func (h *Handler) ListGenres(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(genreSelect + " ORDER BY g.name")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var genres []models.Genre
+	exists := make(map[int64]bool)
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		genres = append(genres, genre)
+		exists[genre.ID] = true
+	}
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	// Group genres by parent, with id 0 for top-level genres
+	children := make(map[int64][]models.Genre)
+	for _, genre := range genres {
+		var parentID int64
+		if genre.ParentID != nil && exists[*genre.ParentID] {
+			parentID = *genre.ParentID
+		}
+		children[parentID] = append(children[parentID], genre)
+	}
+	var nest func(parentID int64) []models.Genre
+	nest = func(parentID int64) []models.Genre {
+		nested := children[parentID]
+		for i := range nested {
+			nested[i].Children = nest(nested[i].ID)
+		}
+		return nested
+	}
+
+	tree := nest(0)
+	if tree == nil {
+		tree = []models.Genre{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (h *Handler) GetGenre(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid genre id")
+		return
+	}
+
+	genre, err := h.getGenre(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "genre not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(genre)
+}
+
+func (h *Handler) CreateGenre(w http.ResponseWriter, r *http.Request) {
+	var genre models.Genre
+	if err := json.NewDecoder(r.Body).Decode(&genre); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	genre.Name = strings.TrimSpace(genre.Name)
+	if !h.validate(w, r, &genre) {
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := checkGenre(tx, 0, &genre); err != nil {
+		writeGenreError(w, r, err)
+		return
+	}
+
+	var id int64
+	err = tx.QueryRow("INSERT INTO genres (name, parent_id) VALUES (?, ?) RETURNING id", genre.Name, genre.ParentID).Scan(&id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	created, err := h.getGenre(r.Context(), id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *Handler) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid genre id")
+		return
+	}
+
+	var genre models.Genre
+	if err := json.NewDecoder(r.Body).Decode(&genre); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	genre.Name = strings.TrimSpace(genre.Name)
+	if !h.validate(w, r, &genre) {
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := checkGenre(tx, id, &genre); err != nil {
+		writeGenreError(w, r, err)
+		return
+	}
+
+	result, err := tx.Exec("UPDATE genres SET name = ?, parent_id = ? WHERE id = ?", genre.Name, genre.ParentID, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "genre not found")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	updated, err := h.getGenre(r.Context(), id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteGenre removes a genre from all songs and albums. Its subgenres move
+// up to its parent.
+func (h *Handler) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid genre id")
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var parentID *int64
+	err = tx.QueryRow("SELECT parent_id FROM genres WHERE id = ?", id).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "genre not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"UPDATE genres SET parent_id = ? WHERE parent_id = ?", []any{parentID, id}},
+		{"DELETE FROM genres WHERE id = ?", []any{id}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetGenres replaces the genres of a song or album with the names in the
+// request body, a JSON array. Unknown genres are created.
+func (h *Handler) SetGenres(itemType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.annotatedItemID(w, r, itemType)
+		if !ok {
+			return
+		}
+
+		var names []string
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		names = normalizeNames(names)
+
+		tx, err := h.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := genreTables[itemType].set(tx, id, names); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		genres, err := h.loadGenres(r.Context(), genreTables[itemType], []int64{id})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(append([]string{}, genres[id]...))
+	}
+}
+
+// getGenre loads a genre with its ancestors and direct subgenres, returning
+// sql.ErrNoRows if there is no such genre.
+func (h *Handler) getGenre(ctx context.Context, id int64) (*models.Genre, error) {
+	genre, err := scanGenre(h.db.QueryRowContext(ctx, genreSelect+" WHERE g.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		WITH RECURSIVE ancestors(id, name, parent_id, depth) AS (
+			SELECT id, name, parent_id, 0 FROM genres WHERE id = ?
+			UNION
+			SELECT g.id, g.name, g.parent_id, a.depth + 1
+			FROM genres g JOIN ancestors a ON g.id = a.parent_id
+		)
+		SELECT name FROM ancestors WHERE depth > 0 ORDER BY depth DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		genre.Path = append(genre.Path, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children, err := h.db.QueryContext(ctx, genreSelect+" WHERE g.parent_id = ? ORDER BY g.name", id)
+	if err != nil {
+		return nil, err
+	}
+	defer children.Close()
+	for children.Next() {
+		child, err := scanGenre(children)
+		if err != nil {
+			return nil, err
+		}
+		genre.Children = append(genre.Children, child)
+	}
+	return &genre, children.Err()
+}
+
+// checkGenre validates the name and parent of genre id, 0 for a new genre,
+// before it is saved.
+func checkGenre(tx *database.Tx, id int64, genre *models.Genre) error {
+	var otherID int64
+	err := tx.QueryRow("SELECT id FROM genres WHERE name = ? AND id != ?", genre.Name, id).Scan(&otherID)
+	if err == nil {
+		return &genreConflictError{name: genre.Name, genreID: otherID}
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	if genre.ParentID == nil {
+		return nil
+	}
+	rows, err := tx.Query(`
+		WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT id, parent_id FROM genres WHERE id = ?
+			UNION
+			SELECT g.id, g.parent_id FROM genres g JOIN ancestors a ON g.id = a.parent_id
+		)
+		SELECT id FROM ancestors
+	`, *genre.ParentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var ancestorID int64
+		if err := rows.Scan(&ancestorID); err != nil {
+			return err
+		}
+		if ancestorID == id {
+			return &invalidParentError{reason: "can't be the genre itself or one of its subgenres"}
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return &invalidParentError{reason: fmt.Sprintf("refers to %d, which doesn't exist", *genre.ParentID)}
+	}
+	return nil
+}
+
+// loadGenres returns the genre names of each of ids, alphabetically.
+func (h *Handler) loadGenres(ctx context.Context, g genreTable, ids []int64) (map[int64][]string, error) {
+	return h.loadNames(ctx, `
+		SELECT l.`+g.column+`, g.name
+		FROM `+g.table+` l
+		JOIN genres g ON l.genre_id = g.id
+		WHERE l.`+g.column+` IN (%s)
+		ORDER BY l.`+g.column+`, g.name
+	`, nil, ids)
+}
+
+// loadNames runs query, which selects an item id and a name, for ids in
+// batches and groups the names by item. The query's "%s" is replaced with
+// the placeholders of a batch, which are bound after args.
+func (h *Handler) loadNames(ctx context.Context, query string, args []any, ids []int64) (map[int64][]string, error) {
+	names := make(map[int64][]string, len(ids))
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), maxQueryParams)]
+		ids = ids[len(batch):]
+
+		batchArgs := append([]any{}, args...)
+		for _, id := range batch {
+			batchArgs = append(batchArgs, id)
+		}
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(query, "?"+strings.Repeat(", ?", len(batch)-1)), batchArgs...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			names[id] = append(names[id], name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// loadSongDetails fills in what is returned alongside the columns of songs:
+// their artists, genres and the user's tags.
+func (h *Handler) loadSongDetails(ctx context.Context, user string, songs []models.Song) error {
+	if err := h.loadSongArtists(ctx, songs); err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(songs))
+	for i, song := range songs {
+		ids[i] = song.ID
+	}
+	genres, err := h.loadGenres(ctx, songGenres, ids)
+	if err != nil {
+		return err
+	}
+	tags, err := h.loadTags(ctx, user, "song", ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range songs {
+		songs[i].Genres = genres[songs[i].ID]
+		songs[i].Tags = tags[songs[i].ID]
+	}
+	return nil
+}
+
+// loadAlbumDetails is loadSongDetails for albums, also setting where their
+// covers are served.
+func (h *Handler) loadAlbumDetails(ctx context.Context, user string, albums []models.Album) error {
+	if err := h.loadAlbumArtists(ctx, albums); err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(albums))
+	for i, album := range albums {
+		ids[i] = album.ID
+	}
+	genres, err := h.loadGenres(ctx, albumGenres, ids)
+	if err != nil {
+		return err
+	}
+	tags, err := h.loadTags(ctx, user, "album", ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range albums {
+		albums[i].Genres = genres[albums[i].ID]
+		albums[i].Tags = tags[albums[i].ID]
+		albums[i].CoverURL = albumCover.url(albums[i].ID, albums[i].CoverArtKey)
+	}
+	return nil
+}
+
+// extractGenres links an uploaded song, and its album, to the genres in the
+// song's tags.
+func (h *Handler) extractGenres(ctx context.Context, songID int64, albumID *int64, metadata *tags.Metadata) {
+	if metadata == nil {
+		return
+	}
+	names := normalizeNames(metadata.Genres)
+	if len(names) == 0 {
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to store genres of song %d: %v", songID, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := songGenres.add(tx, songID, names); err != nil {
+		log.Printf("Failed to store genres of song %d: %v", songID, err)
+		return
+	}
+	if albumID != nil {
+		if err := albumGenres.add(tx, *albumID, names); err != nil {
+			log.Printf("Failed to store genres of album %d: %v", *albumID, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to store genres of song %d: %v", songID, err)
+	}
+}
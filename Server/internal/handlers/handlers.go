@@ -1,94 +1,107 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"s3-music-streamer/internal/audio"
 	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/jobs"
 	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+	"s3-music-streamer/internal/scrobble"
 	"s3-music-streamer/internal/storage"
+	"s3-music-streamer/internal/tags"
+	"s3-music-streamer/internal/validate"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
-	db *database.DB
-	s3 *storage.S3Client
+	db        *database.DB
+	songs     repository.SongRepository
+	albums    repository.AlbumRepository
+	artists   repository.ArtistRepository
+	s3        *storage.S3Client
+	scrobbler *scrobble.Forwarder
+	audio     *audio.Decoder
+	jobs      *jobs.Queue
+	stats     *statsCache
+	validator *validate.Validator
+	options   Options
 }
 
-func New(db *database.DB, s3 *storage.S3Client) *Handler {
-	return &Handler{
-		db: db,
-		s3: s3,
-	}
+// Options configure how the API handles requests.
+type Options struct {
+	// RequireIfMatch refuses updates and deletes of songs, albums and
+	// artists that don't send the ETag of the version they change.
+	RequireIfMatch bool
+	// StreamCacheControl is the Cache-Control header of untranscoded audio
+	// streams.
+	StreamCacheControl string
+}
+
+// New returns the API handlers, registering the background jobs they queue
+// with queue.
+func New(db *database.DB, s3 *storage.S3Client, scrobbler *scrobble.Forwarder, decoder *audio.Decoder, queue *jobs.Queue, options Options) *Handler {
+	h := &Handler{
+		db:        db,
+		songs:     repository.NewSongs(db),
+		albums:    repository.NewAlbums(db),
+		artists:   repository.NewArtists(db),
+		s3:        s3,
+		scrobbler: scrobbler,
+		audio:     decoder,
+		jobs:      queue,
+		stats:     newStatsCache(),
+		options:   options,
+	}
+	h.validator = validate.New(h.exists)
+	h.registerJobs()
+	return h
 }
 
 func (h *Handler) ListSongs(w http.ResponseWriter, r *http.Request) {
-	// Support filtering by artist_id or album_id via query params
-	artistID := r.URL.Query().Get("artist_id")
-	albumID := r.URL.Query().Get("album_id")
-
-	query := `
-		SELECT s.id, s.title, s.artist_id, s.album_id, s.track_number, s.duration, s.file_size,
-		       s.content_type, s.created_at, s.updated_at,
-		       ar.name as artist_name, al.title as album_title
-		FROM songs s
-		LEFT JOIN artists ar ON s.artist_id = ar.id
-		LEFT JOIN albums al ON s.album_id = al.id
-	`
-
-	var rows *sql.Rows
-	var err error
-
-	if artistID != "" {
-		query += " WHERE s.artist_id = ? ORDER BY s.created_at DESC"
-		rows, err = h.db.Query(query, artistID)
-	} else if albumID != "" {
-		query += " WHERE s.album_id = ? ORDER BY s.track_number ASC, s.created_at DESC"
-		rows, err = h.db.Query(query, albumID)
-	} else {
-		query += " ORDER BY s.created_at DESC"
-		rows, err = h.db.Query(query)
+	// Support filtering by artist_id (and role), album_id, genre, tag,
+	// starred and min_rating via query params
+	annotations, err := annotationFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := repository.SongFilter{
+		User:             currentUser(r),
+		AnnotationFilter: annotations,
+		Role:             r.URL.Query().Get("role"),
+		Genre:            r.URL.Query().Get("genre"),
+		Tag:              r.URL.Query().Get("tag"),
+	}
+	if filter.ArtistID, err = queryID(r, "artist_id"); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.AlbumID, err = queryID(r, "album_id"); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
 
+	songs, err := h.songs.List(r.Context(), filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	songs := []models.Song{}
-	for rows.Next() {
-		var song models.Song
-		var artistName, albumTitle sql.NullString
-		var trackNumber sql.NullInt64
-		if err := rows.Scan(
-			&song.ID, &song.Title, &song.ArtistID, &song.AlbumID, &trackNumber, &song.Duration,
-			&song.FileSize, &song.ContentType, &song.CreatedAt, &song.UpdatedAt,
-			&artistName, &albumTitle,
-		); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if trackNumber.Valid {
-			track := int(trackNumber.Int64)
-			song.TrackNumber = &track
-		}
-		if artistName.Valid {
-			song.ArtistName = artistName.String
-		}
-		if albumTitle.Valid {
-			song.AlbumTitle = albumTitle.String
-		}
-		songs = append(songs, song)
+		serverError(w, r, err)
+		return
 	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.loadSongDetails(r.Context(), currentUser(r), songs); err != nil {
+		serverError(w, r, err)
 		return
 	}
 
@@ -96,134 +109,194 @@ func (h *Handler) ListSongs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(songs)
 }
 
+// queryID parses the optional id in query parameter name.
+func queryID(r *http.Request, name string) (*int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &id, nil
+}
+
+// writeRepositoryError responds to an error from a repository, reading or
+// writing the item named item.
+func writeRepositoryError(w http.ResponseWriter, r *http.Request, err error, item string) {
+	var missing *repository.MissingArtistError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, item+" not found")
+	case errors.As(err, &missing):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, repository.ErrConflict):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, repository.ErrModified):
+		writeError(w, r, http.StatusPreconditionFailed, item+" was changed since it was read")
+	default:
+		serverError(w, r, err)
+	}
+}
+
 func (h *Handler) GetSong(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid song id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
 		return
 	}
 
-	var song models.Song
-	var artistName, albumTitle sql.NullString
-	var trackNumber sql.NullInt64
-	err = h.db.QueryRow(`
-		SELECT s.id, s.title, s.artist_id, s.album_id, s.track_number, s.duration, s.file_size,
-		       s.content_type, s.created_at, s.updated_at,
-		       ar.name as artist_name, al.title as album_title
-		FROM songs s
-		LEFT JOIN artists ar ON s.artist_id = ar.id
-		LEFT JOIN albums al ON s.album_id = al.id
-		WHERE s.id = ?
-	`, id).Scan(
-		&song.ID, &song.Title, &song.ArtistID, &song.AlbumID, &trackNumber, &song.Duration,
-		&song.FileSize, &song.ContentType, &song.CreatedAt, &song.UpdatedAt,
-		&artistName, &albumTitle,
-	)
-	if err == sql.ErrNoRows {
-		http.Error(w, "song not found", http.StatusNotFound)
-		return
-	}
+	song, err := h.getSong(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
+	writeEntity(w, r, http.StatusOK, itemTag("song", song.ID, song.Version), song)
+}
 
-	if trackNumber.Valid {
-		track := int(trackNumber.Int64)
-		song.TrackNumber = &track
-	}
-	if artistName.Valid {
-		song.ArtistName = artistName.String
+// getSong loads a song with its artists, genres and the user's tags.
+func (h *Handler) getSong(ctx context.Context, user string, id int64) (*models.Song, error) {
+	song, err := h.songs.Get(ctx, user, id)
+	if err != nil {
+		return nil, err
 	}
-	if albumTitle.Valid {
-		song.AlbumTitle = albumTitle.String
+	songs := []models.Song{*song}
+	if err := h.loadSongDetails(ctx, user, songs); err != nil {
+		return nil, err
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(song)
+	return &songs[0], nil
 }
 
 func (h *Handler) CreateSong(w http.ResponseWriter, r *http.Request) {
 	var song models.Song
 	if err := json.NewDecoder(r.Body).Decode(&song); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	result, err := h.db.Exec(`
-		INSERT INTO songs (title, artist_id, album_id, track_number, duration, file_size, content_type)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, song.Title, song.ArtistID, song.AlbumID, song.TrackNumber, song.Duration, song.FileSize, song.ContentType)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	song.Artists = normalizeCredits(song.Artists, song.ArtistID)
+	song.ArtistID = primaryArtist(song.Artists)
+	if !h.validate(w, r, &song) {
 		return
 	}
 
-	id, err := result.LastInsertId()
+	if err := h.songs.Create(r.Context(), &song); err != nil {
+		writeRepositoryError(w, r, err, "song")
+		return
+	}
+
+	created, err := h.getSong(r.Context(), currentUser(r), song.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
-	song.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(song)
+	writeEntity(w, r, http.StatusCreated, itemTag("song", created.ID, created.Version), created)
 }
 
 func (h *Handler) UpdateSong(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid song id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
 		return
 	}
 
 	var song models.Song
 	if err := json.NewDecoder(r.Body).Decode(&song); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	current, err := h.getSong(r.Context(), currentUser(r), id)
+	if err != nil {
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
+	h.updateSong(w, r, current, song)
+}
 
-	result, err := h.db.Exec(`
-		UPDATE songs
-		SET title = ?, artist_id = ?, album_id = ?, track_number = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, song.Title, song.ArtistID, song.AlbumID, song.TrackNumber, id)
+// PatchSong updates the fields of a song set by a JSON merge patch, keeping
+// the others.
+func (h *Handler) PatchSong(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "song not found", http.StatusNotFound)
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.getSong(r.Context(), currentUser(r), id)
+	if err != nil {
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
 
-	song.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(song)
+	patchCredits(patch)
+	var song models.Song
+	if err := applyMergePatch(current, patch, &song); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.updateSong(w, r, current, song)
+}
+
+// updateSong replaces current, as read by getSong, by song, the body of an
+// update.
+func (h *Handler) updateSong(w http.ResponseWriter, r *http.Request, current *models.Song, song models.Song) {
+	version, ok := h.checkIfMatch(w, r, itemTag("song", current.ID, current.Version), current.Version)
+	if !ok {
+		return
+	}
+
+	song.ID = current.ID
+	song.Version = version
+	song.Artists = normalizeCredits(song.Artists, song.ArtistID)
+	song.ArtistID = primaryArtist(song.Artists)
+	if !h.validate(w, r, &song) {
+		return
+	}
+
+	if err := h.songs.Update(r.Context(), &song); err != nil {
+		writeRepositoryError(w, r, err, "song")
+		return
+	}
+
+	updated, err := h.getSong(r.Context(), currentUser(r), song.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, itemTag("song", updated.ID, updated.Version), updated)
 }
 
 func (h *Handler) DeleteSong(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid song id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
 		return
 	}
 
-	// Check if song exists
-	var exists bool
-	err = h.db.QueryRow("SELECT 1 FROM songs WHERE id = ?", id).Scan(&exists)
-	if err == sql.ErrNoRows {
-		http.Error(w, "song not found", http.StatusNotFound)
+	current, err := h.getSong(r.Context(), currentUser(r), id)
+	if err != nil {
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, ok := h.checkIfMatch(w, r, itemTag("song", current.ID, current.Version), current.Version)
+	if !ok {
+		return
+	}
+
+	// The row goes first, at the version the client saw; audio left behind
+	// by a failed delete is only wasted storage
+	if err := h.songs.Delete(r.Context(), id, version); err != nil {
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
 
@@ -231,13 +304,10 @@ func (h *Handler) DeleteSong(w http.ResponseWriter, r *http.Request) {
 	s3Key := fmt.Sprintf("songs/%d/song.mp3", id)
 
 	if err := h.s3.DeleteObject(r.Context(), s3Key); err != nil {
-		http.Error(w, fmt.Sprintf("failed to delete from S3: %v", err), http.StatusInternalServerError)
-		return
+		log.Printf("Failed to delete audio of song %d: %v", id, err)
 	}
-
-	if _, err := h.db.Exec("DELETE FROM songs WHERE id = ?", id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if err := h.s3.DeletePrefix(r.Context(), fmt.Sprintf("songs/%d/waveform/", id)); err != nil {
+		log.Printf("Failed to delete waveform of song %d: %v", id, err)
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -247,117 +317,296 @@ func (h *Handler) StreamSong(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid song id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
 		return
 	}
 
-	var contentType string
-	err = h.db.QueryRow("SELECT content_type FROM songs WHERE id = ?", id).Scan(&contentType)
-	if err == sql.ErrNoRows {
-		http.Error(w, "song not found", http.StatusNotFound)
-		return
-	}
+	song, err := h.songs.Get(r.Context(), currentUser(r), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, r, err, "song")
 		return
 	}
 
 	// Generate S3 key from ID
 	s3Key := fmt.Sprintf("songs/%d/song.mp3", id)
 
-	body, err := h.s3.GetObject(r.Context(), s3Key)
+	// ?replay_gain=track or album normalizes the volume by transcoding,
+	// when ffmpeg is available and the song has been analyzed
+	var gain float64
+	var applyGain bool
+	if mode := r.URL.Query().Get("replay_gain"); mode != "" {
+		if mode != "track" && mode != "album" {
+			writeError(w, r, http.StatusBadRequest, "replay_gain must be track or album")
+			return
+		}
+		if h.audio.CanTranscode() {
+			if gain, applyGain, err = h.replayGain(r.Context(), id, mode); err != nil {
+				serverError(w, r, err)
+				return
+			}
+		}
+	}
+
+	// The stored audio is validated by its object's ETag and modification
+	// time, looked up before fetching it so that a revalidation doesn't
+	// download the file; transcodes vary with the gain, so they aren't
+	if !applyGain {
+		info, err := h.s3.HeadObject(r.Context(), s3Key)
+		if err != nil {
+			serverError(w, r, fmt.Errorf("failed to get from S3: %w", err))
+			return
+		}
+		if info.ETag != "" {
+			w.Header().Set("ETag", info.ETag)
+		}
+		if !info.LastModified.IsZero() {
+			w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+		}
+		if h.options.StreamCacheControl != "" {
+			w.Header().Set("Cache-Control", h.options.StreamCacheControl)
+		}
+		if notModified(r, info.ETag, info.LastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	object, err := h.s3.OpenObject(r.Context(), s3Key)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get from S3: %v", err), http.StatusInternalServerError)
+		serverError(w, r, fmt.Errorf("failed to get from S3: %w", err))
 		return
 	}
-	defer body.Close()
+	defer object.Body.Close()
+	body := &countingReader{r: object.Body}
+	out := &countingWriter{w: w}
 
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Accept-Ranges", "bytes")
+	startedAt := time.Now().UTC().Truncate(time.Second)
+	if applyGain {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		err = h.audio.Transcode(r.Context(), body, out, gain)
+	} else {
+		w.Header().Set("Content-Type", song.ContentType)
+		if object.ContentLength > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+		}
+		_, err = io.Copy(out, body)
+	}
+	read := body.n
 
-	if _, err := io.Copy(w, body); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Count the stream as a play once most of the file has been served, even
+	// if the client went away before the end
+	if song.FileSize > 0 && float64(read) >= float64(song.FileSize)*streamPlayFraction {
+		played := int(float64(song.Duration) * min(float64(read)/float64(song.FileSize), 1))
+		ctx := context.WithoutCancel(r.Context())
+		if _, err := h.recordPlay(ctx, currentUser(r), id, song.Duration, startedAt, played, "stream"); err != nil {
+			log.Printf("Failed to record play of song %d: %v", id, err)
+		}
+	}
+
+	if err != nil {
+		// Once the audio has started, its status has been sent and the
+		// response can only be cut short
+		if out.n > 0 {
+			log.Printf("Failed to stream song %d: %v", id, err)
+			return
+		}
+		serverError(w, r, err)
 		return
 	}
 }
 
 func (h *Handler) UploadSong(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseMultipartForm(100 << 20); err != nil { // 100 MB max
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "file is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "file is required")
 		return
 	}
 	defer file.Close()
 
-	title := r.FormValue("title")
-	artistIDStr := r.FormValue("artist_id")
-	albumIDStr := r.FormValue("album_id")
-	trackNumberStr := r.FormValue("track_number")
+	form, err := parseUploadForm(r)
+	if err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if !h.validate(w, r, &form) {
+		return
+	}
+	title := strings.TrimSpace(r.FormValue("title"))
+	artistID, albumID := form.ArtistID, form.AlbumID
+	discNumber, trackNumber := form.DiscNumber, form.TrackNumber
+	var discTotal, trackTotal int
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "audio/mpeg"
 	}
 
-	var artistID, albumID *int64
-	var trackNumber *int
-	if artistIDStr != "" {
-		id, err := strconv.ParseInt(artistIDStr, 10, 64)
-		if err == nil {
-			artistID = &id
-		}
+	// Read embedded tags before the upload consumes the file
+	metadata, err := tags.Read(file)
+	if err != nil && err != tags.ErrNoTags {
+		log.Printf("Failed to read tags of %s: %v", header.Filename, err)
 	}
-	if albumIDStr != "" {
-		id, err := strconv.ParseInt(albumIDStr, 10, 64)
-		if err == nil {
-			albumID = &id
-		}
-	}
-	if trackNumberStr != "" {
-		track, err := strconv.Atoi(trackNumberStr)
-		if err == nil {
-			trackNumber = &track
-		}
-	}
-
-	// Step 1: Insert into database first to get an ID
-	result, err := h.db.Exec(`
-		INSERT INTO songs (title, artist_id, album_id, track_number, file_size, content_type)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, title, artistID, albumID, trackNumber, header.Size, contentType)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		serverError(w, r, err)
 		return
 	}
 
-	id, _ := result.LastInsertId()
-
-	// Step 2: Generate S3 key with the ID (fixed path: songs/{id}/song.mp3)
-	s3Key := fmt.Sprintf("songs/%d/song.mp3", id)
+	// Fill in what the form left out from the tags
+	upload := repository.Upload{}
+	if metadata != nil {
+		if title == "" {
+			title = metadata.Title
+		}
+		if trackNumber == nil && metadata.Track > 0 {
+			trackNumber = &metadata.Track
+		}
+		if discNumber == nil && metadata.Disc > 0 {
+			discNumber = &metadata.Disc
+		}
+		discTotal, trackTotal = metadata.DiscTotal, metadata.TrackTotal
+		if artistID == nil {
+			upload.Artist = strings.TrimSpace(metadata.Artist)
+		}
+		if albumID == nil {
+			tagAlbum(&upload, metadata)
+		}
+	}
 
-	// Step 3: Upload to S3
-	if err := h.s3.PutObject(r.Context(), s3Key, file, contentType); err != nil {
-		// Step 4: Delete from database if S3 upload failed
-		h.db.Exec("DELETE FROM songs WHERE id = ?", id)
-		http.Error(w, fmt.Sprintf("failed to upload to S3: %v", err), http.StatusInternalServerError)
-		return
+	if title == "" {
+		title = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
 	}
 
 	song := models.Song{
-		ID:          id,
 		Title:       title,
 		ArtistID:    artistID,
 		AlbumID:     albumID,
+		DiscNumber:  discNumber,
 		TrackNumber: trackNumber,
+		DiscTotal:   discTotal,
+		TrackTotal:  trackTotal,
 		FileSize:    header.Size,
 		ContentType: contentType,
 	}
+	// Names from the tags are held to the rules of the artists and albums
+	// they create, before anything is written
+	tagged := uploadTags{Artist: upload.Artist, Album: upload.Album, AlbumArtist: upload.AlbumArtist, Year: upload.Year}
+	if !h.validate(w, r, &tagged) || !h.validate(w, r, &song) {
+		return
+	}
+
+	// The song, with any artist and album its tags name, is created, then
+	// its audio stored under the song's id; if that fails, the song goes
+	upload.Song = &song
+	var s3Key string
+	err = h.songs.CreateUpload(r.Context(), &upload, func(id int64) error {
+		s3Key = fmt.Sprintf("songs/%d/song.mp3", id)
+		if err := h.s3.PutObject(r.Context(), s3Key, file, contentType); err != nil {
+			return fmt.Errorf("failed to upload to S3: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if s3Key != "" {
+			// The put may have gone through before failing
+			if err := h.s3.DeleteObject(context.WithoutCancel(r.Context()), s3Key); err != nil {
+				log.Printf("Failed to delete %s after failed upload: %v", s3Key, err)
+			}
+		}
+		writeRepositoryError(w, r, err, "song")
+		return
+	}
+	id := song.ID
+	song.Artists = nil
+
+	// Cover art, genres and lyrics from the tags, and loudness and waveform
+	// analysis, are left to background jobs
+	for _, jobType := range []string{jobExtractMetadata, jobAnalyzeSong} {
+		if _, err := h.enqueueSongJob(r.Context(), jobType, id); err != nil {
+			log.Printf("Failed to queue %s for song %d: %v", jobType, id, err)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(song)
 }
+
+// uploadForm holds the fields of an upload form that aren't free text.
+type uploadForm struct {
+	ArtistID    *int64 `json:"artist_id" validate:"exists=artists"`
+	AlbumID     *int64 `json:"album_id" validate:"exists=albums"`
+	DiscNumber  *int   `json:"disc_number" validate:"min=1,max=999"`
+	TrackNumber *int   `json:"track_number" validate:"min=1,max=9999"`
+}
+
+// uploadTags holds the artist and album named by the tags of an upload.
+type uploadTags struct {
+	Artist      string `json:"tags.artist" validate:"max=200"`
+	Album       string `json:"tags.album" validate:"max=500"`
+	AlbumArtist string `json:"tags.album_artist" validate:"max=200"`
+	Year        *int   `json:"tags.year" validate:"min=1000,max=2100"`
+}
+
+// parseUploadForm reads the numeric fields of an upload form, rejecting
+// those that aren't numbers rather than ignoring them.
+func parseUploadForm(r *http.Request) (uploadForm, error) {
+	var form uploadForm
+	var errs validate.Errors
+	parse := func(name string) *int64 {
+		value := strings.TrimSpace(r.FormValue(name))
+		if value == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs = append(errs, validate.FieldError{Field: name, Message: "must be a whole number"})
+			return nil
+		}
+		return &n
+	}
+	parseInt := func(name string) *int {
+		n := parse(name)
+		if n == nil {
+			return nil
+		}
+		i := int(*n)
+		return &i
+	}
+
+	form.ArtistID = parse("artist_id")
+	form.AlbumID = parse("album_id")
+	form.DiscNumber = parseInt("disc_number")
+	form.TrackNumber = parseInt("track_number")
+	if len(errs) > 0 {
+		return form, errs
+	}
+	return form, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
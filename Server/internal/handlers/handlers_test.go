@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+	"s3-music-streamer/internal/jobs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestHandler returns a Handler on an empty SQLite database, without
+// S3, scrobbling or audio decoding, and a router to it.
+func newTestHandler(t *testing.T, options Options) (*Handler, http.Handler) {
+	t.Helper()
+	db := dbtest.OpenMigrated(t, database.SQLite)
+	h := New(db, nil, nil, nil, jobs.NewQueue(db, 1), options)
+
+	r := chi.NewRouter()
+	r.Use(Conditional)
+	r.Get("/artists", h.ListArtists)
+	r.Post("/artists", h.CreateArtist)
+	r.Get("/artists/{id}", h.GetArtist)
+	r.Put("/artists/{id}", h.UpdateArtist)
+	r.Patch("/artists/{id}", h.PatchArtist)
+	r.Delete("/artists/{id}", h.DeleteArtist)
+	r.Post("/albums", h.CreateAlbum)
+	r.Get("/albums/{id}", h.GetAlbum)
+	r.Patch("/albums/{id}", h.PatchAlbum)
+	r.Post("/songs", h.CreateSong)
+	r.Get("/songs/{id}", h.GetSong)
+	r.Patch("/songs/{id}", h.PatchSong)
+	r.Get("/songs/{id}/stream", h.StreamSong)
+	r.Put("/songs/{id}/star", h.Star("song"))
+	r.Put("/songs/{id}/genres", h.SetGenres("song"))
+	r.Post("/playlists", h.CreatePlaylist)
+	r.Get("/playlists/{id}", h.GetPlaylist)
+	r.Put("/playlists/{id}", h.UpdatePlaylist)
+	return h, r
+}
+
+// request sends a request with body, if not empty, and headers, given as
+// name and value pairs, to handler.
+func request(handler http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status %d, want %d: %s", w.Code, want, w.Body)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/scrobble"
+	"s3-music-streamer/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) ListIntegrations(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	rows, err := h.db.Query(`
+		SELECT i.id, i.user_id, i.service, i.username, i.enabled, i.created_at, i.updated_at,
+		       COUNT(CASE WHEN q.status = 'pending' THEN 1 END),
+		       COUNT(CASE WHEN q.status = 'failed' THEN 1 END),
+		       (SELECT last_error FROM scrobble_queue
+		        WHERE integration_id = i.id AND last_error IS NOT NULL
+		        ORDER BY next_attempt_at DESC LIMIT 1)
+		FROM scrobble_integrations i
+		LEFT JOIN scrobble_queue q ON q.integration_id = i.id
+		WHERE i.user_id = ?
+		GROUP BY i.id, i.user_id, i.service, i.username, i.enabled, i.created_at, i.updated_at
+		ORDER BY i.service ASC
+	`, user)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	integrations := []models.ScrobbleIntegration{}
+	for rows.Next() {
+		var integration models.ScrobbleIntegration
+		var lastError sql.NullString
+		if err := rows.Scan(
+			&integration.ID, &integration.UserID, &integration.Service, &integration.Username,
+			&integration.Enabled, &integration.CreatedAt, &integration.UpdatedAt,
+			&integration.Pending, &integration.Failed, &lastError,
+		); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if lastError.Valid {
+			integration.LastError = lastError.String
+		}
+		integrations = append(integrations, integration)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(integrations)
+}
+
+func (h *Handler) PutIntegration(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	serviceName := chi.URLParam(r, "service")
+
+	service, ok := h.scrobbler.Service(serviceName)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "unknown or unconfigured service")
+		return
+	}
+
+	var req models.ScrobbleIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	// Without a token only the enabled flag of an existing integration changes
+	if req.Token == "" {
+		result, err := h.db.Exec(`
+			UPDATE scrobble_integrations
+			SET enabled = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND service = ?
+		`, enabled, user, serviceName)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			writeValidationError(w, r, validate.Field("token", "is required"))
+			return
+		}
+	} else {
+		account, err := service.Authenticate(r.Context(), req.Token)
+		if err != nil {
+			status := http.StatusBadRequest
+			if scrobble.IsTemporary(err) {
+				status = http.StatusBadGateway
+			}
+			writeError(w, r, status, err.Error())
+			return
+		}
+
+		token, err := h.scrobbler.SealToken(account.Token)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		_, err = h.db.Exec(`
+			INSERT INTO scrobble_integrations (user_id, service, token, username, enabled)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, service) DO UPDATE
+			SET token = excluded.token, username = excluded.username, enabled = excluded.enabled,
+			    updated_at = CURRENT_TIMESTAMP
+		`, user, serviceName, token, account.Username, enabled)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+
+	var integration models.ScrobbleIntegration
+	err := h.db.QueryRow(`
+		SELECT id, user_id, service, username, enabled, created_at, updated_at
+		FROM scrobble_integrations
+		WHERE user_id = ? AND service = ?
+	`, user, serviceName).Scan(
+		&integration.ID, &integration.UserID, &integration.Service, &integration.Username,
+		&integration.Enabled, &integration.CreatedAt, &integration.UpdatedAt,
+	)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(integration)
+}
+
+func (h *Handler) DeleteIntegration(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	serviceName := chi.URLParam(r, "service")
+
+	var id int64
+	err := h.db.QueryRow(
+		"SELECT id FROM scrobble_integrations WHERE user_id = ? AND service = ?", user, serviceName,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "integration not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM scrobble_integrations WHERE id = ?", id); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RetryIntegration puts scrobbles that were given up on back in the queue,
+// e.g. after the user fixed their credentials.
+func (h *Handler) RetryIntegration(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	serviceName := chi.URLParam(r, "service")
+
+	result, err := h.db.Exec(`
+		UPDATE scrobble_queue
+		SET status = 'pending', attempts = 0, next_attempt_at = ?
+		WHERE status = 'failed' AND integration_id IN (
+			SELECT id FROM scrobble_integrations WHERE user_id = ? AND service = ?
+		)
+	`, time.Now().UTC(), user, serviceName)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	requeued, _ := result.RowsAffected()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"requeued": requeued})
+}
+
+// songListen describes a song for submission to scrobbling services.
+func (h *Handler) songListen(ctx context.Context, songID int64, listenedAt time.Time) (scrobble.Listen, error) {
+	listen := scrobble.Listen{ListenedAt: listenedAt}
+	var artistName, albumTitle sql.NullString
+	var trackNumber sql.NullInt64
+	err := h.db.QueryRowContext(ctx, `
+		SELECT s.title, s.duration, s.track_number, ar.name, al.title
+		FROM songs s
+		LEFT JOIN artists ar ON s.artist_id = ar.id
+		LEFT JOIN albums al ON s.album_id = al.id
+		WHERE s.id = ?
+	`, songID).Scan(&listen.TrackName, &listen.Duration, &trackNumber, &artistName, &albumTitle)
+	if err != nil {
+		return listen, err
+	}
+
+	listen.ArtistName = artistName.String
+	listen.ReleaseName = albumTitle.String
+	listen.TrackNumber = int(trackNumber.Int64)
+	return listen, nil
+}
+
+// forwardPlay queues a recorded play for the user's scrobbling
+// integrations, or with nowPlaying set, announces it as playing now.
+// Failures are logged rather than failing the request that recorded the
+// play.
+func (h *Handler) forwardPlay(ctx context.Context, user string, songID int64, listenedAt time.Time, nowPlaying bool) {
+	listen, err := h.songListen(ctx, songID, listenedAt)
+	if err == nil && listen.ArtistName == "" {
+		// Scrobbling services require an artist
+		return
+	}
+	if err == nil {
+		if nowPlaying {
+			err = h.scrobbler.NowPlaying(ctx, user, listen)
+		} else {
+			err = h.scrobbler.Enqueue(ctx, user, listen)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to forward play of song %d by %s: %v", songID, user, err)
+	}
+}
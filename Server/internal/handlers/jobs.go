@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"s3-music-streamer/internal/audio"
+	"s3-music-streamer/internal/jobs"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/storage"
+	"s3-music-streamer/internal/tags"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Background job types. Both take a songJob payload.
+const (
+	jobExtractMetadata = "extract_metadata"
+	jobAnalyzeSong     = "analyze_song"
+)
+
+const (
+	defaultJobLimit = 50
+	maxJobLimit     = 500
+)
+
+type songJob struct {
+	SongID int64 `json:"song_id"`
+}
+
+// registerJobs tells the queue how to run each job type.
+func (h *Handler) registerJobs() {
+	h.jobs.Handle(jobExtractMetadata, runSongJob(h.extractMetadata))
+	h.jobs.Handle(jobAnalyzeSong, runSongJob(h.analyzeSong))
+}
+
+// runSongJob adapts a function processing a song to a job. Jobs for songs
+// that have been deleted, or can't be decoded, fail without retrying.
+func runSongJob(run func(ctx context.Context, songID int64) error) jobs.Func {
+	return func(ctx context.Context, payload json.RawMessage) error {
+		var job songJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return jobs.Permanent(fmt.Errorf("invalid payload: %w", err))
+		}
+		err := run(ctx, job.SongID)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, storage.ErrNotFound) || errors.Is(err, audio.ErrUnsupported) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+}
+
+// enqueueSongJob queues a job for a song, returning the job's id.
+func (h *Handler) enqueueSongJob(ctx context.Context, jobType string, songID int64) (int64, error) {
+	return h.jobs.Enqueue(ctx, jobType, songJob{SongID: songID})
+}
+
+// extractMetadata stores what a song's tags hold beyond the fields set on
+// upload: its album's cover and disc layout, its genres and its lyrics. The
+// tags are read again from S3 so the queue doesn't hold embedded pictures.
+func (h *Handler) extractMetadata(ctx context.Context, songID int64) error {
+	var albumID sql.NullInt64
+	err := h.db.QueryRowContext(ctx, "SELECT album_id FROM songs WHERE id = ?", songID).Scan(&albumID)
+	if err != nil {
+		return err
+	}
+
+	object, err := h.s3.OpenObject(ctx, fmt.Sprintf("songs/%d/song.mp3", songID))
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	// Tags can be anywhere in the file, so reading them needs to seek
+	file, err := os.CreateTemp("", "song-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := io.Copy(file, object.Body); err != nil {
+		return fmt.Errorf("failed to download song: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	metadata, err := tags.Read(file)
+	if err == tags.ErrNoTags {
+		return nil
+	}
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var album *int64
+	if albumID.Valid {
+		album = &albumID.Int64
+		h.extractAlbumCover(ctx, albumID.Int64, metadata)
+		h.extractAlbumDiscs(ctx, albumID.Int64, metadata)
+	}
+	h.extractGenres(ctx, songID, album, metadata)
+	h.extractLyrics(ctx, songID, metadata)
+	return nil
+}
+
+const jobSelect = `
+	SELECT id, type, payload, status, attempts, max_attempts, last_error, run_at, started_at, finished_at,
+	       created_at, updated_at
+	FROM jobs
+`
+
+func scanJob(scanner rowScanner) (models.Job, error) {
+	var job models.Job
+	var payload string
+	var lastError sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := scanner.Scan(
+		&job.ID, &job.Type, &payload, &job.Status, &job.Attempts, &job.MaxAttempts, &lastError,
+		&job.RunAt, &startedAt, &finishedAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	job.Payload = json.RawMessage(payload)
+	job.LastError = lastError.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, err
+}
+
+// ListJobs lists jobs, newest first, optionally filtered by ?status= and
+// ?type=. Older jobs are paged through with ?before=<job id>.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	limit := defaultJobLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxJobLimit)
+	}
+
+	var conditions []string
+	var args []any
+	if status := r.URL.Query().Get("status"); status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
+	}
+	if jobType := r.URL.Query().Get("type"); jobType != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, jobType)
+	}
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid before job id")
+			return
+		}
+		conditions = append(conditions, "id < ?")
+		args = append(args, before)
+	}
+
+	query := jobSelect
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		list = append(list, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+	h.writeJob(w, r, id, http.StatusOK)
+}
+
+// RetryJob runs a failed or cancelled job again.
+func (h *Handler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.jobs.Retry(r.Context(), id); err != nil {
+		writeJobError(w, r, err)
+		return
+	}
+	h.writeJob(w, r, id, http.StatusOK)
+}
+
+// CancelJob stops a pending or running job.
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.jobs.Cancel(r.Context(), id); err != nil {
+		writeJobError(w, r, err)
+		return
+	}
+	h.writeJob(w, r, id, http.StatusOK)
+}
+
+func jobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid job id")
+		return 0, false
+	}
+	return id, true
+}
+
+// writeJob responds with the current state of a job.
+func (h *Handler) writeJob(w http.ResponseWriter, r *http.Request, id int64, status int) {
+	job, err := scanJob(h.db.QueryRowContext(r.Context(), jobSelect+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
+
+func writeJobError(w http.ResponseWriter, r *http.Request, err error) {
+	var statusErr *jobs.StatusError
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.As(err, &statusErr):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		serverError(w, r, err)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"s3-music-streamer/internal/audio"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AnalyzeSong queues a new analysis of a song's loudness and waveform, for
+// songs uploaded before analysis existed, and responds with the job.
+func (h *Handler) AnalyzeSong(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRow("SELECT 1 FROM songs WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "song not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	jobID, err := h.enqueueSongJob(r.Context(), jobAnalyzeSong, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	h.writeJob(w, r, jobID, http.StatusAccepted)
+}
+
+// analyzeSong decodes a song from S3 once, storing its waveform in S3 and
+// its loudness, and its duration if it isn't known yet, in the database. It
+// then updates the loudness of its album.
+func (h *Handler) analyzeSong(ctx context.Context, id int64) error {
+	object, err := h.s3.OpenObject(ctx, fmt.Sprintf("songs/%d/song.mp3", id))
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	stream, err := h.audio.Decode(ctx, object.Body)
+	if err != nil {
+		return err
+	}
+	meter := audio.NewLoudnessMeter(stream.SampleRate)
+	waveform := audio.NewWaveformBuilder(stream.SampleRate)
+	frames, err := audio.Process(stream, meter, waveform)
+	if closeErr := stream.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode: %w", err)
+	}
+	if err := h.storeWaveforms(ctx, id, waveform); err != nil {
+		return fmt.Errorf("failed to store waveform: %w", err)
+	}
+
+	result := meter.Result()
+	duration := int(math.Round(float64(frames) / float64(stream.SampleRate)))
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := h.db.ExecContext(ctx, `
+		UPDATE songs
+		SET loudness = ?, true_peak = ?, analyzed_at = ?,
+		    duration = CASE WHEN duration > 0 THEN duration ELSE ? END, version = version + 1
+		WHERE id = ?
+	`, result.Integrated, result.TruePeak, now, duration, id); err != nil {
+		return err
+	}
+
+	var albumID sql.NullInt64
+	if err := h.db.QueryRowContext(ctx, "SELECT album_id FROM songs WHERE id = ?", id).Scan(&albumID); err != nil {
+		return err
+	}
+	if albumID.Valid {
+		return h.updateAlbumLoudness(ctx, albumID.Int64)
+	}
+	return nil
+}
+
+// updateAlbumLoudness recomputes the loudness of an album from its analyzed
+// tracks. The tracks' mean energy, weighted by duration, approximates the
+// loudness of the album played through, and the album peak is the highest
+// track peak.
+func (h *Handler) updateAlbumLoudness(ctx context.Context, albumID int64) error {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT loudness, true_peak, duration FROM songs
+		WHERE album_id = ? AND loudness IS NOT NULL
+	`, albumID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var energy, totalWeight, peak float64
+	for rows.Next() {
+		var loudness, truePeak float64
+		var duration int
+		if err := rows.Scan(&loudness, &truePeak, &duration); err != nil {
+			return err
+		}
+		weight := float64(max(duration, 1))
+		energy += weight * math.Pow(10, loudness/10)
+		totalWeight += weight
+		peak = max(peak, truePeak)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var loudness, truePeak any
+	if totalWeight > 0 {
+		loudness, truePeak = 10*math.Log10(energy/totalWeight), peak
+	}
+	_, err = h.db.ExecContext(ctx, "UPDATE albums SET loudness = ?, true_peak = ?, version = version + 1 WHERE id = ?", loudness, truePeak, albumID)
+	return err
+}
+
+// replayGain returns the gain to apply when streaming a song in mode
+// "track" or "album", lowered if needed to keep the peak from clipping.
+// Album mode falls back to the track gain until the album is analyzed.
+func (h *Handler) replayGain(ctx context.Context, id int64, mode string) (float64, bool, error) {
+	var track, trackPeak, album, albumPeak sql.NullFloat64
+	err := h.db.QueryRowContext(ctx, `
+		SELECT s.loudness, s.true_peak, al.loudness, al.true_peak
+		FROM songs s
+		LEFT JOIN albums al ON s.album_id = al.id
+		WHERE s.id = ?
+	`, id).Scan(&track, &trackPeak, &album, &albumPeak)
+	if err != nil {
+		return 0, false, err
+	}
+
+	loudness, peak := track, trackPeak
+	if mode == "album" && album.Valid {
+		loudness, peak = album, albumPeak
+	}
+	if !loudness.Valid {
+		return 0, false, nil
+	}
+
+	gain := audio.Gain(loudness.Float64)
+	if peak.Float64 > 0 {
+		gain = min(gain, -20*math.Log10(peak.Float64))
+	}
+	return gain, true, nil
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"s3-music-streamer/internal/lyrics"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/tags"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxLyricsSize = 1 << 20 // 1 MB
+
+	lyricsFromTags   = "tags"
+	lyricsFromUpload = "upload"
+)
+
+func (h *Handler) GetLyrics(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	songLyrics, err := h.getLyrics(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "lyrics not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(songLyrics)
+}
+
+// PutLyrics sets the lyrics of a song, replacing any read from its tags.
+// They are either uploaded as a .lrc or .txt file in the "file" field of a
+// multipart form, with an optional "language" field, or sent as JSON with
+// text and language.
+func (h *Handler) PutLyrics(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLyricsSize+1<<10)
+	var req models.Lyrics
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxLyricsSize); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "file is required")
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Text = string(data)
+		req.Language = r.FormValue("language")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req.Text = strings.TrimSpace(strings.TrimPrefix(req.Text, "\ufeff"))
+	if !h.validate(w, r, &req) {
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRow("SELECT 1 FROM songs WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "song not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := h.saveLyrics(r.Context(), id, req.Text, strings.TrimSpace(req.Language), lyricsFromUpload); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	saved, err := h.getLyrics(r.Context(), id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) DeleteLyrics(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM lyrics WHERE song_id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "lyrics not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLyrics loads the lyrics of a song, returning sql.ErrNoRows if it has
+// none.
+func (h *Handler) getLyrics(ctx context.Context, songID int64) (*models.Lyrics, error) {
+	songLyrics := &models.Lyrics{SongID: songID}
+	var language sql.NullString
+	err := h.db.QueryRowContext(ctx, `
+		SELECT text, synced, language, source, created_at, updated_at
+		FROM lyrics WHERE song_id = ?
+	`, songID).Scan(
+		&songLyrics.Text, &songLyrics.Synced, &language, &songLyrics.Source,
+		&songLyrics.CreatedAt, &songLyrics.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	songLyrics.Language = language.String
+
+	if songLyrics.Synced {
+		for _, line := range lyrics.Parse(songLyrics.Text) {
+			songLyrics.Lines = append(songLyrics.Lines, models.LyricLine{
+				Time: line.Time.Milliseconds(),
+				Text: line.Text,
+			})
+		}
+	}
+	return songLyrics, nil
+}
+
+// saveLyrics stores the lyrics of a song, replacing what it had.
+func (h *Handler) saveLyrics(ctx context.Context, songID int64, text, language, source string) error {
+	plainText := text
+	lines := lyrics.Parse(text)
+	if len(lines) > 0 {
+		texts := make([]string, len(lines))
+		for i, line := range lines {
+			texts[i] = line.Text
+		}
+		plainText = strings.Join(texts, "\n")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO lyrics (song_id, text, plain_text, synced, language, source, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(song_id) DO UPDATE
+		SET text = excluded.text, plain_text = excluded.plain_text, synced = excluded.synced,
+		    language = excluded.language, source = excluded.source, updated_at = excluded.updated_at
+	`, songID, text, plainText, len(lines) > 0, nullString(language), source, now, now)
+	return err
+}
+
+// extractLyrics stores the lyrics in an uploaded song's tags.
+func (h *Handler) extractLyrics(ctx context.Context, songID int64, metadata *tags.Metadata) {
+	if metadata == nil || metadata.Lyrics == "" {
+		return
+	}
+	if err := h.saveLyrics(ctx, songID, metadata.Lyrics, metadata.LyricsLanguage, lyricsFromTags); err != nil {
+		log.Printf("Failed to store lyrics of song %d: %v", songID, err)
+	}
+}
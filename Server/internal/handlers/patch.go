@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+// mergePatchType is the media type of JSON merge patches (RFC 7396).
+const mergePatchType = "application/merge-patch+json"
+
+// readMergePatch reads the JSON merge patch in the body of a PATCH request,
+// replying with an error if it isn't a JSON object. Plain application/json
+// bodies are read as merge patches too. It reports whether the request can
+// go on.
+func readMergePatch(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", mergePatchType)
+		writeError(w, r, http.StatusUnsupportedMediaType, "PATCH takes a JSON merge patch, as "+mergePatchType)
+		return nil, false
+	}
+
+	var patch map[string]any
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&patch); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if patch == nil {
+		writeError(w, r, http.StatusBadRequest, "a merge patch must be a JSON object")
+		return nil, false
+	}
+	return patch, true
+}
+
+// applyMergePatch applies patch to the JSON encoding of current, decoding
+// the result into dst. Members of patch replace those of current, objects
+// are merged member by member, and nulls remove members, leaving the fields
+// of dst they would set at their zero value.
+func applyMergePatch(current any, patch map[string]any, dst any) error {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var target any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&target); err != nil {
+		return err
+	}
+
+	if data, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// mergePatch returns target with patch applied, as in section 2 of RFC 7396.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any, len(patchObject))
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// patchCredits makes a patch of the artist_id of a song or album without
+// artists replace its credits, as artist_id does in a full update, rather
+// than leaving the current credits to override it.
+func patchCredits(patch map[string]any) {
+	if _, ok := patch["artist_id"]; !ok {
+		return
+	}
+	if _, ok := patch["artists"]; !ok {
+		patch["artists"] = nil
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"s3-music-streamer/internal/models"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples of appendix A of RFC 7396
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		var target, patch, want any
+		for _, v := range []struct {
+			data string
+			dst  *any
+		}{{test.target, &target}, {test.patch, &patch}, {test.want, &want}} {
+			if err := json.Unmarshal([]byte(v.data), v.dst); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("merging %s into %s gave %v, want %s", test.patch, test.target, got, test.want)
+		}
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	formed := 1958
+	current := models.Artist{
+		ID:         7,
+		Name:       "The Kinks",
+		Bio:        "From Muswell Hill",
+		Country:    "GB",
+		FormedYear: &formed,
+		Aliases:    []string{"Kinks", "The Ravens"},
+		Links:      []models.ArtistLink{{Type: "website", URL: "https://kinks.example"}},
+	}
+	patch := map[string]any{
+		"bio":         nil,
+		"formed_year": nil,
+		"aliases":     []any{"Ravens"},
+		"links":       []any{map[string]any{"type": "wikipedia"}},
+		"sort_name":   "Kinks, The",
+	}
+
+	var got models.Artist
+	if err := applyMergePatch(current, patch, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 7 || got.Name != "The Kinks" || got.Country != "GB" {
+		t.Errorf("fields left out of the patch changed: %+v", got)
+	}
+	if got.Bio != "" || got.FormedYear != nil {
+		t.Errorf("fields set to null were kept: %+v", got)
+	}
+	if got.SortName != "Kinks, The" {
+		t.Errorf("sort_name is %q", got.SortName)
+	}
+
+	// Arrays are replaced, elements and all
+	if !reflect.DeepEqual(got.Aliases, []string{"Ravens"}) {
+		t.Errorf("aliases are %v", got.Aliases)
+	}
+	if len(got.Links) != 1 || got.Links[0] != (models.ArtistLink{Type: "wikipedia"}) {
+		t.Errorf("links are %+v", got.Links)
+	}
+	if current.Bio == "" || len(current.Aliases) != 2 {
+		t.Errorf("the patch changed current: %+v", current)
+	}
+}
+
+func TestApplyMergePatchNestedObjects(t *testing.T) {
+	type settings struct {
+		Theme  string         `json:"theme"`
+		Player map[string]any `json:"player"`
+	}
+	current := settings{Theme: "dark", Player: map[string]any{"volume": 0.5, "shuffle": true, "repeat": "all"}}
+	patch := map[string]any{"player": map[string]any{"volume": json.Number("0.8"), "repeat": nil}}
+
+	var got settings
+	if err := applyMergePatch(current, patch, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := settings{Theme: "dark", Player: map[string]any{"volume": 0.8, "shuffle": true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestApplyMergePatchWrongType(t *testing.T) {
+	var got models.Artist
+	if err := applyMergePatch(models.Artist{Name: "Nina"}, map[string]any{"formed_year": "soon"}, &got); err == nil {
+		t.Error("a string patched into a number field was accepted")
+	}
+}
+
+func TestPatchArtist(t *testing.T) {
+	_, router := newTestHandler(t, Options{})
+	w := request(router, http.MethodPost, "/artists", `{"name": "Nina Simone", "bio": "Singer", "formed_year": 1954}`)
+	expectStatus(t, w, http.StatusCreated)
+	etag := w.Header().Get("ETag")
+
+	patch := func(body string, headers ...string) *httptest.ResponseRecorder {
+		headers = append([]string{"Content-Type", mergePatchType}, headers...)
+		return request(router, http.MethodPatch, "/artists/1", body, headers...)
+	}
+
+	// Patches leaving the artist invalid are refused, changing nothing
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{`{"name": null}`, "name"},
+		{`{"name": "  "}`, "name"},
+		{`{"formed_year": 99}`, "formed_year"},
+		{`{"disbanded_year": 1950}`, "disbanded_year"},
+		{`{"country": "Nowhere"}`, "country"},
+		{`{"links": [{"type": "website", "url": "not a url"}]}`, "links[0].url"},
+		{`{"name": "` + strings.Repeat("x", 201) + `"}`, "name"},
+	}
+	for _, test := range tests {
+		w := patch(test.body)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		var reply struct {
+			Error struct {
+				Details []struct {
+					Field string `json:"field"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if details := reply.Error.Details; len(details) != 1 || details[0].Field != test.field {
+			t.Errorf("patching %s reported %s", test.body, w.Body)
+		}
+	}
+	w = request(router, http.MethodGet, "/artists/1", "")
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("refused patches changed the ETag from %s to %s", etag, got)
+	}
+
+	w = patch(`{"bio": null}`, "If-Match", etag)
+	expectStatus(t, w, http.StatusOK)
+	var artist models.Artist
+	if err := json.Unmarshal(w.Body.Bytes(), &artist); err != nil {
+		t.Fatal(err)
+	}
+	if artist.Name != "Nina Simone" || artist.Bio != "" || artist.FormedYear == nil || *artist.FormedYear != 1954 {
+		t.Errorf("patched to %+v", artist)
+	}
+
+	expectStatus(t, patch(`{"bio": "Pianist"}`, "If-Match", etag), http.StatusPreconditionFailed)
+	expectStatus(t, patch(`["bio"]`), http.StatusBadRequest)
+	expectStatus(t, request(router, http.MethodPatch, "/artists/1", `{"bio": "x"}`, "Content-Type", "text/plain"), http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) ListPlaylists(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`
+		SELECT p.id, p.user_id, p.name, p.description, p.created_at, p.updated_at,
+		       COUNT(s.id), COALESCE(SUM(s.duration), 0)
+		FROM playlists p
+		LEFT JOIN playlist_songs pls ON pls.playlist_id = p.id
+		LEFT JOIN songs s ON pls.song_id = s.id
+		WHERE p.user_id = ?
+		GROUP BY p.id, p.user_id, p.name, p.description, p.created_at, p.updated_at
+		ORDER BY p.name ASC
+	`, currentUser(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	playlists := []models.Playlist{}
+	for rows.Next() {
+		var playlist models.Playlist
+		var description sql.NullString
+		if err := rows.Scan(
+			&playlist.ID, &playlist.UserID, &playlist.Name, &description, &playlist.CreatedAt,
+			&playlist.UpdatedAt, &playlist.SongCount, &playlist.Duration,
+		); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if description.Valid {
+			playlist.Description = description.String
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlists)
+}
+
+func (h *Handler) GetPlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid playlist id")
+		return
+	}
+
+	playlist, err := h.getPlaylist(r.Context(), currentUser(r), id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func (h *Handler) CreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var playlist models.Playlist
+	if err := json.NewDecoder(r.Body).Decode(&playlist); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.validate(w, r, &playlist) {
+		return
+	}
+
+	user := currentUser(r)
+	id, err := h.createPlaylist(r.Context(), user, playlist.Name, playlist.Description, playlist.SongIDs)
+	if err != nil {
+		var missing *missingSongError
+		if errors.As(err, &missing) {
+			writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		serverError(w, r, err)
+		return
+	}
+
+	created, err := h.getPlaylist(r.Context(), user, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *Handler) UpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid playlist id")
+		return
+	}
+
+	var playlist models.Playlist
+	if err := json.NewDecoder(r.Body).Decode(&playlist); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.validate(w, r, &playlist) {
+		return
+	}
+
+	user := currentUser(r)
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var description *string
+	if playlist.Description != "" {
+		description = &playlist.Description
+	}
+
+	result, err := tx.Exec(`
+		UPDATE playlists
+		SET name = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?
+	`, playlist.Name, description, id, user)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "playlist not found")
+		return
+	}
+
+	// Songs are only replaced when song_ids is sent: decoding leaves it nil
+	// when absent or null, and empty for [], which removes every song
+	if playlist.SongIDs != nil {
+		if err := setPlaylistSongs(tx, id, playlist.SongIDs); err != nil {
+			var missing *missingSongError
+			if errors.As(err, &missing) {
+				writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			serverError(w, r, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	updated, err := h.getPlaylist(r.Context(), user, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *Handler) DeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid playlist id")
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM playlists WHERE id = ? AND user_id = ?", id, currentUser(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "playlist not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPlaylist loads one of the user's playlists with its songs, returning
+// sql.ErrNoRows if the user has no such playlist.
+func (h *Handler) getPlaylist(ctx context.Context, user string, id int64) (*models.Playlist, error) {
+	var playlist models.Playlist
+	var description sql.NullString
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, user_id, name, description, created_at, updated_at
+		FROM playlists
+		WHERE id = ? AND user_id = ?
+	`, id, user).Scan(
+		&playlist.ID, &playlist.UserID, &playlist.Name, &description, &playlist.CreatedAt, &playlist.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		playlist.Description = description.String
+	}
+
+	rows, err := h.db.QueryContext(ctx, repository.SongSelect+`
+		JOIN playlist_songs pls ON pls.song_id = s.id
+		WHERE pls.playlist_id = ?
+		ORDER BY pls.position ASC
+	`, user, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if playlist.Songs, err = repository.ScanSongs(rows); err != nil {
+		return nil, err
+	}
+	if err := h.loadSongDetails(ctx, user, playlist.Songs); err != nil {
+		return nil, err
+	}
+	for _, song := range playlist.Songs {
+		playlist.SongCount++
+		playlist.Duration += song.Duration
+	}
+	return &playlist, nil
+}
+
+func (h *Handler) createPlaylist(ctx context.Context, user, name, description string, songIDs []int64) (int64, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var desc *string
+	if description != "" {
+		desc = &description
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO playlists (user_id, name, description)
+		VALUES (?, ?, ?)
+		RETURNING id
+	`, user, name, desc).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := setPlaylistSongs(tx, id, songIDs); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+type missingSongError struct {
+	id int64
+}
+
+func (e *missingSongError) Error() string {
+	return fmt.Sprintf("song %d not found", e.id)
+}
+
+// setPlaylistSongs replaces the songs of a playlist, in order.
+func setPlaylistSongs(tx *database.Tx, playlistID int64, songIDs []int64) error {
+	if _, err := tx.Exec("DELETE FROM playlist_songs WHERE playlist_id = ?", playlistID); err != nil {
+		return err
+	}
+
+	for position, songID := range songIDs {
+		result, err := tx.Exec(`
+			INSERT INTO playlist_songs (playlist_id, song_id, position)
+			SELECT ?, id, ? FROM songs WHERE id = ?
+		`, playlistID, position, songID)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return &missingSongError{id: songID}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"s3-music-streamer/internal/models"
+)
+
+func TestUpdatePlaylistSongs(t *testing.T) {
+	h, router := newTestHandler(t, Options{})
+	for _, title := range []string{"Sinnerman", "Feeling Good", "Four Women"} {
+		if err := h.songs.Create(context.Background(), &models.Song{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStatus(t, request(router, http.MethodPost, "/playlists", `{"name": "Nina", "song_ids": [1, 2]}`), http.StatusCreated)
+
+	update := func(body string) models.Playlist {
+		t.Helper()
+		w := request(router, http.MethodPut, "/playlists/1", body)
+		expectStatus(t, w, http.StatusOK)
+		var playlist models.Playlist
+		if err := json.Unmarshal(w.Body.Bytes(), &playlist); err != nil {
+			t.Fatal(err)
+		}
+		return playlist
+	}
+	songIDs := func(playlist models.Playlist) []int64 {
+		ids := []int64{}
+		for _, song := range playlist.Songs {
+			ids = append(ids, song.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []int64
+	}{
+		{"without song_ids", `{"name": "Renamed"}`, []int64{1, 2}},
+		{"with null song_ids", `{"name": "Renamed", "song_ids": null}`, []int64{1, 2}},
+		{"with song_ids", `{"name": "Renamed", "song_ids": [3, 1]}`, []int64{3, 1}},
+		{"with empty song_ids", `{"name": "Emptied", "song_ids": []}`, []int64{}},
+	}
+	for _, test := range tests {
+		playlist := update(test.body)
+		if got := songIDs(playlist); !slices.Equal(got, test.want) {
+			t.Errorf("updating %s left songs %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	expectStatus(t, request(router, http.MethodPut, "/playlists/1", `{"name": "Lost", "song_ids": [9]}`), http.StatusUnprocessableEntity)
+}
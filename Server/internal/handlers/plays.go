@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"s3-music-streamer/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// Last.fm scrobbling rules: tracks shorter than minScrobbleLength are
+	// never scrobbled, others once half the track or maxScrobbleThreshold
+	// has been played, whichever comes first.
+	minScrobbleLength    = 30 * time.Second
+	maxScrobbleThreshold = 4 * time.Minute
+
+	// streamPlayFraction is how much of a file StreamSong must serve before
+	// the request counts as a play.
+	streamPlayFraction = 0.5
+
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+var errPlayTooShort = errors.New("play too short to scrobble")
+
+func (h *Handler) RecordPlay(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	var req models.PlayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.validate(w, r, &req) {
+		return
+	}
+
+	var duration int
+	err = h.db.QueryRow("SELECT duration FROM songs WHERE id = ?", id).Scan(&duration)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "song not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	startedAt := time.Now().UTC().Truncate(time.Second)
+	if req.Timestamp > 0 {
+		startedAt = time.Unix(req.Timestamp, 0).UTC()
+	}
+	user := currentUser(r)
+
+	if !req.Submission {
+		nowPlaying, err := h.setNowPlaying(r.Context(), user, id, duration, startedAt)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nowPlaying)
+		return
+	}
+
+	played := duration
+	if req.DurationPlayed != nil {
+		played = *req.DurationPlayed
+	}
+	if err := checkScrobble(duration, played); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	play, err := h.recordPlay(r.Context(), user, id, duration, startedAt, played, "api")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(play)
+}
+
+func (h *Handler) ListUserPlays(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	limit := defaultHistoryLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	// Paginate backwards through history with ?before=<unix seconds>
+	before := time.Now().UTC().Add(time.Minute)
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		ts, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid before timestamp")
+			return
+		}
+		before = time.Unix(ts, 0).UTC()
+	}
+
+	rows, err := h.db.Query(`
+		SELECT p.id, p.song_id, p.user_id, p.played_at, p.duration_played, p.source,
+		       s.title, ar.name as artist_name, al.title as album_title
+		FROM plays p
+		JOIN songs s ON p.song_id = s.id
+		LEFT JOIN artists ar ON s.artist_id = ar.id
+		LEFT JOIN albums al ON s.album_id = al.id
+		WHERE p.user_id = ? AND p.played_at < ?
+		ORDER BY p.played_at DESC
+		LIMIT ?
+	`, user, before, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	plays := []models.Play{}
+	for rows.Next() {
+		var play models.Play
+		var artistName, albumTitle sql.NullString
+		if err := rows.Scan(
+			&play.ID, &play.SongID, &play.UserID, &play.PlayedAt, &play.DurationPlayed, &play.Source,
+			&play.SongTitle, &artistName, &albumTitle,
+		); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if artistName.Valid {
+			play.ArtistName = artistName.String
+		}
+		if albumTitle.Valid {
+			play.AlbumTitle = albumTitle.String
+		}
+		plays = append(plays, play)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plays)
+}
+
+func (h *Handler) GetNowPlaying(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	var nowPlaying models.NowPlaying
+	var artistName sql.NullString
+	err := h.db.QueryRow(`
+		SELECT np.user_id, np.song_id, s.title, ar.name as artist_name, np.started_at, np.expires_at
+		FROM now_playing np
+		JOIN songs s ON np.song_id = s.id
+		LEFT JOIN artists ar ON s.artist_id = ar.id
+		WHERE np.user_id = ? AND np.expires_at > ?
+	`, user, time.Now().UTC()).Scan(
+		&nowPlaying.UserID, &nowPlaying.SongID, &nowPlaying.SongTitle, &artistName,
+		&nowPlaying.StartedAt, &nowPlaying.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if artistName.Valid {
+		nowPlaying.ArtistName = artistName.String
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nowPlaying)
+}
+
+// checkScrobble applies the Last.fm rules for whether a play of a song
+// lasting duration seconds, of which played seconds were heard, counts.
+// Songs with an unknown duration are always accepted.
+func checkScrobble(duration, played int) error {
+	if duration <= 0 {
+		return nil
+	}
+	length := time.Duration(duration) * time.Second
+	if length < minScrobbleLength {
+		return errPlayTooShort
+	}
+	threshold := min(length/2, maxScrobbleThreshold)
+	if time.Duration(played)*time.Second < threshold {
+		return errPlayTooShort
+	}
+	return nil
+}
+
+func (h *Handler) setNowPlaying(ctx context.Context, user string, songID int64, duration int, startedAt time.Time) (*models.NowPlaying, error) {
+	// Like Last.fm, now-playing lapses once the track would have finished
+	length := time.Duration(duration) * time.Second
+	if length <= 0 {
+		length = maxScrobbleThreshold
+	}
+	expiresAt := startedAt.Add(length)
+
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO now_playing (user_id, song_id, started_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE
+		SET song_id = excluded.song_id, started_at = excluded.started_at, expires_at = excluded.expires_at
+	`, user, songID, startedAt, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	h.forwardPlay(ctx, user, songID, startedAt, true)
+
+	return &models.NowPlaying{
+		UserID:    user,
+		SongID:    songID,
+		StartedAt: startedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// recordPlay stores a completed play. A stream that was counted
+// automatically and a scrobble sent by the client for the same listen are
+// merged: if the user already has a play of the song that started within
+// one song length of startedAt, that play is returned instead.
+func (h *Handler) recordPlay(ctx context.Context, user string, songID int64, duration int, startedAt time.Time, played int, source string) (*models.Play, error) {
+	window := time.Duration(duration) * time.Second
+	if window < minScrobbleLength {
+		window = minScrobbleLength
+	}
+
+	play := models.Play{
+		SongID: songID,
+		UserID: user,
+		Source: source,
+	}
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, played_at, duration_played, source
+		FROM plays
+		WHERE user_id = ? AND song_id = ? AND played_at > ? AND played_at < ?
+		ORDER BY played_at DESC
+		LIMIT 1
+	`, user, songID, startedAt.Add(-window), startedAt.Add(window)).Scan(
+		&play.ID, &play.PlayedAt, &play.DurationPlayed, &play.Source,
+	)
+	if err == nil {
+		return &play, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var id int64
+	err = h.db.QueryRowContext(ctx, `
+		INSERT INTO plays (song_id, user_id, played_at, duration_played, source)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`, songID, user, startedAt, played, source).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	// A finished play ends the user's now-playing status for that song
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM now_playing WHERE user_id = ? AND song_id = ?", user, songID); err != nil {
+		return nil, err
+	}
+
+	h.stats.invalidate(user)
+	h.forwardPlay(ctx, user, songID, startedAt, false)
+
+	play.ID = id
+	play.PlayedAt = startedAt
+	play.DurationPlayed = played
+	return &play, nil
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchWeights ranks matches by the song_search column they are in: title,
+// artists, album and lyrics.
+var searchWeights = []int{8, 4, 2, 1}
+
+const lyricsColumn = 3
+
+// Search finds songs whose title, artists, album or lyrics contain every
+// word of ?q=, the last one as a prefix so results update while typing.
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	words := searchWords(r.URL.Query().Get("q"))
+	if len(words) == 0 {
+		writeError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	results, err := h.search(r.Context(), currentUser(r), words, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// searchWords splits user input into the words to search for.
+func searchWords(q string) []string {
+	return strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+// ftsQuery turns words into an FTS query matching all of them. Words are
+// quoted so that FTS operators and syntax errors can't come from the input.
+func ftsQuery(words []string) string {
+	terms := make([]string, len(words))
+	for i, word := range words {
+		if i == len(words)-1 {
+			word += "*"
+		}
+		terms[i] = `"` + word + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// tsQuery turns words into a PostgreSQL tsquery matching all of them, each
+// quoted as a lexeme.
+func tsQuery(words []string) string {
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = "'" + strings.ReplaceAll(word, "'", "''") + "'"
+		if i == len(words)-1 {
+			terms[i] += ":*"
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+type searchHit struct {
+	id      int64
+	snippet string
+}
+
+func (h *Handler) search(ctx context.Context, user string, words []string, limit int) ([]models.SearchResult, error) {
+	var hits []searchHit
+	var err error
+	if h.db.Dialect == database.Postgres {
+		hits, err = h.searchTSVector(ctx, words, limit)
+	} else {
+		hits, err = h.searchFTS(ctx, words, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(hits) == 0 {
+		return []models.SearchResult{}, nil
+	}
+
+	args := []any{user}
+	for _, hit := range hits {
+		args = append(args, hit.id)
+	}
+	songRows, err := h.db.QueryContext(ctx, repository.SongSelect+" WHERE s.id IN (?"+strings.Repeat(", ?", len(hits)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer songRows.Close()
+
+	songs, err := repository.ScanSongs(songRows)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.loadSongDetails(ctx, user, songs); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]models.Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+	results := make([]models.SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if song, ok := byID[hit.id]; ok {
+			results = append(results, models.SearchResult{Song: song, LyricsSnippet: hit.snippet})
+		}
+	}
+	return results, nil
+}
+
+// searchFTS finds the best limit matches in SQLite's FTS4 index.
+func (h *Handler) searchFTS(ctx context.Context, words []string, limit int) ([]searchHit, error) {
+	type hit struct {
+		searchHit
+		rank int
+	}
+
+	// FTS4 has no ranking function, so rank in Go from matchinfo: for each
+	// phrase and column, "x" gives the number of hits in the row first.
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT docid, matchinfo(song_search, 'pcx'), snippet(song_search, '', '', '…', `+strconv.Itoa(lyricsColumn)+`, 12)
+		FROM song_search
+		WHERE song_search MATCH ?
+	`, ftsQuery(words))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []hit
+	for rows.Next() {
+		var item hit
+		var info []byte
+		if err := rows.Scan(&item.id, &info, &item.snippet); err != nil {
+			return nil, err
+		}
+
+		phrases := int(binary.NativeEndian.Uint32(info))
+		columns := int(binary.NativeEndian.Uint32(info[4:]))
+		lyricsHits := 0
+		for p := range phrases {
+			for c := range columns {
+				rowHits := int(binary.NativeEndian.Uint32(info[4*(2+3*(p*columns+c)):]))
+				item.rank += searchWeights[c] * rowHits
+				if c == lyricsColumn {
+					lyricsHits += rowHits
+				}
+			}
+		}
+		if lyricsHits == 0 {
+			item.snippet = ""
+		}
+		hits = append(hits, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(hits, func(a, b hit) int {
+		return b.rank - a.rank
+	})
+	best := make([]searchHit, min(len(hits), limit))
+	for i := range best {
+		best[i] = hits[i].searchHit
+	}
+	return best, nil
+}
+
+// searchTSVector finds the best limit matches in PostgreSQL's song_search
+// table, whose document weights the columns like searchWeights.
+func (h *Handler) searchTSVector(ctx context.Context, words []string, limit int) ([]searchHit, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT docid,
+		       CASE WHEN to_tsvector('simple', lyrics) @@ query
+		            THEN ts_headline('simple', lyrics, query,
+		                 'StartSel="",StopSel="",MinWords=6,MaxWords=12,MaxFragments=1,FragmentDelimiter=…')
+		            ELSE '' END
+		FROM song_search, to_tsquery('simple', ?) AS query
+		WHERE document @@ query
+		ORDER BY ts_rank('{0.125, 0.25, 0.5, 1}', document, query) DESC, docid
+		LIMIT ?
+	`, tsQuery(words), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []searchHit
+	for rows.Next() {
+		var hit searchHit
+		if err := rows.Scan(&hit.id, &hit.snippet); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+	"s3-music-streamer/internal/smartplaylist"
+	"s3-music-streamer/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) ListSmartPlaylists(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`
+		SELECT id, user_id, name, description, rules, created_at, updated_at
+		FROM smart_playlists
+		WHERE user_id = ?
+		ORDER BY name ASC
+	`, currentUser(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	playlists := []models.SmartPlaylist{}
+	for rows.Next() {
+		var playlist models.SmartPlaylist
+		var description sql.NullString
+		var rules string
+		if err := rows.Scan(
+			&playlist.ID, &playlist.UserID, &playlist.Name, &description, &rules,
+			&playlist.CreatedAt, &playlist.UpdatedAt,
+		); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if description.Valid {
+			playlist.Description = description.String
+		}
+		playlist.Rules = json.RawMessage(rules)
+		playlists = append(playlists, playlist)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlists)
+}
+
+// GetSmartPlaylist returns the playlist with its songs, found by evaluating
+// its rules now.
+func (h *Handler) GetSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid smart playlist id")
+		return
+	}
+
+	user := currentUser(r)
+	playlist, err := h.getSmartPlaylist(r.Context(), user, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "smart playlist not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if playlist.Songs, err = h.evaluateSmartPlaylist(r.Context(), user, playlist.Rules); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func (h *Handler) CreateSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	var playlist models.SmartPlaylist
+	if err := json.NewDecoder(r.Body).Decode(&playlist); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.validate(w, r, &playlist) {
+		return
+	}
+	if _, err := smartplaylist.Parse(playlist.Rules); err != nil {
+		writeValidationError(w, r, validate.Field("rules", "%v", err))
+		return
+	}
+
+	var description *string
+	if playlist.Description != "" {
+		description = &playlist.Description
+	}
+
+	playlist.UserID = currentUser(r)
+	err := h.db.QueryRow(`
+		INSERT INTO smart_playlists (user_id, name, description, rules)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, playlist.UserID, playlist.Name, description, string(playlist.Rules)).Scan(&playlist.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func (h *Handler) UpdateSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid smart playlist id")
+		return
+	}
+
+	var playlist models.SmartPlaylist
+	if err := json.NewDecoder(r.Body).Decode(&playlist); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.validate(w, r, &playlist) {
+		return
+	}
+	if _, err := smartplaylist.Parse(playlist.Rules); err != nil {
+		writeValidationError(w, r, validate.Field("rules", "%v", err))
+		return
+	}
+
+	var description *string
+	if playlist.Description != "" {
+		description = &playlist.Description
+	}
+
+	playlist.UserID = currentUser(r)
+	result, err := h.db.Exec(`
+		UPDATE smart_playlists
+		SET name = ?, description = ?, rules = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?
+	`, playlist.Name, description, string(playlist.Rules), id, playlist.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "smart playlist not found")
+		return
+	}
+
+	playlist.ID = id
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func (h *Handler) DeleteSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid smart playlist id")
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM smart_playlists WHERE id = ? AND user_id = ?", id, currentUser(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeError(w, r, http.StatusNotFound, "smart playlist not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type snapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// SnapshotSmartPlaylist saves the songs the playlist currently matches as a
+// regular playlist.
+func (h *Handler) SnapshotSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid smart playlist id")
+		return
+	}
+
+	// The body is optional
+	var req snapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := currentUser(r)
+	smart, err := h.getSmartPlaylist(r.Context(), user, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "smart playlist not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	songs, err := h.evaluateSmartPlaylist(r.Context(), user, smart.Rules)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("%s (%s)", smart.Name, time.Now().UTC().Format(dateLayout))
+	}
+	songIDs := make([]int64, len(songs))
+	for i, song := range songs {
+		songIDs[i] = song.ID
+	}
+
+	playlistID, err := h.createPlaylist(r.Context(), user, name, smart.Description, songIDs)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	playlist, err := h.getPlaylist(r.Context(), user, playlistID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func (h *Handler) getSmartPlaylist(ctx context.Context, user string, id int64) (*models.SmartPlaylist, error) {
+	var playlist models.SmartPlaylist
+	var description sql.NullString
+	var rules string
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, user_id, name, description, rules, created_at, updated_at
+		FROM smart_playlists
+		WHERE id = ? AND user_id = ?
+	`, id, user).Scan(
+		&playlist.ID, &playlist.UserID, &playlist.Name, &description, &rules,
+		&playlist.CreatedAt, &playlist.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		playlist.Description = description.String
+	}
+	playlist.Rules = json.RawMessage(rules)
+	return &playlist, nil
+}
+
+// evaluateSmartPlaylist finds the songs currently matching rules for user.
+func (h *Handler) evaluateSmartPlaylist(ctx context.Context, user string, rules json.RawMessage) ([]models.Song, error) {
+	def, err := smartplaylist.Parse(rules)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := def.Compile(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	query := repository.SongSelect + `
+		LEFT JOIN (
+			SELECT song_id, COUNT(*) AS play_count, MAX(played_at) AS last_played
+			FROM plays
+			WHERE user_id = ?
+			GROUP BY song_id
+		) ps ON ps.song_id = s.id
+		WHERE ` + compiled.Where + `
+		ORDER BY ` + compiled.OrderBy
+	args := append([]any{user, user}, compiled.Args...)
+	if compiled.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, compiled.Limit)
+	}
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs, err := repository.ScanSongs(rows)
+	if err != nil {
+		return nil, err
+	}
+	return songs, h.loadSongDetails(ctx, user, songs)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/models"
+)
+
+func TestEvaluateSmartPlaylist(t *testing.T) {
+	h, _ := newTestHandler(t, Options{})
+	ctx := context.Background()
+	ids := map[string]int64{}
+	for _, title := range []string{"Sinnerman", "Feeling Good", "Four Women"} {
+		song := &models.Song{Title: title, ContentType: "audio/mpeg"}
+		if err := h.songs.Create(ctx, song); err != nil {
+			t.Fatal(err)
+		}
+		ids[title] = song.ID
+	}
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := h.db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rate := "INSERT INTO annotations (user_id, item_type, item_id, rating) VALUES (?, 'song', ?, ?)"
+	exec(rate, "nina", ids["Sinnerman"], 5)
+	exec(rate, "nina", ids["Feeling Good"], 2)
+	exec(rate, "hal", ids["Feeling Good"], 5)
+	play := "INSERT INTO plays (song_id, user_id, played_at) VALUES (?, ?, ?)"
+	for range 3 {
+		exec(play, ids["Sinnerman"], "nina", time.Now().UTC())
+	}
+	exec(play, ids["Four Women"], "hal", time.Now().UTC())
+
+	// The user's annotations and plays are bound before the rules' values
+	tests := []struct {
+		user, rules string
+		want        []string
+	}{
+		{"nina", `{"all": [{"field": "rating", "op": "gte", "value": 4}, {"field": "play_count", "op": "gte", "value": 2}]}`, []string{"Sinnerman"}},
+		{"hal", `{"all": [{"field": "rating", "op": "gte", "value": 4}, {"field": "play_count", "op": "gte", "value": 2}]}`, nil},
+		{"hal", `{"any": [{"field": "rating", "op": "gte", "value": 4}, {"field": "play_count", "op": "gte", "value": 1}]}`, []string{"Feeling Good", "Four Women"}},
+		{"nina", `{"field": "title", "op": "starts_with", "value": "F", "sort": "rating", "order": "desc", "limit": 1}`, []string{"Feeling Good"}},
+		{"nina", `{"sort": "play_count", "order": "desc", "limit": 2}`, []string{"Sinnerman", "Feeling Good"}},
+	}
+	for _, test := range tests {
+		songs, err := h.evaluateSmartPlaylist(ctx, test.user, json.RawMessage(test.rules))
+		if err != nil {
+			t.Fatalf("%s for %s: %v", test.rules, test.user, err)
+		}
+		var titles []string
+		for _, song := range songs {
+			titles = append(titles, song.Title)
+		}
+		if !slices.Equal(titles, test.want) {
+			t.Errorf("%s for %s gave %v, want %v", test.rules, test.user, titles, test.want)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"s3-music-streamer/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	statsCacheTTL   = 10 * time.Minute
+	statsCacheSize  = 1000
+	defaultTopLimit = 10
+	maxTopLimit     = 100
+	wrappedTopLimit = 5
+	dateLayout      = "2006-01-02"
+)
+
+// statsCache keeps computed statistics per user and period. Entries expire
+// after statsCacheTTL and are dropped as soon as the user records a play.
+// It holds at most statsCacheSize entries, since every range of dates
+// requested makes one.
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: make(map[string]statsCacheEntry)}
+}
+
+func (c *statsCache) get(user, key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[user+"\x00"+key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *statsCache) set(user, key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= statsCacheSize {
+		c.evict(now)
+	}
+	c.entries[user+"\x00"+key] = statsCacheEntry{value: value, expiresAt: now.Add(statsCacheTTL)}
+}
+
+// evict drops the expired entries, or if none have, the one that would
+// expire first, being the oldest.
+func (c *statsCache) evict(now time.Time) {
+	var oldest string
+	var oldestExpiry time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || entry.expiresAt.Before(oldestExpiry) {
+			oldest, oldestExpiry = key, entry.expiresAt
+		}
+	}
+	if len(c.entries) >= statsCacheSize {
+		delete(c.entries, oldest)
+	}
+}
+
+func (c *statsCache) invalidate(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := user + "\x00"
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cached returns the cached value for user and key, computing and storing it
+// on a miss.
+func cached[T any](c *statsCache, user, key string, compute func() (T, error)) (T, error) {
+	if value, ok := c.get(user, key); ok {
+		return value.(T), nil
+	}
+	value, err := compute()
+	if err != nil {
+		return value, err
+	}
+	c.set(user, key, value)
+	return value, nil
+}
+
+// parsePeriod reads the time range of a stats request, either as explicit
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD dates (inclusive) or as a ?period= of
+// "7d"-style day counts, "week", "month", "year" or "all" (the default).
+func parsePeriod(r *http.Request) (models.StatsPeriod, error) {
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	period := models.StatsPeriod{To: today.AddDate(0, 0, 1)}
+
+	fromStr, toStr := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if fromStr != "" || toStr != "" {
+		if fromStr != "" {
+			from, err := time.Parse(dateLayout, fromStr)
+			if err != nil {
+				return period, fmt.Errorf("invalid from date")
+			}
+			period.From = from
+		}
+		if toStr != "" {
+			to, err := time.Parse(dateLayout, toStr)
+			if err != nil {
+				return period, fmt.Errorf("invalid to date")
+			}
+			period.To = to.AddDate(0, 0, 1)
+		}
+		if !period.From.Before(period.To) {
+			return period, fmt.Errorf("from must not be after to")
+		}
+		return period, nil
+	}
+
+	switch p := r.URL.Query().Get("period"); {
+	case p == "" || p == "all":
+		period.From = time.Unix(0, 0).UTC()
+	case p == "week":
+		weekday := (int(today.Weekday()) + 6) % 7 // days since Monday
+		period.From = today.AddDate(0, 0, -weekday)
+	case p == "month":
+		period.From = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case p == "year":
+		period.From = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case strings.HasSuffix(p, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(p, "d"))
+		if err != nil || days <= 0 {
+			return period, fmt.Errorf("invalid period")
+		}
+		period.From = today.AddDate(0, 0, 1-days)
+	default:
+		return period, fmt.Errorf("invalid period")
+	}
+	return period, nil
+}
+
+func periodKey(period models.StatsPeriod) string {
+	return period.From.Format(dateLayout) + ".." + period.To.Format(dateLayout)
+}
+
+func (h *Handler) TopItems(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	kind := chi.URLParam(r, "kind")
+	if kind != "songs" && kind != "artists" && kind != "albums" && kind != "genres" {
+		writeError(w, r, http.StatusNotFound, "kind must be songs, artists, albums or genres")
+		return
+	}
+
+	period, err := parsePeriod(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := defaultTopLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxTopLimit)
+	}
+
+	key := fmt.Sprintf("top:%s:%s:%d", kind, periodKey(period), limit)
+	items, err := cached(h.stats, user, key, func() ([]models.TopItem, error) {
+		return h.topItems(r.Context(), user, kind, period, limit)
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (h *Handler) GetListeningTime(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	period, err := parsePeriod(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if _, ok := h.db.Dialect.DateBucket(interval, "p.played_at"); !ok {
+		writeError(w, r, http.StatusBadRequest, "interval must be day, week or month")
+		return
+	}
+
+	key := fmt.Sprintf("time:%s:%s", interval, periodKey(period))
+	stats, err := cached(h.stats, user, key, func() (*models.ListeningStats, error) {
+		buckets, err := h.listeningTime(r.Context(), user, interval, period)
+		if err != nil {
+			return nil, err
+		}
+		stats := &models.ListeningStats{Period: period, Buckets: buckets}
+		for _, bucket := range buckets {
+			stats.TotalPlays += bucket.Plays
+			stats.ListeningTime += bucket.ListeningTime
+		}
+		return stats, nil
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (h *Handler) GetStreaks(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	key := "streaks:" + today.Format(dateLayout)
+	streaks, err := cached(h.stats, user, key, func() (*models.Streaks, error) {
+		days, err := h.playDays(r.Context(), user, models.StatsPeriod{
+			From: time.Unix(0, 0).UTC(),
+			To:   today.AddDate(0, 0, 1),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return computeStreaks(days, today), nil
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(streaks)
+}
+
+func (h *Handler) GetYearInReview(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+	year, err := strconv.Atoi(chi.URLParam(r, "year"))
+	if err != nil || year < 1970 || year > 9999 {
+		writeError(w, r, http.StatusBadRequest, "invalid year")
+		return
+	}
+
+	key := fmt.Sprintf("wrapped:%d", year)
+	review, err := cached(h.stats, user, key, func() (*models.YearInReview, error) {
+		return h.yearInReview(r.Context(), user, year)
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+func (h *Handler) yearInReview(ctx context.Context, user string, year int) (*models.YearInReview, error) {
+	period := models.StatsPeriod{
+		From: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	review := &models.YearInReview{Year: year}
+
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(p.duration_played), 0),
+		       COUNT(DISTINCT p.song_id), COUNT(DISTINCT s.artist_id)
+		FROM plays p
+		JOIN songs s ON p.song_id = s.id
+		WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+	`, user, period.From, period.To).Scan(
+		&review.TotalPlays, &review.ListeningTime, &review.UniqueSongs, &review.UniqueArtists,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.TopSongs, err = h.topItems(ctx, user, "songs", period, wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if review.TopArtists, err = h.topItems(ctx, user, "artists", period, wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if review.TopAlbums, err = h.topItems(ctx, user, "albums", period, wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if review.TopGenres, err = h.topItems(ctx, user, "genres", period, wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if review.Monthly, err = h.listeningTime(ctx, user, "month", period); err != nil {
+		return nil, err
+	}
+
+	daily, err := h.listeningTime(ctx, user, "day", period)
+	if err != nil {
+		return nil, err
+	}
+	for i := range daily {
+		if review.BusiestDay == nil || daily[i].ListeningTime > review.BusiestDay.ListeningTime {
+			review.BusiestDay = &daily[i]
+		}
+	}
+
+	days := make([]string, len(daily))
+	for i, bucket := range daily {
+		days[i] = bucket.Period
+	}
+	review.LongestStreak = computeStreaks(days, period.To).Longest
+
+	return review, nil
+}
+
+func (h *Handler) topItems(ctx context.Context, user, kind string, period models.StatsPeriod, limit int) ([]models.TopItem, error) {
+	var query string
+	switch kind {
+	case "songs":
+		query = `
+			SELECT s.id, s.title, ar.name, COUNT(*) AS plays, COALESCE(SUM(p.duration_played), 0) AS listening_time
+			FROM plays p
+			JOIN songs s ON p.song_id = s.id
+			LEFT JOIN artists ar ON s.artist_id = ar.id
+			WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+			GROUP BY s.id, s.title, ar.name
+		`
+	case "artists":
+		query = `
+			SELECT ar.id, ar.name, NULL, COUNT(*) AS plays, COALESCE(SUM(p.duration_played), 0) AS listening_time
+			FROM plays p
+			JOIN songs s ON p.song_id = s.id
+			JOIN artists ar ON s.artist_id = ar.id
+			WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+			GROUP BY ar.id, ar.name
+		`
+	case "albums":
+		query = `
+			SELECT al.id, al.title, ar.name, COUNT(*) AS plays, COALESCE(SUM(p.duration_played), 0) AS listening_time
+			FROM plays p
+			JOIN songs s ON p.song_id = s.id
+			JOIN albums al ON s.album_id = al.id
+			LEFT JOIN artists ar ON al.artist_id = ar.id
+			WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+			GROUP BY al.id, al.title, ar.name
+		`
+	case "genres":
+		// Plays of songs with several genres count towards each
+		query = `
+			SELECT g.id, g.name, NULL, COUNT(*) AS plays, COALESCE(SUM(p.duration_played), 0) AS listening_time
+			FROM plays p
+			JOIN song_genres sg ON sg.song_id = p.song_id
+			JOIN genres g ON sg.genre_id = g.id
+			WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+			GROUP BY g.id, g.name
+		`
+	default:
+		return nil, fmt.Errorf("unknown top item kind %q", kind)
+	}
+	query += " ORDER BY plays DESC, listening_time DESC LIMIT ?"
+
+	rows, err := h.db.QueryContext(ctx, query, user, period.From, period.To, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.TopItem{}
+	for rows.Next() {
+		var item models.TopItem
+		var artistName sql.NullString
+		if err := rows.Scan(&item.ID, &item.Name, &artistName, &item.Plays, &item.ListeningTime); err != nil {
+			return nil, err
+		}
+		if artistName.Valid {
+			item.ArtistName = artistName.String
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (h *Handler) listeningTime(ctx context.Context, user, interval string, period models.StatsPeriod) ([]models.ListeningTime, error) {
+	// Weeks start on Monday
+	bucket, ok := h.db.Dialect.DateBucket(interval, "p.played_at")
+	if !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+bucket+` AS bucket, COUNT(*), COALESCE(SUM(p.duration_played), 0)
+		FROM plays p
+		WHERE p.user_id = ? AND p.played_at >= ? AND p.played_at < ?
+		GROUP BY bucket
+		ORDER BY bucket ASC
+	`, user, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []models.ListeningTime{}
+	for rows.Next() {
+		var bucket models.ListeningTime
+		if err := rows.Scan(&bucket.Period, &bucket.Plays, &bucket.ListeningTime); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// playDays returns the distinct days (YYYY-MM-DD, ascending) on which the
+// user played anything.
+func (h *Handler) playDays(ctx context.Context, user string, period models.StatsPeriod) ([]string, error) {
+	buckets, err := h.listeningTime(ctx, user, "day", period)
+	if err != nil {
+		return nil, err
+	}
+	days := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		days[i] = bucket.Period
+	}
+	return days, nil
+}
+
+// computeStreaks finds runs of consecutive days in days, which must be
+// sorted ascending. The current streak is the run ending today or
+// yesterday, so it isn't broken before the user has had a chance to listen
+// today.
+func computeStreaks(days []string, today time.Time) *models.Streaks {
+	streaks := &models.Streaks{}
+
+	var run models.Streak
+	var prev time.Time
+	for _, dayStr := range days {
+		day, err := time.Parse(dateLayout, dayStr)
+		if err != nil {
+			continue
+		}
+		if run.Days > 0 && day.Equal(prev.AddDate(0, 0, 1)) {
+			run.Days++
+			run.End = dayStr
+		} else {
+			run = models.Streak{Days: 1, Start: dayStr, End: dayStr}
+		}
+		if run.Days > streaks.Longest.Days {
+			streaks.Longest = run
+		}
+		prev = day
+	}
+
+	if run.Days > 0 && !prev.Before(today.AddDate(0, 0, -1)) {
+		streaks.Current = run
+	}
+	return streaks
+}
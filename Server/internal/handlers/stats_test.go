@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStatsCacheIsBounded(t *testing.T) {
+	c := newStatsCache()
+	for i := range statsCacheSize * 3 {
+		c.set("nina", fmt.Sprintf("top:%d", i), i)
+	}
+	if len(c.entries) != statsCacheSize {
+		t.Errorf("cache holds %d entries, want %d", len(c.entries), statsCacheSize)
+	}
+
+	// The oldest entries made room for the newest
+	if _, ok := c.get("nina", "top:0"); ok {
+		t.Error("the first entry is still cached")
+	}
+	last := fmt.Sprintf("top:%d", statsCacheSize*3-1)
+	if value, ok := c.get("nina", last); !ok || value != statsCacheSize*3-1 {
+		t.Errorf("the last entry is %v, %t", value, ok)
+	}
+}
+
+func TestStatsCacheEvictsExpiredEntries(t *testing.T) {
+	c := newStatsCache()
+	for i := range statsCacheSize {
+		c.set("nina", fmt.Sprintf("top:%d", i), i)
+	}
+	expired := time.Now().Add(-time.Second)
+	for key, entry := range c.entries {
+		if key != "nina\x00top:7" {
+			entry.expiresAt = expired
+			c.entries[key] = entry
+		}
+	}
+
+	c.set("nina", "week", "new")
+	if len(c.entries) != 2 {
+		t.Errorf("cache holds %d entries after expiry, want 2", len(c.entries))
+	}
+	if value, ok := c.get("nina", "top:7"); !ok || value != 7 {
+		t.Errorf("an entry that hadn't expired is %v, %t", value, ok)
+	}
+}
+
+func TestStatsCacheInvalidate(t *testing.T) {
+	c := newStatsCache()
+	c.set("nina", "week", 1)
+	c.set("nina", "month", 2)
+	c.set("hal", "week", 3)
+
+	c.invalidate("nina")
+	if _, ok := c.get("nina", "week"); ok {
+		t.Error("an invalidated entry is still cached")
+	}
+	if value, ok := c.get("hal", "week"); !ok || value != 3 {
+		t.Errorf("another user's entry is %v, %t", value, ok)
+	}
+}
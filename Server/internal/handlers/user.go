@@ -0,0 +1,19 @@
+package handlers
+
+import "net/http"
+
+const defaultUserID = "default"
+
+// currentUser identifies the listener making the request. There is no
+// authentication yet, so clients pass their user id in the X-User-ID header,
+// or in the "user" query parameter where headers can't be set (e.g. the src
+// of an <audio> element).
+func currentUser(r *http.Request) string {
+	if user := r.Header.Get("X-User-ID"); user != "" {
+		return user
+	}
+	if user := r.URL.Query().Get("user"); user != "" {
+		return user
+	}
+	return defaultUserID
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"s3-music-streamer/internal/models"
+)
+
+// ListTags returns the tags the requesting user has given songs, albums and
+// artists, with how often each is used.
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`
+		SELECT tag,
+		       SUM(CASE WHEN item_type = 'song' THEN 1 ELSE 0 END),
+		       SUM(CASE WHEN item_type = 'album' THEN 1 ELSE 0 END),
+		       SUM(CASE WHEN item_type = 'artist' THEN 1 ELSE 0 END)
+		FROM item_tags
+		WHERE user_id = ?
+		GROUP BY tag COLLATE NOCASE
+		ORDER BY tag COLLATE NOCASE
+	`, currentUser(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(&tag.Name, &tag.SongCount, &tag.AlbumCount, &tag.ArtistCount); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// SetTags replaces the requesting user's tags on a song, album or artist
+// with the ones in the request body, a JSON array of strings.
+func (h *Handler) SetTags(itemType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.annotatedItemID(w, r, itemType)
+		if !ok {
+			return
+		}
+
+		var tags []string
+		if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		tags = normalizeNames(tags)
+		user := currentUser(r)
+
+		tx, err := h.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("DELETE FROM item_tags WHERE user_id = ? AND item_type = ? AND item_id = ?", user, itemType, id); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		for _, tag := range tags {
+			if _, err := tx.Exec(`
+				INSERT INTO item_tags (user_id, item_type, item_id, tag) VALUES (?, ?, ?, ?)
+			`, user, itemType, id, tag); err != nil {
+				serverError(w, r, err)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		saved, err := h.loadTags(r.Context(), user, itemType, []int64{id})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(append([]string{}, saved[id]...))
+	}
+}
+
+// loadTags returns the user's tags on each of ids, alphabetically.
+func (h *Handler) loadTags(ctx context.Context, user, itemType string, ids []int64) (map[int64][]string, error) {
+	return h.loadNames(ctx, `
+		SELECT item_id, tag
+		FROM item_tags
+		WHERE user_id = ? AND item_type = ? AND item_id IN (%s)
+		ORDER BY item_id, tag
+	`, []any{user, itemType}, ids)
+}
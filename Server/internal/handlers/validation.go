@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"s3-music-streamer/internal/validate"
+)
+
+// exists reports whether table has a row with id, for the exists rules of
+// payloads.
+func (h *Handler) exists(ctx context.Context, table string, id int64) (bool, error) {
+	var one int
+	err := h.db.QueryRowContext(ctx, "SELECT 1 FROM "+table+" WHERE id = ?", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// validate checks payload against its validate tags, replying with the
+// fields that failed if any did. It reports whether the request can go on.
+func (h *Handler) validate(w http.ResponseWriter, r *http.Request, payload any) bool {
+	if err := h.validator.Struct(r.Context(), payload); err != nil {
+		writeValidationError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// writeValidationError replies to a payload that failed validation with
+// each field that did, or with a server error if validating failed.
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid validate.Errors
+	if errors.As(err, &invalid) {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeValidation, invalid.Error(), invalid)
+		return
+	}
+	serverError(w, r, err)
+}
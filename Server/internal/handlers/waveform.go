@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"s3-music-streamer/internal/audio"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// waveformResolutions are the widths in pixels waveforms are stored at, from
+// a phone's seek bar to a zoomed-in editor view.
+var waveformResolutions = []int{256, 1024, 4096}
+
+const defaultWaveformResolution = 1024
+
+func waveformKey(songID int64, resolution int) string {
+	return fmt.Sprintf("songs/%d/waveform/%d.json", songID, resolution)
+}
+
+// GetWaveform serves the peaks of a song at the stored resolution closest
+// to ?resolution=, rounding up.
+func (h *Handler) GetWaveform(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid song id")
+		return
+	}
+
+	requested := defaultWaveformResolution
+	if resolutionStr := r.URL.Query().Get("resolution"); resolutionStr != "" {
+		requested, err = strconv.Atoi(resolutionStr)
+		if err != nil || requested <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid resolution")
+			return
+		}
+	}
+	resolution := waveformResolutions[len(waveformResolutions)-1]
+	for _, candidate := range waveformResolutions {
+		if candidate >= requested {
+			resolution = candidate
+			break
+		}
+	}
+
+	var exists bool
+	err = h.db.QueryRow("SELECT 1 FROM songs WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "song not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	object, err := h.s3.OpenObject(r.Context(), waveformKey(id, resolution))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "waveform not generated yet")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	if object.ContentLength > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	}
+	io.Copy(w, object.Body)
+}
+
+// storeWaveforms uploads the waveform of a song at each resolution.
+func (h *Handler) storeWaveforms(ctx context.Context, songID int64, builder *audio.WaveformBuilder) error {
+	for _, resolution := range waveformResolutions {
+		peaks := builder.Waveform(resolution)
+		waveform := models.Waveform{
+			Version:         2,
+			Channels:        1,
+			SampleRate:      peaks.SampleRate,
+			SamplesPerPixel: peaks.SamplesPerPixel,
+			Bits:            8,
+			Length:          len(peaks.Mins),
+			Data:            make([]int8, 0, 2*len(peaks.Mins)),
+		}
+		for i := range peaks.Mins {
+			waveform.Data = append(waveform.Data, peaks.Mins[i], peaks.Maxs[i])
+		}
+
+		data, err := json.Marshal(waveform)
+		if err != nil {
+			return err
+		}
+		if err := h.s3.PutObject(ctx, waveformKey(songID, resolution), bytes.NewReader(data), "application/json"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
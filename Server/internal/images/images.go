@@ -0,0 +1,106 @@
+// Package images validates uploaded artwork and generates thumbnails.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"slices"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+
+	jpegQuality = 85
+
+	// maxPixels bounds the decoded size of uploaded images so a small file
+	// can't claim enormous dimensions and exhaust memory.
+	maxPixels = 50_000_000
+)
+
+// Sizes are the thumbnail sizes generated and cached. Requests for other
+// sizes are served the next size up.
+var Sizes = []int{64, 128, 256, 512, 1024}
+
+var ErrUnsupported = errors.New("unsupported image format")
+
+// Validate checks that data is an image we can decode and returns its
+// content type.
+func Validate(data []byte) (string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrUnsupported
+	}
+	if config.Width*config.Height > maxPixels {
+		return "", fmt.Errorf("image is too large: %dx%d", config.Width, config.Height)
+	}
+	return "image/" + format, nil
+}
+
+// ThumbnailSize snaps a requested size to one of Sizes: the smallest that
+// is at least as big, or the largest.
+func ThumbnailSize(requested int) int {
+	i, _ := slices.BinarySearch(Sizes, requested)
+	if i == len(Sizes) {
+		i--
+	}
+	return Sizes[i]
+}
+
+// ContentType returns the MIME type of thumbnails in format.
+func ContentType(format string) string {
+	if format == FormatWebP {
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// Thumbnail scales the image in data to fit within a size x size square,
+// keeping its aspect ratio, and encodes it as format. Images that are
+// already small enough are re-encoded but not enlarged.
+func Thumbnail(data []byte, size int, format string) ([]byte, error) {
+	if _, err := Validate(data); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	case FormatWebP:
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		return nil, fmt.Errorf("unknown thumbnail format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
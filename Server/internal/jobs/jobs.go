@@ -0,0 +1,487 @@
+// Package jobs runs background work, such as analyzing uploaded songs, from
+// a queue persisted in the database. Jobs survive restarts, failed jobs are
+// retried with exponential backoff, and jobs that keep failing are kept as
+// failed (a dead letter queue) until they are retried or cancelled by hand.
+// Running jobs are leased to their worker, so that servers sharing a
+// database only run them again once the server running them has stopped.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"s3-music-streamer/internal/database"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	jobTimeout   = 30 * time.Minute
+
+	// Failed jobs are retried with exponential backoff from minRetryDelay up
+	// to maxRetryDelay, and given up on after maxAttempts.
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = time.Hour
+	maxAttempts   = 5
+
+	// Running jobs get shutdownGrace to finish when the queue stops before
+	// they are interrupted, to be run again at the next start.
+	shutdownGrace = 30 * time.Second
+
+	// Running jobs hold a lease of leaseDuration, which their worker renews
+	// every renewInterval. Jobs whose lease has run out, their server having
+	// stopped without finishing them, are queued again.
+	leaseDuration = 2 * time.Minute
+	renewInterval = 30 * time.Second
+
+	// Succeeded jobs are deleted after a week.
+	pruneInterval = time.Hour
+	pruneAge      = 7 * 24 * time.Hour
+)
+
+// ErrNotFound is returned when there is no job with the given id.
+var ErrNotFound = errors.New("job not found")
+
+// StatusError is returned when a job can't be retried or cancelled in its
+// current status.
+type StatusError struct {
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("job is %s", e.Status)
+}
+
+// permanentError marks a job error as not worth retrying.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error returned by a job that would fail the same way
+// every time, such as its song having been deleted, so that the job fails
+// immediately instead of being retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Func runs a job with its payload.
+type Func func(ctx context.Context, payload json.RawMessage) error
+
+// Queue stores jobs and runs them on a pool of workers.
+type Queue struct {
+	db      *database.DB
+	workers int
+	funcs   map[string]Func
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
+}
+
+func NewQueue(db *database.DB, workers int) *Queue {
+	return &Queue{
+		db:      db,
+		workers: max(workers, 1),
+		funcs:   make(map[string]Func),
+		wake:    make(chan struct{}, 1),
+		running: make(map[int64]context.CancelFunc),
+	}
+}
+
+// Handle registers the function running jobs of a type. It must be called
+// before Run.
+func (q *Queue) Handle(jobType string, fn Func) {
+	q.funcs[jobType] = fn
+}
+
+// Enqueue adds a job, with payload encoded as JSON, and returns its id.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	var id int64
+	err = q.db.QueryRowContext(ctx, `
+		INSERT INTO jobs (type, payload, status, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, jobType, string(data), StatusPending, maxAttempts, now, now, now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue %s job: %w", jobType, err)
+	}
+	q.notify()
+	return id, nil
+}
+
+// notify wakes up an idle worker.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs jobs until ctx is cancelled, then waits for running jobs to
+// finish. Jobs left running by a process that stopped are queued again once
+// their lease runs out.
+func (q *Queue) Run(ctx context.Context) {
+	// Jobs run outside ctx so that stopping the queue lets them finish
+	jobCtx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, jobCtx)
+		}()
+	}
+	go q.prune(ctx)
+	go q.requeueExpired(ctx)
+
+	<-ctx.Done()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownGrace):
+		log.Printf("Interrupting running jobs")
+		interrupt()
+		<-done
+	}
+}
+
+// work runs due jobs one at a time until ctx is cancelled.
+func (q *Queue) work(ctx, jobCtx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			job, err := q.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to claim job: %v", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(jobCtx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+type job struct {
+	id        int64
+	jobType   string
+	payload   string
+	attempts  int
+	max       int
+	startedAt time.Time // identifies the claim
+}
+
+// claim marks the next due job as running and returns it, or nil if no job
+// is due.
+func (q *Queue) claim(ctx context.Context) (*job, error) {
+	for {
+		var j job
+		err := q.db.QueryRowContext(ctx, `
+			SELECT id, type, payload, attempts, max_attempts FROM jobs
+			WHERE status = ? AND run_at <= ?
+			ORDER BY run_at ASC, id ASC
+			LIMIT 1
+		`, StatusPending, time.Now().UTC()).Scan(&j.id, &j.jobType, &j.payload, &j.attempts, &j.max)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Another worker may have claimed it in the meantime. Times are
+		// kept to the microsecond, as PostgreSQL stores them
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		result, err := q.db.ExecContext(ctx, `
+			UPDATE jobs SET status = ?, attempts = attempts + 1, started_at = ?, locked_until = ?, updated_at = ?
+			WHERE id = ? AND status = ?
+		`, StatusRunning, now, now.Add(leaseDuration), now, j.id, StatusPending)
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+			j.attempts++
+			j.startedAt = now
+			return &j, nil
+		}
+	}
+}
+
+// run runs a claimed job and records the outcome.
+func (q *Queue) run(jobCtx context.Context, j *job) {
+	ctx, cancel := context.WithTimeout(jobCtx, jobTimeout)
+	defer cancel()
+	q.mu.Lock()
+	q.running[j.id] = cancel
+	q.mu.Unlock()
+
+	renewing, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go q.holdLease(renewing, j, cancel)
+
+	var err error
+	if fn, ok := q.funcs[j.jobType]; ok {
+		err = fn(ctx, json.RawMessage(j.payload))
+	} else {
+		err = Permanent(fmt.Errorf("unknown job type %q", j.jobType))
+	}
+
+	stopRenewing()
+	q.mu.Lock()
+	delete(q.running, j.id)
+	q.mu.Unlock()
+
+	if err := q.finish(jobCtx, j, err); err != nil {
+		log.Printf("Failed to record outcome of job %d: %v", j.id, err)
+	}
+}
+
+// holdLease renews the lease of running job j until ctx is cancelled. If
+// the job is no longer this worker's, having been cancelled or, its lease
+// lost, claimed by another worker, it stops the job with cancel.
+func (q *Queue) holdLease(ctx context.Context, j *job, cancel context.CancelFunc) {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, err := q.renewLease(ctx, j)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to renew lease of job %d: %v", j.id, err)
+			}
+			continue
+		}
+		if !held {
+			cancel()
+			return
+		}
+	}
+}
+
+// renewLease extends the lease of running job j, reporting whether the job
+// is still running on its claim.
+func (q *Queue) renewLease(ctx context.Context, j *job) (bool, error) {
+	now := time.Now().UTC()
+	result, err := q.db.ExecContext(ctx, `
+		UPDATE jobs SET locked_until = ?
+		WHERE id = ? AND status = ? AND started_at = ?
+	`, now.Add(leaseDuration), j.id, StatusRunning, j.startedAt)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// finish records the outcome of a job. Jobs cancelled while running keep
+// their status, as do jobs claimed again since, their lease having run out.
+func (q *Queue) finish(jobCtx context.Context, j *job, jobErr error) error {
+	now := time.Now().UTC()
+	// The queue is stopping: run the job again at the next start, without
+	// counting this attempt
+	if jobErr != nil && jobCtx.Err() != nil {
+		_, err := q.db.Exec(`
+			UPDATE jobs SET status = ?, attempts = attempts - 1, run_at = ?, locked_until = NULL, updated_at = ?
+			WHERE id = ? AND status = ? AND started_at = ?
+		`, StatusPending, now, now, j.id, StatusRunning, j.startedAt)
+		return err
+	}
+
+	if jobErr == nil {
+		_, err := q.db.Exec(`
+			UPDATE jobs SET status = ?, finished_at = ?, locked_until = NULL, updated_at = ?
+			WHERE id = ? AND status = ? AND started_at = ?
+		`, StatusSucceeded, now, now, j.id, StatusRunning, j.startedAt)
+		return err
+	}
+
+	log.Printf("Job %d (%s) failed: %v", j.id, j.jobType, jobErr)
+	status, runAt := StatusPending, now.Add(retryDelay(j.attempts))
+	var finishedAt any
+	var permanent *permanentError
+	if errors.As(jobErr, &permanent) || j.attempts >= j.max {
+		status, runAt, finishedAt = StatusFailed, now, now
+	}
+	_, err := q.db.Exec(`
+		UPDATE jobs SET status = ?, last_error = ?, run_at = ?, finished_at = ?, locked_until = NULL, updated_at = ?
+		WHERE id = ? AND status = ? AND started_at = ?
+	`, status, jobErr.Error(), runAt, finishedAt, now, j.id, StatusRunning, j.startedAt)
+	return err
+}
+
+// retryDelay is how long to wait before retrying after the given attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// Retry queues a failed or cancelled job to run again now, with a fresh
+// set of attempts.
+func (q *Queue) Retry(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	result, err := q.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, attempts = 0, run_at = ?, finished_at = NULL, updated_at = ?
+		WHERE id = ? AND status IN (?, ?)
+	`, StatusPending, now, now, id, StatusFailed, StatusCancelled)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return q.statusError(ctx, id)
+	}
+	q.notify()
+	return nil
+}
+
+// Cancel stops a pending or running job. A running job's context is
+// cancelled, by the server running it once it next renews its lease if that
+// is another; whether it stops early is up to the job.
+func (q *Queue) Cancel(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	result, err := q.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, finished_at = ?, locked_until = NULL, updated_at = ?
+		WHERE id = ? AND status IN (?, ?)
+	`, StatusCancelled, now, now, id, StatusPending, StatusRunning)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return q.statusError(ctx, id)
+	}
+
+	q.mu.Lock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// statusError explains why a job wasn't updated.
+func (q *Queue) statusError(ctx context.Context, id int64) error {
+	var status string
+	err := q.db.QueryRowContext(ctx, "SELECT status FROM jobs WHERE id = ?", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return &StatusError{Status: status}
+}
+
+// requeueExpired queues running jobs whose lease has run out again, every
+// renewInterval until ctx is cancelled.
+func (q *Queue) requeueExpired(ctx context.Context) {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := q.requeue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to requeue interrupted jobs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeue queues running jobs whose lease has run out to run again now, or
+// fails those that have had all their attempts. Jobs running before leases
+// were kept have none, and are queued again too.
+func (q *Queue) requeue(ctx context.Context) error {
+	now := time.Now().UTC()
+	const expired = "status = ? AND (locked_until IS NULL OR locked_until < ?)"
+	if _, err := q.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, last_error = ?, finished_at = ?, locked_until = NULL, updated_at = ?
+		WHERE `+expired+` AND attempts >= max_attempts
+	`, StatusFailed, "interrupted: its server stopped while running it", now, now, StatusRunning, now); err != nil {
+		return err
+	}
+	result, err := q.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, run_at = ?, locked_until = NULL, updated_at = ?
+		WHERE `+expired, StatusPending, now, now, StatusRunning, now)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		q.notify()
+	}
+	return nil
+}
+
+// prune deletes old succeeded jobs periodically until ctx is cancelled.
+// Failed and cancelled jobs are kept for inspection.
+func (q *Queue) prune(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := q.db.ExecContext(ctx, `
+			DELETE FROM jobs WHERE status = ? AND finished_at < ?
+		`, StatusSucceeded, time.Now().UTC().Add(-pruneAge)); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to prune jobs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+)
+
+type stored struct {
+	status   string
+	attempts int
+	runAt    time.Time
+	err      string
+}
+
+func load(t *testing.T, db *database.DB, id int64) stored {
+	t.Helper()
+	var s stored
+	var lastError *string
+	err := db.QueryRow("SELECT status, attempts, run_at, last_error FROM jobs WHERE id = ?", id).
+		Scan(&s.status, &s.attempts, &s.runAt, &lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError != nil {
+		s.err = *lastError
+	}
+	return s
+}
+
+func enqueue(t *testing.T, q *Queue, jobType string) int64 {
+	t.Helper()
+	id, err := q.Enqueue(context.Background(), jobType, map[string]int{"song_id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func claim(t *testing.T, q *Queue) *job {
+	t.Helper()
+	j, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func exec(t *testing.T, db *database.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaimOrder(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q := NewQueue(db, 1)
+		first, second, later, earlier := enqueue(t, q, "a"), enqueue(t, q, "b"), enqueue(t, q, "c"), enqueue(t, q, "d")
+		now := time.Now().UTC()
+		exec(t, db, "UPDATE jobs SET run_at = ? WHERE id = ?", now.Add(time.Hour), later)
+		exec(t, db, "UPDATE jobs SET run_at = ? WHERE id = ?", now.Add(-time.Hour), earlier)
+		exec(t, db, "UPDATE jobs SET run_at = ? WHERE id IN (?, ?)", now.Add(-time.Minute), first, second)
+
+		// Due jobs are claimed by run_at, then in the order they were queued
+		for _, want := range []int64{earlier, first, second} {
+			j := claim(t, q)
+			if j == nil || j.id != want {
+				t.Fatalf("claimed %+v, want job %d", j, want)
+			}
+			if j.attempts != 1 || load(t, db, j.id).status != StatusRunning {
+				t.Errorf("claimed job is %+v", load(t, db, j.id))
+			}
+		}
+		if j := claim(t, q); j != nil {
+			t.Errorf("claimed job %d before it was due", j.id)
+		}
+	})
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q := NewQueue(db, 1)
+		q.Handle("flaky", func(ctx context.Context, payload json.RawMessage) error {
+			return errors.New("S3 is down")
+		})
+		id := enqueue(t, q, "flaky")
+
+		for attempt := 1; attempt <= 3; attempt++ {
+			start := time.Now().UTC()
+			q.run(context.Background(), claim(t, q))
+			got := load(t, db, id)
+			if got.status != StatusPending || got.attempts != attempt || got.err != "S3 is down" {
+				t.Fatalf("after attempt %d, job is %+v", attempt, got)
+			}
+			delay := got.runAt.Sub(start)
+			if want := retryDelay(attempt); delay < want-time.Second || delay > want+time.Second {
+				t.Errorf("attempt %d is retried after %s, want %s", attempt, delay, want)
+			}
+			if j := claim(t, q); j != nil {
+				t.Fatalf("claimed job %d before its retry was due", j.id)
+			}
+			exec(t, db, "UPDATE jobs SET run_at = ? WHERE id = ?", start, id)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q := NewQueue(db, 1)
+		q.Handle("flaky", func(ctx context.Context, payload json.RawMessage) error {
+			return errors.New("S3 is down")
+		})
+		q.Handle("doomed", func(ctx context.Context, payload json.RawMessage) error {
+			return Permanent(errors.New("song was deleted"))
+		})
+
+		// Jobs that keep failing are given up on after maxAttempts
+		flaky := enqueue(t, q, "flaky")
+		exec(t, db, "UPDATE jobs SET attempts = ? WHERE id = ?", maxAttempts-1, flaky)
+		q.run(context.Background(), claim(t, q))
+		if got := load(t, db, flaky); got.status != StatusFailed || got.attempts != maxAttempts {
+			t.Errorf("job failing its last attempt is %+v", got)
+		}
+
+		// and jobs that can't succeed at once, as are unknown ones
+		doomed, unknown := enqueue(t, q, "doomed"), enqueue(t, q, "unknown")
+		for range 2 {
+			q.run(context.Background(), claim(t, q))
+		}
+		for _, id := range []int64{doomed, unknown} {
+			if got := load(t, db, id); got.status != StatusFailed || got.attempts != 1 {
+				t.Errorf("job %d is %+v", id, got)
+			}
+		}
+
+		// until retried by hand
+		if err := q.Retry(context.Background(), flaky); err != nil {
+			t.Fatal(err)
+		}
+		if got := load(t, db, flaky); got.status != StatusPending || got.attempts != 0 {
+			t.Errorf("retried job is %+v", got)
+		}
+		var statusErr *StatusError
+		if err := q.Retry(context.Background(), flaky); !errors.As(err, &statusErr) || statusErr.Status != StatusPending {
+			t.Errorf("retrying a pending job gave %v", err)
+		}
+		if err := q.Retry(context.Background(), 999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("retrying a missing job gave %v", err)
+		}
+	})
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q := NewQueue(db, 1)
+		started := make(chan struct{})
+		q.Handle("slow", func(ctx context.Context, payload json.RawMessage) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		id := enqueue(t, q, "slow")
+
+		done := make(chan struct{})
+		go func() {
+			q.run(context.Background(), claim(t, q))
+			close(done)
+		}()
+		<-started
+		if err := q.Cancel(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the cancelled job kept running")
+		}
+		if got := load(t, db, id); got.status != StatusCancelled || got.attempts != 1 {
+			t.Errorf("cancelled job is %+v", got)
+		}
+	})
+}
+
+func TestCancelJobRunningElsewhere(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q, other := NewQueue(db, 1), NewQueue(db, 1)
+		id := enqueue(t, q, "slow")
+		j := claim(t, q)
+
+		held, err := q.renewLease(context.Background(), j)
+		if err != nil || !held {
+			t.Fatalf("renewing the lease of a running job gave %t, %v", held, err)
+		}
+
+		// The server running the job learns of the cancellation as it
+		// renews the lease
+		if err := other.Cancel(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+		if held, err := q.renewLease(context.Background(), j); err != nil || held {
+			t.Errorf("renewing the lease of a cancelled job gave %t, %v", held, err)
+		}
+	})
+}
+
+func TestRequeueExpiredLeases(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		q, other := NewQueue(db, 1), NewQueue(db, 1)
+		live, expired, exhausted := enqueue(t, q, "a"), enqueue(t, q, "b"), enqueue(t, q, "c")
+		claims := map[int64]*job{}
+		for range 3 {
+			j := claim(t, q)
+			claims[j.id] = j
+		}
+		past := time.Now().UTC().Add(-time.Second)
+		exec(t, db, "UPDATE jobs SET locked_until = ? WHERE id IN (?, ?)", past, expired, exhausted)
+		exec(t, db, "UPDATE jobs SET attempts = max_attempts WHERE id = ?", exhausted)
+
+		// Another server starting only takes over jobs whose lease ran out
+		if err := other.requeue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := load(t, db, live); got.status != StatusRunning {
+			t.Errorf("job with a live lease is %+v", got)
+		}
+		if got := load(t, db, expired); got.status != StatusPending || got.attempts != 1 {
+			t.Errorf("job with an expired lease is %+v", got)
+		}
+		if got := load(t, db, exhausted); got.status != StatusFailed || got.err == "" {
+			t.Errorf("job with an expired lease and no attempts left is %+v", got)
+		}
+
+		// The first server's claim is lost: it can't renew it, nor record
+		// an outcome over the new claim's
+		reclaimed := claim(t, other)
+		if reclaimed == nil || reclaimed.id != expired || reclaimed.attempts != 2 {
+			t.Fatalf("reclaimed %+v", reclaimed)
+		}
+		if held, err := q.renewLease(context.Background(), claims[expired]); err != nil || held {
+			t.Errorf("renewing a lost lease gave %t, %v", held, err)
+		}
+		if err := q.finish(context.Background(), claims[expired], nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := load(t, db, expired); got.status != StatusRunning {
+			t.Errorf("the lost claim's outcome was recorded: %+v", got)
+		}
+		if err := other.finish(context.Background(), reclaimed, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := load(t, db, expired); got.status != StatusSucceeded {
+			t.Errorf("the new claim's outcome wasn't recorded: %+v", got)
+		}
+	})
+}
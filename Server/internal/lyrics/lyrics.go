@@ -0,0 +1,106 @@
+// Package lyrics parses and formats time-synced lyrics in the LRC format:
+//
+//	[ar:The Beatles]
+//	[offset:+250]
+//	[00:12.34]Here comes the sun
+//	[00:15.00][01:02.50]Little darling
+//
+// Lines may carry several timestamps, and the word timestamps of enhanced
+// LRC ("<00:12.80>") are dropped.
+package lyrics
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line is a line of lyrics and the time into the song it is sung.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+var (
+	timestampTag = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	infoTag      = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]\s*$`)
+	wordTag      = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+)
+
+// Parse returns the timed lines of LRC text, ordered by time. Plain text
+// without timestamps gives no lines.
+func Parse(text string) []Line {
+	var lines []Line
+	var offset time.Duration
+	for _, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		raw = strings.TrimSpace(raw)
+
+		var times []time.Duration
+		for {
+			match := timestampTag.FindStringSubmatch(raw)
+			if match == nil {
+				break
+			}
+			times = append(times, parseTimestamp(match[1], match[2], match[3]))
+			raw = raw[len(match[0]):]
+		}
+
+		if len(times) == 0 {
+			// [offset:+/-ms] shifts every line earlier or later
+			if match := infoTag.FindStringSubmatch(raw); match != nil && strings.EqualFold(match[1], "offset") {
+				if ms, err := strconv.Atoi(strings.TrimSpace(match[2])); err == nil {
+					offset = time.Duration(ms) * time.Millisecond
+				}
+			}
+			continue
+		}
+
+		lineText := strings.TrimSpace(wordTag.ReplaceAllString(raw, ""))
+		for _, t := range times {
+			lines = append(lines, Line{Time: t, Text: lineText})
+		}
+	}
+
+	// A positive offset means lyrics appear sooner
+	for i := range lines {
+		lines[i].Time = max(lines[i].Time-offset, 0)
+	}
+	slices.SortStableFunc(lines, func(a, b Line) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+	return lines
+}
+
+// IsSynced reports whether text is LRC with at least one timed line.
+func IsSynced(text string) bool {
+	return len(Parse(text)) > 0
+}
+
+// Format writes lines as LRC text.
+func Format(lines []Line) string {
+	var b strings.Builder
+	for _, line := range lines {
+		centis := line.Time.Milliseconds() / 10
+		fmt.Fprintf(&b, "[%02d:%02d.%02d]%s\n", centis/6000, centis/100%60, centis%100, line.Text)
+	}
+	return b.String()
+}
+
+func parseTimestamp(minutes, seconds, fraction string) time.Duration {
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	t := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if fraction != "" {
+		// ".5" is half a second, ".05" and ".050" five hundredths
+		f, _ := strconv.Atoi(fraction)
+		for i := len(fraction); i < 3; i++ {
+			f *= 10
+		}
+		t += time.Duration(f) * time.Millisecond
+	}
+	return t
+}
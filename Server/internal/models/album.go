@@ -3,12 +3,33 @@ package models
 import "time"
 
 type Album struct {
-	ID         int64     `json:"id"`
-	Title      string    `json:"title"`
-	ArtistID   int64     `json:"artist_id"`
-	ArtistName string    `json:"artist_name,omitempty"` // For joined queries
-	Year       int       `json:"year,omitempty"`
-	CoverArt   string    `json:"cover_art,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID            int64          `json:"id"`
+	Title         string         `json:"title" validate:"required,max=500"`
+	ArtistID      *int64         `json:"artist_id,omitempty" validate:"exists=artists"` // The first main album artist
+	ArtistName    string         `json:"artist_name,omitempty"`                         // For joined queries
+	Artists       []ArtistCredit `json:"artists,omitempty"`                             // Album artists, distinct from track artists
+	ArtistDisplay string         `json:"artist_display,omitempty"`                      // "Various Artists" for compilations without album artist
+	Compilation   bool           `json:"compilation"`
+	Genres        []string       `json:"genres,omitempty"`
+	Tags          []string       `json:"tags,omitempty"` // The requesting user's
+	Year          int            `json:"year,omitempty" validate:"min=1000,max=2100"`
+	DiscTotal     int            `json:"disc_total,omitempty" validate:"min=1,max=999"`
+	TrackTotal    int            `json:"track_total,omitempty" validate:"min=1,max=9999"` // Across all discs
+	Discs         []AlbumDisc    `json:"discs,omitempty"`                                 // Discs with a subtitle, for single albums
+	CoverArt      string         `json:"cover_art,omitempty" validate:"max=2000"`
+	CoverArtKey   string         `json:"-"`                   // S3 key of the uploaded cover image
+	CoverURL      string         `json:"cover_url,omitempty"` // Set when a cover image was uploaded
+	Loudness      *Loudness      `json:"loudness,omitempty"`  // Once its tracks are analyzed
+	Starred       bool           `json:"starred,omitempty"`   // For the requesting user
+	StarredAt     *time.Time     `json:"starred_at,omitempty"`
+	Rating        int            `json:"rating,omitempty"` // 1-5, 0 when unrated
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	Version       int64          `json:"-"` // Counts writes, see repository.ErrModified
+}
+
+// AlbumDisc names one disc of a multi-disc album, e.g. "Live at Leeds".
+type AlbumDisc struct {
+	Number   int    `json:"number" validate:"required,min=1,max=999"`
+	Subtitle string `json:"subtitle" validate:"max=500"`
 }
@@ -3,9 +3,37 @@ package models
 import "time"
 
 type Artist struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Bio       string    `json:"bio,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            int64        `json:"id"`
+	Name          string       `json:"name" validate:"required,max=200"`
+	SortName      string       `json:"sort_name,omitempty" validate:"max=200"` // e.g. "Beatles, The"; lists sort by Name when empty
+	Bio           string       `json:"bio,omitempty" validate:"max=10000"`
+	Country       string       `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	FormedYear    *int         `json:"formed_year,omitempty" validate:"min=1000,max=2100"`
+	DisbandedYear *int         `json:"disbanded_year,omitempty" validate:"min=1000,max=2100"`
+	Links         []ArtistLink `json:"links,omitempty" validate:"max=50"`
+	Aliases       []string     `json:"aliases,omitempty" validate:"max=50"` // Other names matched during upload
+	ImageKey      string       `json:"-"`                                   // S3 key of the uploaded image
+	ImageURL      string       `json:"image_url,omitempty"`                 // Set when an image was uploaded
+	Starred       bool         `json:"starred,omitempty"`                   // For the requesting user
+	StarredAt     *time.Time   `json:"starred_at,omitempty"`
+	Rating        int          `json:"rating,omitempty"` // 1-5, 0 when unrated
+	Tags          []string     `json:"tags,omitempty"`   // The requesting user's
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	Version       int64        `json:"-"` // Counts writes, see repository.ErrModified
+}
+
+// ArtistLink is an external page about an artist, such as their website or
+// MusicBrainz entry.
+type ArtistLink struct {
+	Type string `json:"type" validate:"required,max=50"` // e.g. "website", "wikipedia", "musicbrainz"
+	URL  string `json:"url" validate:"required,url,max=2000"`
+}
+
+// ArtistCredit credits an artist on a song or album. Requests only need to
+// set ID and Role, which defaults to "main".
+type ArtistCredit struct {
+	ID   int64  `json:"id" validate:"required,exists=artists"`
+	Name string `json:"name,omitempty"`
+	Role string `json:"role" validate:"oneof=main featured remixer composer producer"`
 }
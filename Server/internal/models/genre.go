@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Genre is a node in the genre hierarchy, e.g. "Shoegaze" under "Rock".
+// Songs and albums of a genre's subgenres count as belonging to it when
+// browsing.
+type Genre struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name" validate:"required,max=100"`
+	ParentID   *int64    `json:"parent_id,omitempty" validate:"exists=genres"`
+	Path       []string  `json:"path,omitempty"`     // Ancestors from the root, for single genres
+	Children   []Genre   `json:"children,omitempty"` // Direct subgenres, for single genres
+	SongCount  int       `json:"song_count"`         // Songs tagged with the genre itself
+	AlbumCount int       `json:"album_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Tag is one of a user's free-form labels, with how many items carry it.
+type Tag struct {
+	Name        string `json:"name"`
+	SongCount   int    `json:"song_count"`
+	AlbumCount  int    `json:"album_count"`
+	ArtistCount int    `json:"artist_count"`
+}
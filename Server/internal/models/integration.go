@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ScrobbleIntegration links a user to an external scrobbling account. The
+// account's token or session key is never returned.
+type ScrobbleIntegration struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	Service   string    `json:"service"`
+	Username  string    `json:"username,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	Pending   int       `json:"pending"` // scrobbles waiting to be submitted
+	Failed    int       `json:"failed"`  // scrobbles that were given up on
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ScrobbleIntegrationRequest connects or updates an integration. Token is a
+// ListenBrainz user token or an authorized Last.fm auth token.
+type ScrobbleIntegrationRequest struct {
+	Token   string `json:"token,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Lyrics are the words of a song, either plain text or synced to the song
+// in LRC format.
+type Lyrics struct {
+	SongID    int64       `json:"song_id"`
+	Text      string      `json:"text" validate:"required"`
+	Synced    bool        `json:"synced"`
+	Lines     []LyricLine `json:"lines,omitempty"`                     // Parsed from Text when synced
+	Language  string      `json:"language,omitempty" validate:"max=3"` // ISO 639-2 code, e.g. "eng"
+	Source    string      `json:"source"`                              // "tags" or "upload"
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type LyricLine struct {
+	Time int64  `json:"time"` // milliseconds into the song
+	Text string `json:"text"`
+}
+
+// SearchResult is a song matching a search, with the part of its lyrics
+// that matched, if any.
+type SearchResult struct {
+	Song
+	LyricsSnippet string `json:"lyrics_snippet,omitempty"`
+}
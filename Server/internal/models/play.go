@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type Play struct {
+	ID             int64     `json:"id"`
+	SongID         int64     `json:"song_id"`
+	UserID         string    `json:"user_id"`
+	PlayedAt       time.Time `json:"played_at"`
+	DurationPlayed int       `json:"duration_played"`       // seconds actually listened
+	Source         string    `json:"source"`                // "api" or "stream"
+	SongTitle      string    `json:"song_title,omitempty"`  // For joined queries
+	ArtistName     string    `json:"artist_name,omitempty"` // For joined queries
+	AlbumTitle     string    `json:"album_title,omitempty"` // For joined queries
+}
+
+type NowPlaying struct {
+	UserID     string    `json:"user_id"`
+	SongID     int64     `json:"song_id"`
+	SongTitle  string    `json:"song_title,omitempty"`
+	ArtistName string    `json:"artist_name,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// PlayRequest is the body of POST /songs/{id}/plays. Like Last.fm, a request
+// with Submission=false only updates the user's now-playing status, while
+// Submission=true records a completed play (a scrobble).
+type PlayRequest struct {
+	Submission     bool  `json:"submission"`
+	Timestamp      int64 `json:"timestamp,omitempty"`                                  // unix seconds the play started, defaults to now
+	DurationPlayed *int  `json:"duration_played,omitempty" validate:"min=0,max=86400"` // seconds, defaults to the song duration
+}
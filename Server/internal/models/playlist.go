@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Playlist struct {
+	ID          int64     `json:"id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name" validate:"required,max=200"`
+	Description string    `json:"description,omitempty" validate:"max=2000"`
+	SongCount   int       `json:"song_count"`
+	Duration    int       `json:"duration"`                                             // total duration in seconds
+	SongIDs     []int64   `json:"song_ids,omitempty" validate:"max=10000,exists=songs"` // For create and update requests; updates without it keep the songs
+	Songs       []Song    `json:"songs,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SmartPlaylist is a playlist defined by rules (see package smartplaylist)
+// whose songs are found when it is fetched.
+type SmartPlaylist struct {
+	ID          int64           `json:"id"`
+	UserID      string          `json:"user_id"`
+	Name        string          `json:"name" validate:"required,max=200"`
+	Description string          `json:"description,omitempty" validate:"max=2000"`
+	Rules       json.RawMessage `json:"rules" validate:"required"`
+	Songs       []Song          `json:"songs,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
@@ -6,21 +6,57 @@ import (
 )
 
 type Song struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	ArtistID    *int64    `json:"artist_id,omitempty"`
-	AlbumID     *int64    `json:"album_id,omitempty"`
-	TrackNumber *int      `json:"track_number,omitempty"`
-	ArtistName  string    `json:"artist_name,omitempty"` // For joined queries
-	AlbumTitle  string    `json:"album_title,omitempty"` // For joined queries
-	Duration    int       `json:"duration"`              // duration in seconds
-	FileSize    int64     `json:"file_size"`
-	ContentType string    `json:"content_type"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID            int64          `json:"id"`
+	Title         string         `json:"title" validate:"required,max=500"`
+	ArtistID      *int64         `json:"artist_id,omitempty" validate:"exists=artists"` // The first main artist
+	AlbumID       *int64         `json:"album_id,omitempty" validate:"exists=albums"`
+	DiscNumber    *int           `json:"disc_number,omitempty" validate:"min=1,max=999"`
+	DiscSubtitle  string         `json:"disc_subtitle,omitempty"` // For joined queries
+	TrackNumber   *int           `json:"track_number,omitempty" validate:"min=1,max=9999"`
+	DiscTotal     int            `json:"disc_total,omitempty" validate:"min=1,max=999"`
+	TrackTotal    int            `json:"track_total,omitempty" validate:"min=1,max=9999"` // Tracks on this disc
+	ArtistName    string         `json:"artist_name,omitempty"`                           // For joined queries
+	AlbumTitle    string         `json:"album_title,omitempty"`                           // For joined queries
+	Artists       []ArtistCredit `json:"artists,omitempty"`                               // Every credited artist, in order
+	Genres        []string       `json:"genres,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`           // The requesting user's
+	ArtistDisplay string         `json:"artist_display,omitempty"` // e.g. "A & B feat. C"
+	Duration      int            `json:"duration"`                 // duration in seconds
+	Loudness      *Loudness      `json:"loudness,omitempty"`       // Set once analyzed
+	AlbumLoudness *Loudness      `json:"album_loudness,omitempty"`
+	FileSize      int64          `json:"file_size"`
+	ContentType   string         `json:"content_type"`
+	Starred       bool           `json:"starred,omitempty"` // For the requesting user
+	StarredAt     *time.Time     `json:"starred_at,omitempty"`
+	Rating        int            `json:"rating,omitempty"` // 1-5, 0 when unrated
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	Version       int64          `json:"-"` // Counts writes, see repository.ErrModified
+}
+
+// Loudness is the EBU R128 loudness of a song or album, with the
+// ReplayGain 2.0 values derived from it.
+type Loudness struct {
+	Integrated float64 `json:"integrated"` // LUFS
+	TruePeak   float64 `json:"true_peak"`  // dBTP
+	Gain       float64 `json:"gain"`       // dB bringing playback to -18 LUFS
+	Peak       float64 `json:"peak"`       // linear, to keep gain from clipping
 }
 
 // GetS3Key returns the S3 key for this song based on its ID
 func (s *Song) GetS3Key() string {
 	return fmt.Sprintf("songs/%d/song.mp3", s.ID)
 }
+
+// Waveform holds the peaks drawn by the player's seek bar, in the JSON
+// format of BBC's audiowaveform so that libraries such as peaks.js can read
+// it. Data alternates the minimum and maximum of each pixel.
+type Waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"` // pixels
+	Data            []int8 `json:"data"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// TopItem is a song, artist or album ranked by how often it was played.
+type TopItem struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	ArtistName    string `json:"artist_name,omitempty"` // For songs and albums
+	Plays         int    `json:"plays"`
+	ListeningTime int    `json:"listening_time"` // seconds
+}
+
+type ListeningTime struct {
+	Period        string `json:"period"` // start of the bucket, e.g. "2024-03-04"
+	Plays         int    `json:"plays"`
+	ListeningTime int    `json:"listening_time"` // seconds
+}
+
+type Streak struct {
+	Days  int    `json:"days"`
+	Start string `json:"start,omitempty"` // YYYY-MM-DD
+	End   string `json:"end,omitempty"`   // YYYY-MM-DD
+}
+
+type Streaks struct {
+	Current Streak `json:"current"`
+	Longest Streak `json:"longest"`
+}
+
+type StatsPeriod struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type ListeningStats struct {
+	Period        StatsPeriod     `json:"period"`
+	TotalPlays    int             `json:"total_plays"`
+	ListeningTime int             `json:"listening_time"` // seconds
+	Buckets       []ListeningTime `json:"buckets"`
+}
+
+// YearInReview is the "wrapped"-style summary of a user's listening year.
+type YearInReview struct {
+	Year          int             `json:"year"`
+	TotalPlays    int             `json:"total_plays"`
+	ListeningTime int             `json:"listening_time"` // seconds
+	UniqueSongs   int             `json:"unique_songs"`
+	UniqueArtists int             `json:"unique_artists"`
+	TopSongs      []TopItem       `json:"top_songs"`
+	TopArtists    []TopItem       `json:"top_artists"`
+	TopAlbums     []TopItem       `json:"top_albums"`
+	TopGenres     []TopItem       `json:"top_genres"`
+	Monthly       []ListeningTime `json:"monthly"`
+	BusiestDay    *ListeningTime  `json:"busiest_day,omitempty"`
+	LongestStreak Streak          `json:"longest_streak"`
+}
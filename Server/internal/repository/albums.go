@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+)
+
+// albumSelect selects the columns read by scanAlbum. It takes the requesting
+// user's id as its first parameter, for the annotations join.
+const albumSelect = `
+	SELECT a.id, a.title, a.artist_id, a.compilation, a.year, a.disc_total, a.track_total,
+	       a.cover_art, a.cover_art_key, a.loudness, a.true_peak, a.created_at, a.updated_at, a.version,
+	       ar.name as artist_name,
+	       COALESCE(an.starred, 0), an.starred_at, COALESCE(an.rating, 0)
+	FROM albums a
+	LEFT JOIN artists ar ON a.artist_id = ar.id
+	LEFT JOIN annotations an ON an.item_type = 'album' AND an.item_id = a.id AND an.user_id = ?
+`
+
+func scanAlbum(row Scanner) (models.Album, error) {
+	var album models.Album
+	var year, discTotal, trackTotal sql.NullInt64
+	var coverArt, coverArtKey, artistName sql.NullString
+	var loudness, truePeak sql.NullFloat64
+	err := row.Scan(
+		&album.ID, &album.Title, &album.ArtistID, &album.Compilation, &year, &discTotal, &trackTotal,
+		&coverArt, &coverArtKey, &loudness, &truePeak, &album.CreatedAt, &album.UpdatedAt, &album.Version,
+		&artistName,
+		&album.Starred, &album.StarredAt, &album.Rating,
+	)
+	if err != nil {
+		return album, err
+	}
+	if year.Valid {
+		album.Year = int(year.Int64)
+	}
+	album.DiscTotal = int(discTotal.Int64)
+	album.TrackTotal = int(trackTotal.Int64)
+	if coverArt.Valid {
+		album.CoverArt = coverArt.String
+	}
+	album.CoverArtKey = coverArtKey.String
+	album.ArtistName = artistName.String
+	album.Loudness = newLoudness(loudness, truePeak)
+	return album, nil
+}
+
+// Albums is the AlbumRepository of a database.
+type Albums struct {
+	db *database.DB
+}
+
+var _ AlbumRepository = (*Albums)(nil)
+
+func NewAlbums(db *database.DB) *Albums {
+	return &Albums{db: db}
+}
+
+func (a *Albums) List(ctx context.Context, filter AlbumFilter) ([]models.Album, error) {
+	var c conditions
+	c.annotations(filter.AnnotationFilter)
+
+	orderBy := " ORDER BY a.created_at DESC"
+	if filter.ArtistID != nil {
+		// The artist's own albums
+		c.add("a.id IN (SELECT album_id FROM album_artists WHERE artist_id = ?)", *filter.ArtistID)
+		orderBy = " ORDER BY a.year DESC, a.title ASC"
+	}
+	if filter.AppearsOn != nil {
+		// Albums by others, typically compilations, with tracks by the artist
+		c.add(`a.id IN (
+			SELECT s.album_id FROM songs s
+			JOIN song_artists sa ON sa.song_id = s.id
+			WHERE sa.artist_id = ?
+		) AND a.id NOT IN (SELECT album_id FROM album_artists WHERE artist_id = ?)`, *filter.AppearsOn, *filter.AppearsOn)
+		orderBy = " ORDER BY a.year DESC, a.title ASC"
+	}
+	if filter.Compilation != nil {
+		c.add("a.compilation = ?", *filter.Compilation)
+	}
+	if filter.Genre != "" {
+		c.genre(filter.Genre, "album_genres", "album_id", "a.id")
+	}
+	if filter.Tag != "" {
+		c.tag(filter.User, filter.Tag, "album", "a.id")
+	}
+
+	rows, err := a.db.QueryContext(ctx, albumSelect+c.String()+orderBy, append([]any{filter.User}, c.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []models.Album{}
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+func (a *Albums) Get(ctx context.Context, user string, id int64) (*models.Album, error) {
+	album, err := scanAlbum(a.db.QueryRowContext(ctx, albumSelect+" WHERE a.id = ?", user, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, "SELECT disc_number, subtitle FROM album_discs WHERE album_id = ? ORDER BY disc_number", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var disc models.AlbumDisc
+		if err := rows.Scan(&disc.Number, &disc.Subtitle); err != nil {
+			return nil, err
+		}
+		album.Discs = append(album.Discs, disc)
+	}
+	return &album, rows.Err()
+}
+
+// albumColumns returns the values of the columns of albums set by Create
+// and Update.
+func albumColumns(album *models.Album) []any {
+	var year *int
+	if album.Year > 0 {
+		year = &album.Year
+	}
+	return []any{
+		album.Title, album.ArtistID, album.Compilation, year, nullInt(album.DiscTotal), nullInt(album.TrackTotal),
+		nullString(album.CoverArt),
+	}
+}
+
+func (a *Albums) Create(ctx context.Context, album *models.Album) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO albums (title, artist_id, compilation, year, disc_total, track_total, cover_art)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, albumColumns(album)...).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if err := albumCredits.set(tx, id, album.Artists); err != nil {
+		return err
+	}
+	if err := setAlbumDiscs(tx, id, album.Discs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	album.ID = id
+	return nil
+}
+
+func (a *Albums) Update(ctx context.Context, album *models.Album) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, whereArgs := versioned(album.ID, album.Version)
+	result, err := tx.Exec(`
+		UPDATE albums
+		SET title = ?, artist_id = ?, compilation = ?, year = ?, disc_total = ?, track_total = ?,
+		    cover_art = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+	`+where, append(albumColumns(album), whereArgs...)...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return notWritten(tx.QueryRow("SELECT id FROM albums WHERE id = ?", album.ID))
+	}
+
+	if err := albumCredits.set(tx, album.ID, album.Artists); err != nil {
+		return err
+	}
+	if err := setAlbumDiscs(tx, album.ID, album.Discs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (a *Albums) Delete(ctx context.Context, id, version int64) error {
+	return deleteRow(ctx, a.db, "albums", "album", id, version, nil)
+}
+
+func (a *Albums) FindOrCreate(ctx context.Context, title string, artistID *int64, year *int) (int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, _, err := findOrCreateAlbum(ctx, tx, title, artistID, year)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// findOrCreateAlbum is AlbumRepository.FindOrCreate in tx, also reporting
+// whether it created the album.
+func findOrCreateAlbum(ctx context.Context, tx *database.Tx, title string, artistID *int64, year *int) (int64, bool, error) {
+	var id int64
+	if artistID == nil {
+		const lookup = "SELECT id FROM albums WHERE title = ? COLLATE NOCASE AND compilation = 1 ORDER BY id LIMIT 1"
+		err := tx.QueryRowContext(ctx, lookup, title).Scan(&id)
+		if err != sql.ErrNoRows {
+			return id, false, err
+		}
+		const insert = "INSERT INTO albums (title, compilation, year) VALUES (?, 1, ?) RETURNING id"
+		err = tx.QueryRowContext(ctx, insert, title, year).Scan(&id)
+		return id, err == nil, err
+	}
+
+	const lookup = `
+		SELECT a.id FROM albums a
+		JOIN album_artists aa ON aa.album_id = a.id
+		WHERE a.title = ? COLLATE NOCASE AND aa.artist_id = ? AND aa.role = 'main'
+		ORDER BY a.id
+		LIMIT 1
+	`
+	err := tx.QueryRowContext(ctx, lookup, title, *artistID).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, false, err
+	}
+
+	const insert = "INSERT INTO albums (title, artist_id, year) VALUES (?, ?, ?) RETURNING id"
+	if err := tx.QueryRowContext(ctx, insert, title, *artistID, year).Scan(&id); err != nil {
+		return 0, false, err
+	}
+	credits := []models.ArtistCredit{{ID: *artistID, Role: roleMain}}
+	if err := albumCredits.set(tx, id, credits); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// setAlbumDiscs replaces the disc subtitles of an album.
+func setAlbumDiscs(tx *database.Tx, albumID int64, discs []models.AlbumDisc) error {
+	if _, err := tx.Exec("DELETE FROM album_discs WHERE album_id = ?", albumID); err != nil {
+		return err
+	}
+	for _, disc := range discs {
+		if strings.TrimSpace(disc.Subtitle) == "" {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO album_discs (album_id, disc_number, subtitle)
+			VALUES (?, ?, ?)
+		`, albumID, disc.Number, strings.TrimSpace(disc.Subtitle)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+)
+
+// artistSelect selects the columns read by scanArtist. It takes the
+// requesting user's id as its first parameter, for the annotations join.
+const artistSelect = `
+	SELECT ar.id, ar.name, ar.sort_name, ar.bio, ar.country, ar.formed_year, ar.disbanded_year,
+	       ar.image_key, ar.created_at, ar.updated_at, ar.version,
+	       COALESCE(an.starred, 0), an.starred_at, COALESCE(an.rating, 0)
+	FROM artists ar
+	LEFT JOIN annotations an ON an.item_type = 'artist' AND an.item_id = ar.id AND an.user_id = ?
+`
+
+// artistOrder sorts artists by sort name, falling back to their name.
+const artistOrder = " ORDER BY COALESCE(NULLIF(ar.sort_name, ''), ar.name) COLLATE NOCASE ASC"
+
+func scanArtist(row Scanner) (models.Artist, error) {
+	var artist models.Artist
+	var sortName, bio, country, imageKey sql.NullString
+	var formedYear, disbandedYear sql.NullInt64
+	err := row.Scan(
+		&artist.ID, &artist.Name, &sortName, &bio, &country, &formedYear, &disbandedYear,
+		&imageKey, &artist.CreatedAt, &artist.UpdatedAt, &artist.Version,
+		&artist.Starred, &artist.StarredAt, &artist.Rating,
+	)
+	if err != nil {
+		return artist, err
+	}
+	artist.SortName = sortName.String
+	artist.Bio = bio.String
+	artist.Country = country.String
+	if formedYear.Valid {
+		year := int(formedYear.Int64)
+		artist.FormedYear = &year
+	}
+	if disbandedYear.Valid {
+		year := int(disbandedYear.Int64)
+		artist.DisbandedYear = &year
+	}
+	artist.ImageKey = imageKey.String
+	return artist, nil
+}
+
+// Artists is the ArtistRepository of a database.
+type Artists struct {
+	db *database.DB
+}
+
+var _ ArtistRepository = (*Artists)(nil)
+
+func NewArtists(db *database.DB) *Artists {
+	return &Artists{db: db}
+}
+
+func (a *Artists) List(ctx context.Context, filter ArtistFilter) ([]models.Artist, error) {
+	var c conditions
+	c.annotations(filter.AnnotationFilter)
+	if filter.Tag != "" {
+		c.tag(filter.User, filter.Tag, "artist", "ar.id")
+	}
+
+	rows, err := a.db.QueryContext(ctx, artistSelect+c.String()+artistOrder, append([]any{filter.User}, c.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []models.Artist{}
+	for rows.Next() {
+		artist, err := scanArtist(rows)
+		if err != nil {
+			return nil, err
+		}
+		artists = append(artists, artist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return artists, a.loadProfiles(ctx, artists)
+}
+
+func (a *Artists) Get(ctx context.Context, user string, id int64) (*models.Artist, error) {
+	artist, err := scanArtist(a.db.QueryRowContext(ctx, artistSelect+" WHERE ar.id = ?", user, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	artists := []models.Artist{artist}
+	if err := a.loadProfiles(ctx, artists); err != nil {
+		return nil, err
+	}
+	return &artists[0], nil
+}
+
+// loadProfiles fills in the links and aliases of artists.
+func (a *Artists) loadProfiles(ctx context.Context, artists []models.Artist) error {
+	if len(artists) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(artists))
+	for i, artist := range artists {
+		index[artist.ID] = i
+	}
+
+	// A single artist is looked up directly, lists read every row once
+	filter, args := "", []any{}
+	if len(artists) == 1 {
+		filter, args = " WHERE artist_id = ?", []any{artists[0].ID}
+	}
+
+	rows, err := a.db.QueryContext(ctx, "SELECT artist_id, alias FROM artist_aliases"+filter+" ORDER BY alias COLLATE NOCASE", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var artistID int64
+		var alias string
+		if err := rows.Scan(&artistID, &alias); err != nil {
+			return err
+		}
+		if i, ok := index[artistID]; ok {
+			artists[i].Aliases = append(artists[i].Aliases, alias)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = a.db.QueryContext(ctx, "SELECT artist_id, type, url FROM artist_links"+filter+" ORDER BY artist_id, position", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var artistID int64
+		var link models.ArtistLink
+		if err := rows.Scan(&artistID, &link.Type, &link.URL); err != nil {
+			return err
+		}
+		if i, ok := index[artistID]; ok {
+			artists[i].Links = append(artists[i].Links, link)
+		}
+	}
+	return rows.Err()
+}
+
+// artistColumns returns the values of the columns of artists set by Create
+// and Update.
+func artistColumns(artist *models.Artist) []any {
+	return []any{
+		artist.Name, nullString(artist.SortName), nullString(artist.Bio), nullString(artist.Country),
+		artist.FormedYear, artist.DisbandedYear,
+	}
+}
+
+func (a *Artists) Create(ctx context.Context, artist *models.Artist) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO artists (name, sort_name, bio, country, formed_year, disbanded_year)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, artistColumns(artist)...).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if err := setArtistProfile(tx, id, artist); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	artist.ID = id
+	return nil
+}
+
+func (a *Artists) Update(ctx context.Context, artist *models.Artist) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, whereArgs := versioned(artist.ID, artist.Version)
+	result, err := tx.Exec(`
+		UPDATE artists
+		SET name = ?, sort_name = ?, bio = ?, country = ?, formed_year = ?, disbanded_year = ?,
+		    updated_at = CURRENT_TIMESTAMP, version = version + 1
+	`+where, append(artistColumns(artist), whereArgs...)...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return notWritten(tx.QueryRow("SELECT id FROM artists WHERE id = ?", artist.ID))
+	}
+
+	if err := setArtistProfile(tx, artist.ID, artist); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (a *Artists) Delete(ctx context.Context, id, version int64) error {
+	return deleteRow(ctx, a.db, "artists", "artist", id, version, func(tx *database.Tx) error {
+		if err := albumCredits.replaceArtist(tx, id); err != nil {
+			return err
+		}
+		return songCredits.replaceArtist(tx, id)
+	})
+}
+
+// setArtistProfile replaces the links and aliases of an artist, checking
+// that neither its name nor its aliases are another artist's name or alias.
+func setArtistProfile(tx *database.Tx, artistID int64, artist *models.Artist) error {
+	if _, err := tx.Exec("DELETE FROM artist_aliases WHERE artist_id = ?", artistID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM artist_links WHERE artist_id = ?", artistID); err != nil {
+		return err
+	}
+
+	for _, name := range append([]string{artist.Name}, artist.Aliases...) {
+		var otherID int64
+		err := tx.QueryRow(`
+			SELECT id FROM artists WHERE name = ? COLLATE NOCASE AND id <> ?
+			UNION ALL
+			SELECT artist_id FROM artist_aliases WHERE alias = ? AND artist_id <> ?
+			LIMIT 1
+		`, name, artistID, name, artistID).Scan(&otherID)
+		if err == nil {
+			return &NameConflictError{Name: name, ArtistID: otherID}
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	for _, alias := range artist.Aliases {
+		if _, err := tx.Exec("INSERT INTO artist_aliases (alias, artist_id) VALUES (?, ?)", alias, artistID); err != nil {
+			return err
+		}
+	}
+	for position, link := range artist.Links {
+		if _, err := tx.Exec(`
+			INSERT INTO artist_links (artist_id, position, type, url)
+			VALUES (?, ?, ?, ?)
+		`, artistID, position, link.Type, link.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Artists) FindOrCreate(ctx context.Context, name string) (int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, _, err := findOrCreateArtist(ctx, tx, name)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// findOrCreateArtist is ArtistRepository.FindOrCreate in tx, also
+// reporting whether it created the artist.
+func findOrCreateArtist(ctx context.Context, tx *database.Tx, name string) (int64, bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, false, errors.New("artist name is empty")
+	}
+
+	const lookup = `
+		SELECT id FROM artists WHERE name = ? COLLATE NOCASE
+		UNION ALL
+		SELECT artist_id FROM artist_aliases WHERE alias = ?
+		LIMIT 1
+	`
+	var id int64
+	err := tx.QueryRowContext(ctx, lookup, name, name).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, false, err
+	}
+
+	// Another upload may create the same artist concurrently
+	result, err := tx.ExecContext(ctx, "INSERT INTO artists (name) VALUES (?) ON CONFLICT (name) DO NOTHING", name)
+	if err != nil {
+		return 0, false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	err = tx.QueryRowContext(ctx, lookup, name, name).Scan(&id)
+	return id, rowsAffected == 1, err
+}
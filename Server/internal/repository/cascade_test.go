@@ -0,0 +1,246 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+)
+
+// library is a small catalog with a row in every table referring to songs,
+// albums and artists.
+//
+// Album "Duets" and its song "Together" are by Ann, then Bob; album "Solo"
+// and its song "Alone" by Ann only.
+type library struct {
+	ann, bob        int64
+	duets, solo     int64
+	together, alone int64
+}
+
+func newLibrary(t *testing.T, db *database.DB) library {
+	t.Helper()
+	ctx := context.Background()
+	var l library
+	artists := repository.NewArtists(db)
+	albums := repository.NewAlbums(db)
+	songs := repository.NewSongs(db)
+
+	ann := &models.Artist{
+		Name:    "Ann",
+		Aliases: []string{"Annie"},
+		Links:   []models.ArtistLink{{Type: "website", URL: "https://ann.example"}},
+	}
+	if err := artists.Create(ctx, ann); err != nil {
+		t.Fatal(err)
+	}
+	l.ann = ann.ID
+	l.bob = createArtist(t, artists, "Bob").ID
+
+	both := []models.ArtistCredit{{ID: l.ann, Role: "main"}, {ID: l.bob, Role: "main"}}
+	only := []models.ArtistCredit{{ID: l.ann, Role: "main"}}
+	duets := &models.Album{Title: "Duets", ArtistID: &l.ann, Artists: both,
+		Discs: []models.AlbumDisc{{Number: 1, Subtitle: "Live"}}}
+	solo := &models.Album{Title: "Solo", ArtistID: &l.ann, Artists: only}
+	for _, album := range []*models.Album{duets, solo} {
+		if err := albums.Create(ctx, album); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.duets, l.solo = duets.ID, solo.ID
+
+	together := &models.Song{Title: "Together", ArtistID: &l.ann, AlbumID: &l.duets, Artists: both}
+	alone := &models.Song{Title: "Alone", ArtistID: &l.ann, AlbumID: &l.solo, Artists: only}
+	for _, song := range []*models.Song{together, alone} {
+		if err := songs.Create(ctx, song); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.together, l.alone = together.ID, alone.ID
+
+	var genreID, playlistID int64
+	if err := db.QueryRow("INSERT INTO genres (name) VALUES ('Soul') RETURNING id").Scan(&genreID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("INSERT INTO playlists (user_id, name) VALUES (?, 'Mix') RETURNING id", user).Scan(&playlistID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	exec(t, db, "INSERT INTO song_genres (song_id, genre_id) VALUES (?, ?)", l.together, genreID)
+	exec(t, db, "INSERT INTO album_genres (album_id, genre_id) VALUES (?, ?)", l.duets, genreID)
+	exec(t, db, "INSERT INTO lyrics (song_id, text, plain_text, source) VALUES (?, 'la', 'la', 'upload')", l.together)
+	exec(t, db, "INSERT INTO plays (song_id, user_id, played_at) VALUES (?, ?, ?)", l.together, user, now)
+	exec(t, db, "INSERT INTO now_playing (user_id, song_id, started_at, expires_at) VALUES (?, ?, ?, ?)", user, l.together, now, now.Add(time.Hour))
+	exec(t, db, "INSERT INTO playlist_songs (playlist_id, song_id, position) VALUES (?, ?, 0), (?, ?, 1)", playlistID, l.together, playlistID, l.alone)
+	for _, item := range []struct {
+		itemType string
+		id       int64
+	}{{"artist", l.ann}, {"album", l.duets}, {"song", l.together}} {
+		exec(t, db, "INSERT INTO annotations (user_id, item_type, item_id, starred) VALUES (?, ?, ?, 1)", user, item.itemType, item.id)
+		exec(t, db, "INSERT INTO item_tags (user_id, item_type, item_id, tag) VALUES (?, ?, ?, 'best')", user, item.itemType, item.id)
+	}
+	return l
+}
+
+func exec(t *testing.T, db *database.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectRows checks that query counts want rows.
+func expectRows(t *testing.T, db *database.DB, want int, query string, args ...any) {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Errorf("%s %v: %d rows, want %d", query, args, n, want)
+	}
+}
+
+// artistOf returns the artist_id of row id of table, 0 if NULL.
+func artistOf(t *testing.T, db *database.DB, table string, id int64) int64 {
+	t.Helper()
+	var artistID sql.NullInt64
+	if err := db.QueryRow("SELECT artist_id FROM "+table+" WHERE id = ?", id).Scan(&artistID); err != nil {
+		t.Fatal(err)
+	}
+	return artistID.Int64
+}
+
+func TestDeleteArtistCascades(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		l := newLibrary(t, db)
+		if err := repository.NewArtists(db).Delete(context.Background(), l.ann, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM artist_aliases WHERE artist_id = ?", l.ann)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM artist_links WHERE artist_id = ?", l.ann)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM song_artists WHERE artist_id = ?", l.ann)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM album_artists WHERE artist_id = ?", l.ann)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM annotations WHERE item_type = 'artist' AND item_id = ?", l.ann)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM item_tags WHERE item_type = 'artist' AND item_id = ?", l.ann)
+
+		// Songs and albums are kept, by their next artist if they have one
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM albums")
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM songs")
+		if id := artistOf(t, db, "albums", l.duets); id != l.bob {
+			t.Errorf("Duets is by artist %d, want Bob (%d)", id, l.bob)
+		}
+		if id := artistOf(t, db, "songs", l.together); id != l.bob {
+			t.Errorf("Together is by artist %d, want Bob (%d)", id, l.bob)
+		}
+		if id := artistOf(t, db, "albums", l.solo); id != 0 {
+			t.Errorf("Solo is by artist %d, want none", id)
+		}
+		if id := artistOf(t, db, "songs", l.alone); id != 0 {
+			t.Errorf("Alone is by artist %d, want none", id)
+		}
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM album_artists WHERE album_id = ? AND artist_id = ?", l.duets, l.bob)
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM albums WHERE version = 2")
+
+		// Other artists' rows are untouched
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM artists WHERE id = ?", l.bob)
+	})
+}
+
+func TestDeleteArtistKeepsAlbumTitlesUnique(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		l := newLibrary(t, db)
+		albums := repository.NewAlbums(db)
+		cat := createArtist(t, repository.NewArtists(db), "Cat").ID
+
+		// Bob has albums titled like two of Ann's he is credited on, one of
+		// which is also Cat's
+		for _, album := range []*models.Album{
+			{Title: "Duets", ArtistID: &l.bob, Artists: []models.ArtistCredit{{ID: l.bob, Role: "main"}}},
+			{Title: "Trios", ArtistID: &l.ann, Artists: []models.ArtistCredit{
+				{ID: l.ann, Role: "main"}, {ID: l.bob, Role: "main"}, {ID: cat, Role: "main"},
+			}},
+			{Title: "Trios", ArtistID: &l.bob, Artists: []models.ArtistCredit{{ID: l.bob, Role: "main"}}},
+		} {
+			if err := albums.Create(ctx, album); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := repository.NewArtists(db).Delete(ctx, l.ann, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// Ann's albums pass over Bob to their next artist, or none
+		expectRows(t, db, 5, "SELECT COUNT(*) FROM albums")
+		if id := artistOf(t, db, "albums", l.duets); id != 0 {
+			t.Errorf("Duets is by artist %d, want none", id)
+		}
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM albums WHERE title = 'Trios' AND artist_id = ?", cat)
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM albums WHERE title = 'Trios' AND artist_id = ?", l.bob)
+		// and stay credited to him
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM album_artists WHERE album_id = ? AND artist_id = ?", l.duets, l.bob)
+	})
+}
+
+func TestDeleteAlbumCascades(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		l := newLibrary(t, db)
+		if err := repository.NewAlbums(db).Delete(context.Background(), l.duets, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM album_artists WHERE album_id = ?", l.duets)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM album_discs WHERE album_id = ?", l.duets)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM album_genres WHERE album_id = ?", l.duets)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM annotations WHERE item_type = 'album' AND item_id = ?", l.duets)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM item_tags WHERE item_type = 'album' AND item_id = ?", l.duets)
+
+		// Its songs stay, without an album, as do its artists
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM songs WHERE id = ? AND album_id IS NULL", l.together)
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM artists")
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM albums WHERE id = ?", l.solo)
+	})
+}
+
+func TestDeleteSongCascades(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		l := newLibrary(t, db)
+		if err := repository.NewSongs(db).Delete(context.Background(), l.together, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, table := range []string{"song_artists", "song_genres", "lyrics", "plays", "now_playing", "playlist_songs"} {
+			expectRows(t, db, 0, "SELECT COUNT(*) FROM "+table+" WHERE song_id = ?", l.together)
+		}
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM annotations WHERE item_type = 'song' AND item_id = ?", l.together)
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM item_tags WHERE item_type = 'song' AND item_id = ?", l.together)
+
+		// The playlist keeps its other songs, and the album its artists
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM playlist_songs WHERE song_id = ?", l.alone)
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM album_artists WHERE album_id = ?", l.duets)
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM annotations WHERE item_type = 'album'")
+	})
+}
+
+func TestDeleteAtStaleVersionChangesNothing(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		l := newLibrary(t, db)
+		err := repository.NewArtists(db).Delete(context.Background(), l.ann, 5)
+		if !errors.Is(err, repository.ErrModified) {
+			t.Fatalf("got %v, want ErrModified", err)
+		}
+		if id := artistOf(t, db, "albums", l.duets); id != l.ann {
+			t.Errorf("Duets moved to artist %d", id)
+		}
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM annotations WHERE item_type = 'artist'")
+	})
+}
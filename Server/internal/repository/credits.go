@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+)
+
+// roleMain is the role of the artists a song or album is by.
+const roleMain = "main"
+
+// creditTable is a join table crediting artists on songs or albums.
+type creditTable struct {
+	table  string
+	column string // id of the credited song or album
+	items  string // table of the credited songs or albums
+	// uniqueTitles is set if an artist can't have two items of a title
+	uniqueTitles bool
+}
+
+var (
+	songCredits  = creditTable{table: "song_artists", column: "song_id", items: "songs"}
+	albumCredits = creditTable{table: "album_artists", column: "album_id", items: "albums", uniqueTitles: true}
+)
+
+// set replaces the artists credited on item id, in order.
+func (c creditTable) set(tx *database.Tx, id int64, credits []models.ArtistCredit) error {
+	if _, err := tx.Exec("DELETE FROM "+c.table+" WHERE "+c.column+" = ?", id); err != nil {
+		return err
+	}
+
+	for position, credit := range credits {
+		result, err := tx.Exec(`
+			INSERT INTO `+c.table+` (`+c.column+`, artist_id, role, position)
+			SELECT ?, id, ?, ? FROM artists WHERE id = ?
+		`, id, credit.Role, position, credit.ID)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return &MissingArtistError{ID: credit.ID}
+		}
+	}
+	return nil
+}
+
+// replaceArtist makes the next main artist credited on the items whose
+// artist is artistID, which is being deleted, their artist. Items with no
+// other main artist are left without one. Where titles are unique, artists
+// that already have an item of the same title are passed over, leaving the
+// two items apart rather than merging them.
+func (c creditTable) replaceArtist(tx *database.Tx, artistID int64) error {
+	var clash string
+	if c.uniqueTitles {
+		clash = `AND NOT EXISTS (
+				SELECT 1 FROM ` + c.items + ` other
+				WHERE other.artist_id = ` + c.table + `.artist_id AND other.title = ` + c.items + `.title
+			)`
+	}
+	_, err := tx.Exec(`
+		UPDATE `+c.items+`
+		SET artist_id = (
+			SELECT artist_id FROM `+c.table+`
+			WHERE `+c.column+` = `+c.items+`.id AND artist_id <> ? AND role = ?
+			`+clash+`
+			ORDER BY position
+			LIMIT 1
+		), updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE artist_id = ?
+	`, artistID, roleMain, artistID)
+	return err
+}
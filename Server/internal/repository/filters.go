@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AnnotationFilter narrows a list to items the requesting user starred or
+// rated.
+type AnnotationFilter struct {
+	Starred   *bool // starred, or not starred, when set
+	MinRating int   // 1-5, or 0 for any rating
+}
+
+// SongFilter selects the songs listed by SongRepository.List. Songs of an
+// album are in track order, others newest first.
+type SongFilter struct {
+	User string
+	AnnotationFilter
+	ArtistID *int64 // credited in any role, or in Role when set
+	Role     string
+	AlbumID  *int64
+	Genre    string // id or name, including subgenres
+	Tag      string // given by User
+}
+
+// AlbumFilter selects the albums listed by AlbumRepository.List. An
+// artist's albums are listed by year, others newest first.
+type AlbumFilter struct {
+	User string
+	AnnotationFilter
+	ArtistID    *int64 // credited as album artist
+	AppearsOn   *int64 // with tracks by this artist, but credited to others
+	Compilation *bool
+	Genre       string // id or name, including subgenres
+	Tag         string // given by User
+}
+
+// ArtistFilter selects the artists listed by ArtistRepository.List, in
+// order of sort name.
+type ArtistFilter struct {
+	User string
+	AnnotationFilter
+	Tag string // given by User
+}
+
+// conditions builds the WHERE clause of a list query, which ANDs together
+// conditions added with add.
+type conditions struct {
+	where []string
+	args  []any
+}
+
+func (c *conditions) add(condition string, args ...any) {
+	c.where = append(c.where, condition)
+	c.args = append(c.args, args...)
+}
+
+func (c *conditions) String() string {
+	if len(c.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.where, " AND ")
+}
+
+// annotations adds the conditions of f on the "an" annotations join.
+func (c *conditions) annotations(f AnnotationFilter) {
+	if f.Starred != nil {
+		if *f.Starred {
+			c.add("an.starred = 1")
+		} else {
+			c.add("COALESCE(an.starred, 0) = 0")
+		}
+	}
+	if f.MinRating > 0 {
+		c.add("an.rating >= ?", f.MinRating)
+	}
+}
+
+// subgenresQuery selects the ids of the genres matching match, a condition
+// on the genres table, and of all genres below them.
+func subgenresQuery(match string) string {
+	return `
+		WITH RECURSIVE subgenres(id) AS (
+			SELECT id FROM genres WHERE ` + match + `
+			UNION
+			SELECT g.id FROM genres g JOIN subgenres sub ON g.parent_id = sub.id
+		)
+		SELECT id FROM subgenres
+	`
+}
+
+// genre adds a condition matching items, identified by idColumn, in genre,
+// an id or else a name, or one of its subgenres, through the join table
+// linking column to genres.
+func (c *conditions) genre(genre, table, column, idColumn string) {
+	match, arg := "name = ?", any(genre)
+	if id, err := strconv.ParseInt(genre, 10, 64); err == nil {
+		match, arg = "id = ?", id
+	}
+	c.add(idColumn+" IN (SELECT "+column+" FROM "+table+" WHERE genre_id IN ("+subgenresQuery(match)+"))", arg)
+}
+
+// tag adds a condition matching items of itemType, identified by idColumn,
+// that user tagged with tag.
+func (c *conditions) tag(user, tag, itemType, idColumn string) {
+	c.add(idColumn+" IN (SELECT item_id FROM item_tags WHERE user_id = ? AND item_type = '"+itemType+"' AND tag = ?)", user, tag)
+}
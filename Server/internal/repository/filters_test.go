@@ -0,0 +1,167 @@
+package repository_test
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+)
+
+func createGenre(t *testing.T, db *database.DB, name string, parentID *int64) int64 {
+	t.Helper()
+	var id int64
+	if err := db.QueryRow("INSERT INTO genres (name, parent_id) VALUES (?, ?) RETURNING id", name, parentID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func songTitles(songs []models.Song) []string {
+	titles := make([]string, len(songs))
+	for i, song := range songs {
+		titles[i] = song.Title
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func albumTitles(albums []models.Album) []string {
+	titles := make([]string, len(albums))
+	for i, album := range albums {
+		titles[i] = album.Title
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func TestGenreFilter(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		songs := repository.NewSongs(db)
+		albums := repository.NewAlbums(db)
+		jazz := createGenre(t, db, "Jazz", nil)
+		bebop := createGenre(t, db, "Bebop", &jazz)
+		hardBop := createGenre(t, db, "Hard Bop", &bebop)
+		createGenre(t, db, "Rock", nil)
+
+		singer := createArtist(t, repository.NewArtists(db), "Nina Simone")
+		genreOf := map[string]int64{"Sinnerman": jazz, "Ornithology": bebop, "Moanin'": hardBop}
+		for _, title := range []string{"Sinnerman", "Ornithology", "Moanin'", "Untitled"} {
+			albumID, err := albums.FindOrCreate(ctx, title, &singer.ID, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			song := &models.Song{Title: title, ArtistID: &singer.ID, AlbumID: &albumID}
+			if err := songs.Create(ctx, song); err != nil {
+				t.Fatal(err)
+			}
+			if genre, ok := genreOf[title]; ok {
+				exec(t, db, "INSERT INTO song_genres (song_id, genre_id) VALUES (?, ?)", song.ID, genre)
+				exec(t, db, "INSERT INTO album_genres (album_id, genre_id) VALUES (?, ?)", albumID, genre)
+			}
+		}
+
+		// Genres are named by id or by name, ignoring case, and include
+		// their subgenres
+		tests := []struct {
+			genre string
+			want  []string
+		}{
+			{"Jazz", []string{"Moanin'", "Ornithology", "Sinnerman"}},
+			{"jazz", []string{"Moanin'", "Ornithology", "Sinnerman"}},
+			{strconv.FormatInt(jazz, 10), []string{"Moanin'", "Ornithology", "Sinnerman"}},
+			{"Bebop", []string{"Moanin'", "Ornithology"}},
+			{strconv.FormatInt(hardBop, 10), []string{"Moanin'"}},
+			{"Rock", []string{}},
+			{"Polka", []string{}},
+			{"999", []string{}},
+		}
+		for _, test := range tests {
+			listedSongs, err := songs.List(ctx, repository.SongFilter{User: user, Genre: test.genre})
+			if err != nil {
+				t.Fatalf("listing songs of %q: %v", test.genre, err)
+			}
+			if got := songTitles(listedSongs); !slices.Equal(got, test.want) {
+				t.Errorf("songs of %q are %v, want %v", test.genre, got, test.want)
+			}
+			listedAlbums, err := albums.List(ctx, repository.AlbumFilter{User: user, Genre: test.genre})
+			if err != nil {
+				t.Fatalf("listing albums of %q: %v", test.genre, err)
+			}
+			if got := albumTitles(listedAlbums); !slices.Equal(got, test.want) {
+				t.Errorf("albums of %q are %v, want %v", test.genre, got, test.want)
+			}
+		}
+	})
+}
+
+func TestTagFilter(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		artists := repository.NewArtists(db)
+		albums := repository.NewAlbums(db)
+		songs := repository.NewSongs(db)
+
+		singer := createArtist(t, artists, "Nina Simone")
+		pianist := createArtist(t, artists, "Hal Singer")
+		albumID, err := albums.FindOrCreate(ctx, "Pastel Blues", &singer.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := albums.FindOrCreate(ctx, "Blue Stompin'", &pianist.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		tagged := &models.Song{Title: "Sinnerman", ArtistID: &singer.ID, AlbumID: &albumID}
+		for _, song := range []*models.Song{tagged, {Title: "Be My Husband", ArtistID: &singer.ID}} {
+			if err := songs.Create(ctx, song); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tag := "INSERT INTO item_tags (user_id, item_type, item_id, tag) VALUES (?, ?, ?, ?)"
+		exec(t, db, tag, user, "song", tagged.ID, "favourite")
+		exec(t, db, tag, user, "album", albumID, "favourite")
+		exec(t, db, tag, user, "artist", singer.ID, "favourite")
+		// Other users' tags, and tags on other types of item, don't count
+		exec(t, db, tag, "someone-else", "artist", pianist.ID, "favourite")
+		exec(t, db, tag, user, "playlist", pianist.ID, "favourite")
+
+		for _, name := range []string{"favourite", "FAVOURITE"} {
+			listedSongs, err := songs.List(ctx, repository.SongFilter{User: user, Tag: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := songTitles(listedSongs); !slices.Equal(got, []string{"Sinnerman"}) {
+				t.Errorf("songs tagged %q are %v", name, got)
+			}
+			listedAlbums, err := albums.List(ctx, repository.AlbumFilter{User: user, Tag: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := albumTitles(listedAlbums); !slices.Equal(got, []string{"Pastel Blues"}) {
+				t.Errorf("albums tagged %q are %v", name, got)
+			}
+			listedArtists, err := artists.List(ctx, repository.ArtistFilter{User: user, Tag: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listedArtists) != 1 || listedArtists[0].ID != singer.ID {
+				t.Errorf("artists tagged %q are %+v", name, listedArtists)
+			}
+		}
+
+		listedSongs, err := songs.List(ctx, repository.SongFilter{User: user, Tag: "dull"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listedSongs) != 0 {
+			t.Errorf("songs tagged dull are %v", songTitles(listedSongs))
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"database/sql"
+	"math"
+
+	"s3-music-streamer/internal/audio"
+	"s3-music-streamer/internal/models"
+)
+
+// minPeakDB is below the quietest sample of 24-bit audio.
+const minPeakDB = -144.0
+
+// newLoudness returns the loudness stored for a song or album, or nil if it
+// hasn't been analyzed.
+func newLoudness(integrated, truePeak sql.NullFloat64) *models.Loudness {
+	if !integrated.Valid {
+		return nil
+	}
+	// Silence has a peak of -Inf dB, which JSON can't express
+	return &models.Loudness{
+		Integrated: round2(integrated.Float64),
+		TruePeak:   round2(max(20*math.Log10(truePeak.Float64), minPeakDB)),
+		Gain:       round2(audio.Gain(integrated.Float64)),
+		Peak:       round2(truePeak.Float64),
+	}
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
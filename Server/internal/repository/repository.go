@@ -0,0 +1,177 @@
+// Package repository reads and writes the catalog: songs, albums and
+// artists, along with the artists credited on them. The HTTP handlers and
+// other tools go through its interfaces instead of writing their own SQL.
+//
+// Reads take the id of the requesting user, whose annotations (stars and
+// ratings) are returned with each item.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"s3-music-streamer/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the song, album or artist doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by errors reporting a write that clashes with
+	// existing data, such as *NameConflictError.
+	ErrConflict = errors.New("conflict")
+	// ErrModified is returned by writes to an item at a Version it no longer
+	// has, because it was written since it was read.
+	ErrModified = errors.New("modified since it was read")
+)
+
+// Scanner is a *sql.Row or *sql.Rows.
+type Scanner interface {
+	Scan(dest ...any) error
+}
+
+// NameConflictError reports a name or alias that already identifies another
+// artist.
+type NameConflictError struct {
+	Name     string
+	ArtistID int64
+}
+
+func (e *NameConflictError) Error() string {
+	return fmt.Sprintf("%q already refers to artist %d", e.Name, e.ArtistID)
+}
+
+func (e *NameConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// MissingArtistError reports a credit naming an artist that doesn't exist.
+type MissingArtistError struct {
+	ID int64
+}
+
+func (e *MissingArtistError) Error() string {
+	return fmt.Sprintf("artist %d not found", e.ID)
+}
+
+// SongRepository stores songs. Lists and Get return the columns of songs
+// with their artist, album and disc names; credits, genres and tags are
+// loaded separately.
+type SongRepository interface {
+	List(ctx context.Context, filter SongFilter) ([]models.Song, error)
+	Get(ctx context.Context, user string, id int64) (*models.Song, error)
+	// Create inserts a song crediting song.Artists, in order, and sets its
+	// ID.
+	Create(ctx context.Context, song *models.Song) error
+	// CreateUpload creates upload.Song with the artist and album upload
+	// names, found or created as FindOrCreate does, and credits its artist
+	// as main artist if it credits none. Once the rows are committed, it
+	// calls store with the song's id, outside any transaction so as not to
+	// hold up other writes; if store fails, it deletes the song again, with
+	// the artists and album it created.
+	CreateUpload(ctx context.Context, upload *Upload, store func(id int64) error) error
+	// Update replaces the fields of song.ID set by Create. If song.Version
+	// is set, it fails with ErrModified unless the song is at that version.
+	Update(ctx context.Context, song *models.Song) error
+	// Delete removes a song with its credits, genres, lyrics, plays and
+	// annotations. If version is not 0, it fails with ErrModified unless
+	// the song is at that version.
+	Delete(ctx context.Context, id, version int64) error
+}
+
+// Upload is a song being uploaded, with the artist and album named by its
+// tags for those its form leaves out.
+type Upload struct {
+	Song *models.Song
+	// Artist names the song's artist, if Song.ArtistID is nil.
+	Artist string
+	// Album is the title of the song's album, if Song.AlbumID is nil. The
+	// album is by AlbumArtist, or else by the song's artist, unless it is a
+	// Compilation; if there is no artist either, the song has no album.
+	Album       string
+	AlbumArtist string
+	Compilation bool
+	Year        *int
+}
+
+// AlbumRepository stores albums. Lists and Get return the columns of albums
+// with the name of their first artist; Get also returns their discs.
+type AlbumRepository interface {
+	List(ctx context.Context, filter AlbumFilter) ([]models.Album, error)
+	Get(ctx context.Context, user string, id int64) (*models.Album, error)
+	// Create inserts an album crediting album.Artists, in order, with the
+	// subtitles of album.Discs, and sets its ID.
+	Create(ctx context.Context, album *models.Album) error
+	// Update replaces the fields of album.ID set by Create, checking
+	// album.Version as SongRepository.Update does.
+	Update(ctx context.Context, album *models.Album) error
+	// Delete removes an album with its credits, discs, genres and
+	// annotations, checking version as SongRepository.Delete does. Its
+	// songs are kept, without an album.
+	Delete(ctx context.Context, id, version int64) error
+	// FindOrCreate returns the id of the album with title, ignoring case, by
+	// the main artist artistID, or the compilation with title if artistID
+	// is nil. It creates the album if there is none.
+	FindOrCreate(ctx context.Context, title string, artistID *int64, year *int) (int64, error)
+}
+
+// ArtistRepository stores artists with their links and aliases. Names and
+// aliases must each identify a single artist, ignoring case.
+type ArtistRepository interface {
+	List(ctx context.Context, filter ArtistFilter) ([]models.Artist, error)
+	Get(ctx context.Context, user string, id int64) (*models.Artist, error)
+	// Create inserts an artist and sets its ID.
+	Create(ctx context.Context, artist *models.Artist) error
+	// Update replaces the profile of artist.ID, checking artist.Version as
+	// SongRepository.Update does.
+	Update(ctx context.Context, artist *models.Artist) error
+	// Delete removes an artist with its aliases, links and credits,
+	// checking version as SongRepository.Delete does. Songs and albums it
+	// is the artist of go to the next main artist credited on them, or are
+	// left without an artist.
+	Delete(ctx context.Context, id, version int64) error
+	// FindOrCreate returns the id of the artist called name, by name or
+	// alias and ignoring case, creating it if there is none.
+	FindOrCreate(ctx context.Context, name string) (int64, error)
+}
+
+// versioned returns the condition of a write to the row with id at
+// version, or at any version if version is 0, with its arguments.
+func versioned(id, version int64) (string, []any) {
+	if version == 0 {
+		return " WHERE id = ?", []any{id}
+	}
+	return " WHERE id = ? AND version = ?", []any{id, version}
+}
+
+// notWritten returns why a versioned write matched no row, given row, the
+// row's id selected from its table: ErrModified if it exists, or else
+// ErrNotFound.
+func notWritten(row *sql.Row) error {
+	var id int64
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrModified
+}
+
+// nullString stores empty optional text as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullInt stores unset optional numbers as NULL.
+func nullInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,331 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+	"s3-music-streamer/internal/models"
+	"s3-music-streamer/internal/repository"
+)
+
+const user = "test-user"
+
+func createArtist(t *testing.T, artists repository.ArtistRepository, name string) *models.Artist {
+	t.Helper()
+	artist := &models.Artist{Name: name}
+	if err := artists.Create(context.Background(), artist); err != nil {
+		t.Fatal(err)
+	}
+	return artist
+}
+
+func TestArtists(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		artists := repository.NewArtists(db)
+
+		artist := &models.Artist{
+			Name:    "Prince",
+			Aliases: []string{"The Artist"},
+			Links:   []models.ArtistLink{{Type: "website", URL: "https://prince.com"}},
+		}
+		if err := artists.Create(ctx, artist); err != nil {
+			t.Fatal(err)
+		}
+		got, err := artists.Get(ctx, user, artist.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Prince" || len(got.Aliases) != 1 || len(got.Links) != 1 || got.Version != 1 {
+			t.Errorf("got %+v", got)
+		}
+
+		// Names and aliases identify artists ignoring case
+		id, err := artists.FindOrCreate(ctx, "the artist")
+		if err != nil || id != artist.ID {
+			t.Errorf("FindOrCreate by alias = %d, %v, want %d", id, err, artist.ID)
+		}
+		id, err = artists.FindOrCreate(ctx, "PRINCE")
+		if err != nil || id != artist.ID {
+			t.Errorf("FindOrCreate by name = %d, %v, want %d", id, err, artist.ID)
+		}
+		err = artists.Create(ctx, &models.Artist{Name: "THE ARTIST"})
+		var conflict *repository.NameConflictError
+		if !errors.As(err, &conflict) || conflict.ArtistID != artist.ID || !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("creating an artist named like an alias gave %v", err)
+		}
+
+		// Updates at a stale version are refused
+		got.Bio = "Purple"
+		if err := artists.Update(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		got.Bio = "Rain"
+		if err := artists.Update(ctx, got); !errors.Is(err, repository.ErrModified) {
+			t.Errorf("stale update gave %v, want ErrModified", err)
+		}
+		if err := artists.Delete(ctx, artist.ID, 1); !errors.Is(err, repository.ErrModified) {
+			t.Errorf("stale delete gave %v, want ErrModified", err)
+		}
+
+		list, err := artists.List(ctx, repository.ArtistFilter{User: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Bio != "Purple" {
+			t.Errorf("listed %+v", list)
+		}
+
+		if err := artists.Delete(ctx, artist.ID, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := artists.Get(ctx, user, artist.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get after Delete gave %v, want ErrNotFound", err)
+		}
+		if err := artists.Delete(ctx, artist.ID, 0); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("deleting again gave %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestAlbums(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		artists := repository.NewArtists(db)
+		albums := repository.NewAlbums(db)
+		artist := createArtist(t, artists, "Nina Simone")
+
+		album := &models.Album{
+			Title:    "Pastel Blues",
+			ArtistID: &artist.ID,
+			Artists:  []models.ArtistCredit{{ID: artist.ID, Role: "main"}},
+			Year:     1965,
+			Discs:    []models.AlbumDisc{{Number: 1, Subtitle: "Side A"}},
+		}
+		if err := albums.Create(ctx, album); err != nil {
+			t.Fatal(err)
+		}
+		got, err := albums.Get(ctx, user, album.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ArtistName != "Nina Simone" || got.Year != 1965 || len(got.Discs) != 1 {
+			t.Errorf("got %+v", got)
+		}
+
+		// FindOrCreate finds albums by title ignoring case, per artist
+		id, err := albums.FindOrCreate(ctx, "PASTEL BLUES", &artist.ID, nil)
+		if err != nil || id != album.ID {
+			t.Errorf("FindOrCreate = %d, %v, want %d", id, err, album.ID)
+		}
+		compilationID, err := albums.FindOrCreate(ctx, "Jazz Hits", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := albums.FindOrCreate(ctx, "jazz hits", nil, nil); err != nil || id != compilationID {
+			t.Errorf("FindOrCreate found compilation %d, %v, want %d", id, err, compilationID)
+		}
+
+		compilation := true
+		list, err := albums.List(ctx, repository.AlbumFilter{User: user, Compilation: &compilation})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].ID != compilationID {
+			t.Errorf("compilations are %+v", list)
+		}
+		list, err = albums.List(ctx, repository.AlbumFilter{User: user, ArtistID: &artist.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].ID != album.ID {
+			t.Errorf("albums of the artist are %+v", list)
+		}
+
+		// Crediting a missing artist fails, and changes nothing
+		got.Artists = []models.ArtistCredit{{ID: 999, Role: "main"}}
+		var missing *repository.MissingArtistError
+		if err := albums.Update(ctx, got); !errors.As(err, &missing) || missing.ID != 999 {
+			t.Errorf("crediting a missing artist gave %v", err)
+		}
+		if again, err := albums.Get(ctx, user, album.ID); err != nil || again.Version != got.Version {
+			t.Errorf("a failed update changed the album: %+v, %v", again, err)
+		}
+	})
+}
+
+func TestSongs(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		artists := repository.NewArtists(db)
+		songs := repository.NewSongs(db)
+		singer := createArtist(t, artists, "Nina Simone")
+		pianist := createArtist(t, artists, "Hal Singer")
+		albumID, err := repository.NewAlbums(db).FindOrCreate(ctx, "Pastel Blues", &singer.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		track := 9
+		song := &models.Song{
+			Title:       "Sinnerman",
+			ArtistID:    &singer.ID,
+			AlbumID:     &albumID,
+			TrackNumber: &track,
+			Artists: []models.ArtistCredit{
+				{ID: singer.ID, Role: "main"},
+				{ID: pianist.ID, Role: "featured"},
+			},
+			Duration:    622,
+			ContentType: "audio/mpeg",
+		}
+		if err := songs.Create(ctx, song); err != nil {
+			t.Fatal(err)
+		}
+		got, err := songs.Get(ctx, user, song.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.AlbumTitle != "Pastel Blues" || got.ArtistName != "Nina Simone" || *got.TrackNumber != 9 {
+			t.Errorf("got %+v", got)
+		}
+
+		// Songs are listed by any credited artist, or by role
+		list, err := songs.List(ctx, repository.SongFilter{User: user, ArtistID: &pianist.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 {
+			t.Errorf("songs crediting the pianist are %+v", list)
+		}
+		list, err = songs.List(ctx, repository.SongFilter{User: user, ArtistID: &pianist.ID, Role: "main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 0 {
+			t.Errorf("songs by the pianist as main artist are %+v", list)
+		}
+
+		got.Title = "Sinnerman (Live)"
+		if err := songs.Update(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := songs.Update(ctx, got); !errors.Is(err, repository.ErrModified) {
+			t.Errorf("stale update gave %v, want ErrModified", err)
+		}
+		got.Version = 0
+		if err := songs.Update(ctx, got); err != nil {
+			t.Errorf("unconditional update gave %v", err)
+		}
+
+		missingAlbum := int64(999)
+		err = songs.Create(ctx, &models.Song{Title: "Lost", AlbumID: &missingAlbum})
+		if constraintErr, ok := database.AsConstraintError(err); !ok || constraintErr.Kind != database.ForeignKey {
+			t.Errorf("creating a song on a missing album gave %v", err)
+		}
+
+		if err := songs.Delete(ctx, song.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := songs.Get(ctx, user, song.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get after Delete gave %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestCreateUpload(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		songs := repository.NewSongs(db)
+		year := 1966
+		newUpload := func() *repository.Upload {
+			return &repository.Upload{
+				Song:        &models.Song{Title: "Wild Is the Wind", ContentType: "audio/mpeg"},
+				Artist:      "Nina Simone",
+				Album:       "Wild Is the Wind",
+				AlbumArtist: "Nina",
+				Year:        &year,
+			}
+		}
+
+		// Nothing is left if the audio can't be stored
+		failed := errors.New("no storage")
+		var storedID int64
+		err := songs.CreateUpload(ctx, newUpload(), func(id int64) error {
+			storedID = id
+			return failed
+		})
+		if !errors.Is(err, failed) || storedID == 0 {
+			t.Fatalf("got %v storing song %d, want the error of store", err, storedID)
+		}
+		for _, table := range []string{"artists", "albums", "album_artists", "songs", "song_artists"} {
+			expectRows(t, db, 0, "SELECT COUNT(*) FROM "+table)
+		}
+
+		// but artists that were there already stay
+		if _, err := repository.NewArtists(db).FindOrCreate(ctx, "Nina"); err != nil {
+			t.Fatal(err)
+		}
+		if err := songs.CreateUpload(ctx, newUpload(), func(int64) error { return failed }); !errors.Is(err, failed) {
+			t.Fatalf("got %v, want the error of store", err)
+		}
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM artists WHERE name = 'Nina'")
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM artists")
+		expectRows(t, db, 0, "SELECT COUNT(*) FROM albums")
+
+		// Other writes go ahead while the audio is stored
+		err = songs.CreateUpload(ctx, newUpload(), func(id int64) error {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			_, err := db.ExecContext(ctx, "INSERT INTO genres (name) VALUES ('Jazz')")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("writing while storing: %v", err)
+		}
+
+		upload := newUpload()
+		if err := songs.CreateUpload(ctx, upload, func(int64) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		song, err := songs.Get(ctx, user, upload.Song.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if song.ArtistName != "Nina Simone" || song.AlbumTitle != "Wild Is the Wind" {
+			t.Errorf("got %+v", song)
+		}
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM song_artists WHERE song_id = ? AND role = 'main'", song.ID)
+		expectRows(t, db, 1, "SELECT COUNT(*) FROM albums a JOIN artists ar ON ar.id = a.artist_id WHERE ar.name = 'Nina' AND a.year = 1966")
+
+		// Uploads from the same album and artist share them
+		again := newUpload()
+		again.Song.Title = "Four Women"
+		if err := songs.CreateUpload(ctx, again, func(int64) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if *again.Song.AlbumID != *upload.Song.AlbumID || *again.Song.ArtistID != *upload.Song.ArtistID {
+			t.Errorf("second upload is on album %d by %d", *again.Song.AlbumID, *again.Song.ArtistID)
+		}
+		expectRows(t, db, 2, "SELECT COUNT(*) FROM artists")
+
+		// Compilations have no artist, and songs without one no album
+		compilation := &repository.Upload{Song: &models.Song{Title: "Feeling Good"}, Album: "Hits", Compilation: true}
+		loose := &repository.Upload{Song: &models.Song{Title: "Untitled"}, Album: "Demos"}
+		for _, upload := range []*repository.Upload{compilation, loose} {
+			if err := songs.CreateUpload(ctx, upload, func(int64) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if compilation.Song.AlbumID == nil || artistOf(t, db, "albums", *compilation.Song.AlbumID) != 0 {
+			t.Errorf("compilation upload is on album %v", compilation.Song.AlbumID)
+		}
+		if loose.Song.AlbumID != nil {
+			t.Errorf("upload without an artist is on album %d", *loose.Song.AlbumID)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/models"
+)
+
+// SongSelect selects the columns read by ScanSong. It takes the requesting
+// user's id as its first parameter, for the annotations join. It is
+// exported for queries listing songs through other tables, such as
+// playlists.
+const SongSelect = `
+	SELECT s.id, s.title, s.artist_id, s.album_id, s.disc_number, s.track_number, s.disc_total,
+	       s.track_total, s.duration, s.file_size, s.content_type, s.loudness, s.true_peak,
+	       s.created_at, s.updated_at, s.version, ar.name as artist_name, al.title as album_title, ad.subtitle,
+	       al.loudness, al.true_peak,
+	       COALESCE(an.starred, 0), an.starred_at, COALESCE(an.rating, 0)
+	FROM songs s
+	LEFT JOIN artists ar ON s.artist_id = ar.id
+	LEFT JOIN albums al ON s.album_id = al.id
+	LEFT JOIN album_discs ad ON ad.album_id = s.album_id AND ad.disc_number = COALESCE(s.disc_number, 1)
+	LEFT JOIN annotations an ON an.item_type = 'song' AND an.item_id = s.id AND an.user_id = ?
+`
+
+// AlbumTrackOrder orders the songs of an album by disc, then track. Songs
+// without a disc number are on the first disc.
+const AlbumTrackOrder = "COALESCE(s.disc_number, 1) ASC, s.track_number ASC"
+
+func ScanSong(row Scanner) (models.Song, error) {
+	var song models.Song
+	var artistName, albumTitle, discSubtitle sql.NullString
+	var discNumber, trackNumber, discTotal, trackTotal sql.NullInt64
+	var loudness, truePeak, albumLoudness, albumTruePeak sql.NullFloat64
+	err := row.Scan(
+		&song.ID, &song.Title, &song.ArtistID, &song.AlbumID, &discNumber, &trackNumber, &discTotal,
+		&trackTotal, &song.Duration, &song.FileSize, &song.ContentType, &loudness, &truePeak,
+		&song.CreatedAt, &song.UpdatedAt, &song.Version, &artistName, &albumTitle, &discSubtitle,
+		&albumLoudness, &albumTruePeak, &song.Starred, &song.StarredAt, &song.Rating,
+	)
+	if err != nil {
+		return song, err
+	}
+	if discNumber.Valid {
+		disc := int(discNumber.Int64)
+		song.DiscNumber = &disc
+	}
+	if trackNumber.Valid {
+		track := int(trackNumber.Int64)
+		song.TrackNumber = &track
+	}
+	song.DiscTotal = int(discTotal.Int64)
+	song.TrackTotal = int(trackTotal.Int64)
+	song.ArtistName = artistName.String
+	song.AlbumTitle = albumTitle.String
+	song.DiscSubtitle = discSubtitle.String
+	song.Loudness = newLoudness(loudness, truePeak)
+	song.AlbumLoudness = newLoudness(albumLoudness, albumTruePeak)
+	return song, nil
+}
+
+func ScanSongs(rows *sql.Rows) ([]models.Song, error) {
+	songs := []models.Song{}
+	for rows.Next() {
+		song, err := ScanSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
+
+// Songs is the SongRepository of a database.
+type Songs struct {
+	db *database.DB
+}
+
+var _ SongRepository = (*Songs)(nil)
+
+func NewSongs(db *database.DB) *Songs {
+	return &Songs{db: db}
+}
+
+func (s *Songs) List(ctx context.Context, filter SongFilter) ([]models.Song, error) {
+	var c conditions
+	c.annotations(filter.AnnotationFilter)
+
+	orderBy := " ORDER BY s.created_at DESC"
+	if filter.ArtistID != nil {
+		// Match songs crediting the artist in any role, or only in Role
+		credit := "SELECT song_id FROM song_artists WHERE artist_id = ?"
+		args := []any{*filter.ArtistID}
+		if filter.Role != "" {
+			credit += " AND role = ?"
+			args = append(args, filter.Role)
+		}
+		c.add("s.id IN ("+credit+")", args...)
+	} else if filter.AlbumID != nil {
+		c.add("s.album_id = ?", *filter.AlbumID)
+		orderBy = " ORDER BY " + AlbumTrackOrder + ", s.created_at DESC"
+	}
+	if filter.Genre != "" {
+		c.genre(filter.Genre, "song_genres", "song_id", "s.id")
+	}
+	if filter.Tag != "" {
+		c.tag(filter.User, filter.Tag, "song", "s.id")
+	}
+
+	rows, err := s.db.QueryContext(ctx, SongSelect+c.String()+orderBy, append([]any{filter.User}, c.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return ScanSongs(rows)
+}
+
+func (s *Songs) Get(ctx context.Context, user string, id int64) (*models.Song, error) {
+	song, err := ScanSong(s.db.QueryRowContext(ctx, SongSelect+" WHERE s.id = ?", user, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (s *Songs) Create(ctx context.Context, song *models.Song) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := insertSong(ctx, tx, song)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	song.ID = id
+	return nil
+}
+
+func (s *Songs) CreateUpload(ctx context.Context, upload *Upload, store func(id int64) error) error {
+	created, rows, err := s.insertUpload(ctx, upload)
+	if err != nil {
+		return err
+	}
+	if err := store(created.ID); err != nil {
+		// Whatever happened to the request, the rows must go
+		if removeErr := s.removeUpload(context.WithoutCancel(ctx), rows); removeErr != nil {
+			return errors.Join(err, removeErr)
+		}
+		return err
+	}
+	*upload.Song = *created
+	return nil
+}
+
+// uploadRows are the rows written for an upload: its song, and the artists
+// and album it created.
+type uploadRows struct {
+	song    int64
+	artists []int64
+	album   int64
+}
+
+// insertUpload writes the rows of upload, returning its song.
+func (s *Songs) insertUpload(ctx context.Context, upload *Upload) (*models.Song, uploadRows, error) {
+	var rows uploadRows
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, rows, err
+	}
+	defer tx.Rollback()
+
+	findArtist := func(name string) (*int64, error) {
+		id, created, err := findOrCreateArtist(ctx, tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			rows.artists = append(rows.artists, id)
+		}
+		return &id, nil
+	}
+
+	song := upload.Song
+	artistID := song.ArtistID
+	if artistID == nil && strings.TrimSpace(upload.Artist) != "" {
+		if artistID, err = findArtist(upload.Artist); err != nil {
+			return nil, rows, err
+		}
+	}
+	albumID := song.AlbumID
+	if albumID == nil && strings.TrimSpace(upload.Album) != "" {
+		albumArtistID := artistID
+		switch {
+		case upload.Compilation:
+			albumArtistID = nil
+		case strings.TrimSpace(upload.AlbumArtist) != "":
+			if albumArtistID, err = findArtist(upload.AlbumArtist); err != nil {
+				return nil, rows, err
+			}
+		}
+		if upload.Compilation || albumArtistID != nil {
+			id, created, err := findOrCreateAlbum(ctx, tx, strings.TrimSpace(upload.Album), albumArtistID, upload.Year)
+			if err != nil {
+				return nil, rows, err
+			}
+			if created {
+				rows.album = id
+			}
+			albumID = &id
+		}
+	}
+
+	created := *song
+	created.ArtistID, created.AlbumID = artistID, albumID
+	if len(created.Artists) == 0 && artistID != nil {
+		created.Artists = []models.ArtistCredit{{ID: *artistID, Role: roleMain}}
+	}
+	if created.ID, err = insertSong(ctx, tx, &created); err != nil {
+		return nil, rows, err
+	}
+	rows.song = created.ID
+	return &created, rows, tx.Commit()
+}
+
+// removeUpload deletes the rows of an upload whose audio couldn't be
+// stored. The artists and album it created are kept if other songs or
+// albums have come to use them since.
+func (s *Songs) removeUpload(ctx context.Context, rows uploadRows) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM songs WHERE id = ?", rows.song); err != nil {
+		return err
+	}
+	for _, annotations := range []string{"annotations", "item_tags"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+annotations+" WHERE item_type = 'song' AND item_id = ?", rows.song); err != nil {
+			return err
+		}
+	}
+	if rows.album != 0 {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM albums
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM songs WHERE album_id = albums.id)
+		`, rows.album)
+		if err != nil {
+			return err
+		}
+	}
+	for _, artistID := range rows.artists {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM artists
+			WHERE id = ?
+			  AND NOT EXISTS (SELECT 1 FROM songs WHERE artist_id = artists.id)
+			  AND NOT EXISTS (SELECT 1 FROM albums WHERE artist_id = artists.id)
+			  AND NOT EXISTS (SELECT 1 FROM song_artists WHERE artist_id = artists.id)
+			  AND NOT EXISTS (SELECT 1 FROM album_artists WHERE artist_id = artists.id)
+		`, artistID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertSong inserts song and its credits in tx, returning its id.
+func insertSong(ctx context.Context, tx *database.Tx, song *models.Song) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO songs (title, artist_id, album_id, disc_number, track_number, disc_total, track_total,
+		                   duration, file_size, content_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, song.Title, song.ArtistID, song.AlbumID, song.DiscNumber, song.TrackNumber, nullInt(song.DiscTotal),
+		nullInt(song.TrackTotal), song.Duration, song.FileSize, song.ContentType).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := songCredits.set(tx, id, song.Artists); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *Songs) Update(ctx context.Context, song *models.Song) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, whereArgs := versioned(song.ID, song.Version)
+	result, err := tx.Exec(`
+		UPDATE songs
+		SET title = ?, artist_id = ?, album_id = ?, disc_number = ?, track_number = ?, disc_total = ?,
+		    track_total = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+	`+where, append([]any{song.Title, song.ArtistID, song.AlbumID, song.DiscNumber, song.TrackNumber,
+		nullInt(song.DiscTotal), nullInt(song.TrackTotal)}, whereArgs...)...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return notWritten(tx.QueryRow("SELECT id FROM songs WHERE id = ?", song.ID))
+	}
+
+	if err := songCredits.set(tx, song.ID, song.Artists); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Songs) Delete(ctx context.Context, id, version int64) error {
+	return deleteRow(ctx, s.db, "songs", "song", id, version, nil)
+}
+
+// deleteRow deletes row id of table, the item of itemType that users
+// annotate and tag, in a transaction beginning with prepare, if not nil.
+// Rows referring to it, such as credits, go with it through the ON DELETE
+// clauses of the schema; annotations and tags, which refer to items of
+// any type, are deleted here.
+func deleteRow(ctx context.Context, db *database.DB, table, itemType string, id, version int64, prepare func(tx *database.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if prepare != nil {
+		if err := prepare(tx); err != nil {
+			return err
+		}
+	}
+	where, args := versioned(id, version)
+	result, err := tx.ExecContext(ctx, "DELETE FROM "+table+where, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return notWritten(tx.QueryRowContext(ctx, "SELECT id FROM "+table+" WHERE id = ?", id))
+	}
+	for _, annotations := range []string{"annotations", "item_tags"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+annotations+" WHERE item_type = ? AND item_id = ?", itemType, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,271 @@
+package scrobble
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"s3-music-streamer/internal/database"
+)
+
+const (
+	flushInterval  = time.Minute
+	flushBatchSize = 100
+	submitTimeout  = 30 * time.Second
+
+	// Failed submissions are retried with exponential backoff from
+	// minRetryDelay up to maxRetryDelay, and given up on after maxAttempts
+	// (roughly three days of a service being unreachable).
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = 6 * time.Hour
+	maxAttempts   = 20
+)
+
+// Forwarder queues plays for every scrobbling service a user has connected
+// and submits them in the background. Plays stay queued in the database
+// while a service is unreachable, so nothing is lost across restarts.
+type Forwarder struct {
+	db       *database.DB
+	tokens   *TokenCipher
+	services map[string]Service
+}
+
+// NewForwarder returns a Forwarder storing tokens sealed by tokens, which
+// may be nil.
+func NewForwarder(db *database.DB, tokens *TokenCipher, services ...Service) *Forwarder {
+	f := &Forwarder{
+		db:       db,
+		tokens:   tokens,
+		services: make(map[string]Service),
+	}
+	for _, service := range services {
+		f.services[service.Name()] = service
+	}
+	return f
+}
+
+// Service returns the configured service with the given name.
+func (f *Forwarder) Service(name string) (Service, bool) {
+	service, ok := f.services[name]
+	return service, ok
+}
+
+// SealToken returns the token of an account as it is to be stored.
+func (f *Forwarder) SealToken(token string) (string, error) {
+	return f.tokens.Seal(token)
+}
+
+// account returns the account of integration i with its stored token
+// opened.
+func (f *Forwarder) account(i integration) (Account, error) {
+	account := i.account
+	token, err := f.tokens.Open(account.Token)
+	if err != nil {
+		return account, &Error{Service: i.service.Name(), Err: err}
+	}
+	account.Token = token
+	return account, nil
+}
+
+// Enqueue queues a completed play for each of the user's enabled
+// integrations.
+func (f *Forwarder) Enqueue(ctx context.Context, user string, listen Listen) error {
+	integrations, err := f.integrations(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, integration := range integrations {
+		_, err := f.db.ExecContext(ctx, `
+			INSERT INTO scrobble_queue (integration_id, artist_name, track_name, album_name, track_number, duration, listened_at, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, integration.id, listen.ArtistName, listen.TrackName, listen.ReleaseName, listen.TrackNumber,
+			listen.Duration, listen.ListenedAt, now)
+		if err != nil {
+			return fmt.Errorf("failed to queue scrobble: %w", err)
+		}
+	}
+	return nil
+}
+
+// NowPlaying tells the user's services what they are listening to. Now
+// playing updates are only useful immediately, so they are sent in the
+// background once and never queued.
+func (f *Forwarder) NowPlaying(ctx context.Context, user string, listen Listen) error {
+	integrations, err := f.integrations(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	for _, integration := range integrations {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), submitTimeout)
+			defer cancel()
+			account, err := f.account(integration)
+			if err == nil {
+				err = integration.service.NowPlaying(ctx, account, listen)
+			}
+			if err != nil {
+				log.Printf("Failed to send now playing for %s to %s: %v", user, integration.service.Name(), err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Run flushes the queue periodically until ctx is cancelled.
+func (f *Forwarder) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Flush(ctx); err != nil {
+			log.Printf("Failed to flush scrobble queue: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type queuedScrobble struct {
+	id          int64
+	attempts    int
+	integration integration
+	listen      Listen
+}
+
+// Flush submits every queued scrobble that is due, oldest first.
+func (f *Forwarder) Flush(ctx context.Context) error {
+	rows, err := f.db.QueryContext(ctx, `
+		SELECT q.id, q.attempts, q.artist_name, q.track_name, q.album_name, q.track_number, q.duration, q.listened_at,
+		       i.id, i.service, i.token, i.username
+		FROM scrobble_queue q
+		JOIN scrobble_integrations i ON q.integration_id = i.id
+		WHERE q.status = 'pending' AND q.next_attempt_at <= ? AND i.enabled = 1
+		ORDER BY q.listened_at ASC
+		LIMIT ?
+	`, time.Now().UTC(), flushBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var queue []queuedScrobble
+	for rows.Next() {
+		var item queuedScrobble
+		var serviceName string
+		if err := rows.Scan(
+			&item.id, &item.attempts, &item.listen.ArtistName, &item.listen.TrackName, &item.listen.ReleaseName,
+			&item.listen.TrackNumber, &item.listen.Duration, &item.listen.ListenedAt,
+			&item.integration.id, &serviceName, &item.integration.account.Token, &item.integration.account.Username,
+		); err != nil {
+			rows.Close()
+			return err
+		}
+		service, ok := f.services[serviceName]
+		if !ok {
+			continue
+		}
+		item.integration.service = service
+		queue = append(queue, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Once a service fails with a temporary error, leave the rest of that
+	// integration's queue alone until the next flush
+	unavailable := make(map[int64]bool)
+	for _, item := range queue {
+		if unavailable[item.integration.id] {
+			continue
+		}
+
+		account, err := f.account(item.integration)
+		if err == nil {
+			submitCtx, cancel := context.WithTimeout(ctx, submitTimeout)
+			err = item.integration.service.Scrobble(submitCtx, account, item.listen)
+			cancel()
+		}
+
+		if err == nil {
+			if _, err := f.db.ExecContext(ctx, "DELETE FROM scrobble_queue WHERE id = ?", item.id); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f.recordFailure(ctx, item, err); err != nil {
+			return err
+		}
+		if IsTemporary(err) {
+			unavailable[item.integration.id] = true
+		}
+	}
+	return nil
+}
+
+func (f *Forwarder) recordFailure(ctx context.Context, item queuedScrobble, submitErr error) error {
+	attempts := item.attempts + 1
+	status := "pending"
+	if !IsTemporary(submitErr) || attempts >= maxAttempts {
+		status = "failed"
+	}
+
+	_, err := f.db.ExecContext(ctx, `
+		UPDATE scrobble_queue
+		SET attempts = ?, status = ?, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, attempts, status, submitErr.Error(), time.Now().UTC().Add(retryDelay(attempts)), item.id)
+	return err
+}
+
+// retryDelay is how long to wait before the given attempt number.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+type integration struct {
+	id      int64
+	service Service
+	account Account
+}
+
+// integrations returns the user's enabled integrations with a configured
+// service.
+func (f *Forwarder) integrations(ctx context.Context, user string) ([]integration, error) {
+	rows, err := f.db.QueryContext(ctx, `
+		SELECT id, service, token, username
+		FROM scrobble_integrations
+		WHERE user_id = ? AND enabled = 1
+	`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var integrations []integration
+	for rows.Next() {
+		var i integration
+		var serviceName string
+		if err := rows.Scan(&i.id, &serviceName, &i.account.Token, &i.account.Username); err != nil {
+			return nil, err
+		}
+		service, ok := f.services[serviceName]
+		if !ok {
+			continue
+		}
+		i.service = service
+		integrations = append(integrations, i)
+	}
+	return integrations, rows.Err()
+}
@@ -0,0 +1,280 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"s3-music-streamer/internal/database"
+	"s3-music-streamer/internal/database/dbtest"
+)
+
+const user = "test-user"
+
+// listenBrainzServer answers submissions with the next of statuses, then
+// with 200 once they run out, recording the tracks of those it accepts.
+type listenBrainzServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	tracks   []string
+}
+
+func newListenBrainzServer(t *testing.T, statuses ...int) *listenBrainzServer {
+	s := &listenBrainzServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization is %q", got)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		var submission listenBrainzSubmission
+		if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+			t.Error(err)
+		}
+		s.tracks = append(s.tracks, submission.Payload[0].TrackMetadata.TrackName)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newForwarder returns a Forwarder to a ListenBrainz server for a user
+// whose integration has token, and its id.
+func newForwarder(t *testing.T, db *database.DB, server *httptest.Server, tokens *TokenCipher, token string) (*Forwarder, int64) {
+	t.Helper()
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO scrobble_integrations (user_id, service, token, username)
+		VALUES (?, ?, ?, 'nina')
+		RETURNING id
+	`, user, ServiceListenBrainz, token).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewForwarder(db, tokens, NewListenBrainz(server.URL, server.Client())), id
+}
+
+func enqueue(t *testing.T, f *Forwarder, tracks ...string) {
+	t.Helper()
+	for i, track := range tracks {
+		listen := sinnerman
+		listen.TrackName = track
+		listen.ListenedAt = listen.ListenedAt.Add(time.Duration(i) * time.Minute)
+		if err := f.Enqueue(context.Background(), user, listen); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type queued struct {
+	track    string
+	status   string
+	attempts int
+	due      bool
+}
+
+func queue(t *testing.T, db *database.DB) []queued {
+	t.Helper()
+	rows, err := db.Query("SELECT track_name, status, attempts, next_attempt_at FROM scrobble_queue ORDER BY listened_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var items []queued
+	for rows.Next() {
+		var item queued
+		var next time.Time
+		if err := rows.Scan(&item.track, &item.status, &item.attempts, &next); err != nil {
+			t.Fatal(err)
+		}
+		item.due = !next.After(time.Now())
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+// makeDue makes every queued scrobble due for another attempt.
+func makeDue(t *testing.T, db *database.DB) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE scrobble_queue SET next_attempt_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushRetriesTemporaryFailures(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		server := newListenBrainzServer(t, http.StatusServiceUnavailable)
+		f, _ := newForwarder(t, db, server.Server, nil, "secret")
+		enqueue(t, f, "Sinnerman", "Feeling Good")
+
+		// The service being down, the rest of its queue waits too
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		got := queue(t, db)
+		want := []queued{{"Sinnerman", "pending", 1, false}, {"Feeling Good", "pending", 0, true}}
+		if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Fatalf("queue is %+v, want %+v", got, want)
+		}
+
+		// Only what is due is sent
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(server.tracks) != 1 || server.tracks[0] != "Feeling Good" {
+			t.Errorf("sent %v before the retry was due", server.tracks)
+		}
+
+		makeDue(t, db)
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := queue(t, db); len(got) != 0 {
+			t.Errorf("queue is %+v after the service came back", got)
+		}
+		if len(server.tracks) != 2 || server.tracks[1] != "Sinnerman" {
+			t.Errorf("sent %v", server.tracks)
+		}
+	})
+}
+
+func TestFlushGivesUpOnPermanentFailures(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		server := newListenBrainzServer(t, http.StatusBadRequest)
+		f, _ := newForwarder(t, db, server.Server, nil, "secret")
+		enqueue(t, f, "Sinnerman", "Feeling Good")
+
+		// Other scrobbles are still sent
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		got := queue(t, db)
+		if len(got) != 1 || got[0].track != "Sinnerman" || got[0].status != "failed" || got[0].attempts != 1 {
+			t.Fatalf("queue is %+v", got)
+		}
+		if len(server.tracks) != 1 || server.tracks[0] != "Feeling Good" {
+			t.Errorf("sent %v", server.tracks)
+		}
+
+		makeDue(t, db)
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := queue(t, db); len(got) != 1 || got[0].attempts != 1 {
+			t.Errorf("a failed scrobble was retried: %+v", got)
+		}
+	})
+}
+
+func TestFlushGivesUpAfterMaxAttempts(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		server := newListenBrainzServer(t, http.StatusBadGateway)
+		f, _ := newForwarder(t, db, server.Server, nil, "secret")
+		enqueue(t, f, "Sinnerman")
+		if _, err := db.Exec("UPDATE scrobble_queue SET attempts = ?", maxAttempts-1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := queue(t, db); len(got) != 1 || got[0].status != "failed" || got[0].attempts != maxAttempts {
+			t.Errorf("queue is %+v", got)
+		}
+	})
+}
+
+func TestFlushKeepsQueueWhileOffline(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		server := newListenBrainzServer(t)
+		f, integrationID := newForwarder(t, db, server.Server, nil, "secret")
+		server.Close()
+		enqueue(t, f, "Sinnerman", "Feeling Good")
+
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		got := queue(t, db)
+		if len(got) != 2 || got[0].status != "pending" || got[0].attempts != 1 || got[1].attempts != 0 {
+			t.Fatalf("queue is %+v", got)
+		}
+
+		// Nor is anything sent for disabled integrations
+		if _, err := db.Exec("UPDATE scrobble_integrations SET enabled = 0 WHERE id = ?", integrationID); err != nil {
+			t.Fatal(err)
+		}
+		makeDue(t, db)
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := queue(t, db); got[0].attempts != 1 {
+			t.Errorf("queue of a disabled integration is %+v", got)
+		}
+	})
+}
+
+func TestFlushOpensSealedTokens(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *database.DB) {
+		tokens, err := NewTokenCipher(testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := newListenBrainzServer(t)
+		f, integrationID := newForwarder(t, db, server.Server, tokens, "secret")
+
+		// Tokens stored before the key was configured are sealed
+		if err := f.SealTokens(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var stored string
+		if err := db.QueryRow("SELECT token FROM scrobble_integrations WHERE id = ?", integrationID).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(stored, sealedPrefix) || strings.Contains(stored, "secret") {
+			t.Errorf("token is stored as %q", stored)
+		}
+
+		enqueue(t, f, "Sinnerman")
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(server.tracks) != 1 {
+			t.Errorf("sent %v", server.tracks)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{maxAttempts, 6 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Last.fm error codes that mean "try again later".
+var lastFMTemporaryErrors = map[int]bool{
+	8:  true, // operation failed
+	11: true, // service offline
+	16: true, // temporarily unavailable
+	29: true, // rate limit exceeded
+}
+
+// LastFM submits scrobbles through the Last.fm 2.0 web service API.
+type LastFM struct {
+	baseURL   string
+	apiKey    string
+	apiSecret string
+	client    *http.Client
+}
+
+func NewLastFM(baseURL, apiKey, apiSecret string, client *http.Client) *LastFM {
+	return &LastFM{
+		baseURL:   baseURL,
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		client:    client,
+	}
+}
+
+func (lf *LastFM) Name() string {
+	return ServiceLastFM
+}
+
+// Authenticate exchanges a token the user authorized on last.fm for a
+// session key, which never expires.
+func (lf *LastFM) Authenticate(ctx context.Context, token string) (*Account, error) {
+	var result struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	err := lf.call(ctx, url.Values{
+		"method": {"auth.getSession"},
+		"token":  {token},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{Token: result.Session.Key, Username: result.Session.Name}, nil
+}
+
+func (lf *LastFM) NowPlaying(ctx context.Context, account Account, listen Listen) error {
+	params := lf.trackParams(listen)
+	params.Set("method", "track.updateNowPlaying")
+	params.Set("sk", account.Token)
+	return lf.call(ctx, params, nil)
+}
+
+func (lf *LastFM) Scrobble(ctx context.Context, account Account, listen Listen) error {
+	params := lf.trackParams(listen)
+	params.Set("method", "track.scrobble")
+	params.Set("sk", account.Token)
+	params.Set("timestamp", strconv.FormatInt(listen.ListenedAt.Unix(), 10))
+
+	var result struct {
+		Scrobbles struct {
+			Attr struct {
+				Accepted int `json:"accepted"`
+				Ignored  int `json:"ignored"`
+			} `json:"@attr"`
+		} `json:"scrobbles"`
+	}
+	if err := lf.call(ctx, params, &result); err != nil {
+		return err
+	}
+	if result.Scrobbles.Attr.Ignored > 0 {
+		return &Error{Service: ServiceLastFM, Err: fmt.Errorf("scrobble ignored by last.fm")}
+	}
+	return nil
+}
+
+func (lf *LastFM) trackParams(listen Listen) url.Values {
+	params := url.Values{
+		"artist": {listen.ArtistName},
+		"track":  {listen.TrackName},
+	}
+	if listen.ReleaseName != "" {
+		params.Set("album", listen.ReleaseName)
+	}
+	if listen.TrackNumber > 0 {
+		params.Set("trackNumber", strconv.Itoa(listen.TrackNumber))
+	}
+	if listen.Duration > 0 {
+		params.Set("duration", strconv.Itoa(listen.Duration))
+	}
+	return params
+}
+
+// call signs and POSTs a Last.fm API method.
+func (lf *LastFM) call(ctx context.Context, params url.Values, result any) error {
+	params.Set("api_key", lf.apiKey)
+	params.Set("api_sig", lf.sign(params))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lf.baseURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := lf.client.Do(req)
+	if err != nil {
+		return &Error{Service: ServiceLastFM, Temporary: true, Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Service: ServiceLastFM, Temporary: true, Err: err}
+	}
+
+	// Last.fm reports failures in the body, sometimes with a 200 status
+	var apiErr struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != 0 {
+		return &Error{
+			Service:   ServiceLastFM,
+			Temporary: lastFMTemporaryErrors[apiErr.Error],
+			Err:       fmt.Errorf("error %d: %s", apiErr.Error, apiErr.Message),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(ServiceLastFM, resp.StatusCode, string(body))
+	}
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return &Error{Service: ServiceLastFM, Err: fmt.Errorf("invalid response: %w", err)}
+		}
+	}
+	return nil
+}
+
+// sign computes api_sig: the MD5 of every parameter name and value in
+// name order, followed by the shared secret.
+func (lf *LastFM) sign(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "format" && key != "callback" && key != "api_sig" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString(params.Get(key))
+	}
+	b.WriteString(lf.apiSecret)
+
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
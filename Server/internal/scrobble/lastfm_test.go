@@ -0,0 +1,113 @@
+package scrobble
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestLastFMSign(t *testing.T) {
+	lf := NewLastFM("", "key", "secret", nil)
+	params := url.Values{
+		"method":  {"auth.getSession"},
+		"token":   {"abc"},
+		"api_key": {"key"},
+		"format":  {"json"},
+		"api_sig": {"left out"},
+	}
+	// md5 of "api_keykeymethodauth.getSessiontokenabcsecret"
+	if got, want := lf.sign(params), "6629efc98b97f7c35ff32314185ffaa1"; got != want {
+		t.Errorf("signature is %s, want %s", got, want)
+	}
+}
+
+// lastFMServer answers Last.fm API calls with body, after checking that
+// they are signed.
+func lastFMServer(t *testing.T, status int, body string) (*httptest.Server, *url.Values) {
+	t.Helper()
+	var received url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		received = r.PostForm
+		if r.PostForm.Get("format") != "json" || r.PostForm.Get("api_key") != "key" {
+			t.Errorf("posted %v", r.PostForm)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestLastFMScrobble(t *testing.T) {
+	server, received := lastFMServer(t, http.StatusOK, `{"scrobbles": {"@attr": {"accepted": 1, "ignored": 0}}}`)
+	lf := NewLastFM(server.URL, "key", "secret", server.Client())
+	if err := lf.Scrobble(context.Background(), Account{Token: "SESSION"}, sinnerman); err != nil {
+		t.Fatal(err)
+	}
+
+	want := url.Values{
+		"method":      {"track.scrobble"},
+		"sk":          {"SESSION"},
+		"artist":      {"Nina Simone"},
+		"track":       {"Sinnerman"},
+		"album":       {"Pastel Blues"},
+		"trackNumber": {"9"},
+		"duration":    {"622"},
+		"timestamp":   {"1714593600"},
+		// md5 of the parameters other than format, sorted, then the secret
+		"api_sig": {"8268afc92eccd3cfc1de75ae5f15a38a"},
+	}
+	for key, value := range want {
+		if got := received.Get(key); got != value[0] {
+			t.Errorf("%s is %q, want %q", key, got, value[0])
+		}
+	}
+}
+
+func TestLastFMErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		temporary bool
+	}{
+		{"ignored", http.StatusOK, `{"scrobbles": {"@attr": {"accepted": 0, "ignored": 1}}}`, false},
+		{"invalid session key", http.StatusForbidden, `{"error": 9, "message": "Invalid session key"}`, false},
+		{"service offline", http.StatusOK, `{"error": 11, "message": "Service Offline"}`, true},
+		{"rate limited", http.StatusTooManyRequests, `{"error": 29, "message": "Rate Limit Exceeded"}`, true},
+		{"server error", http.StatusInternalServerError, "<html>oops</html>", true},
+		{"bad request", http.StatusBadRequest, "<html>no</html>", false},
+		{"invalid response", http.StatusOK, "<html>ok</html>", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := lastFMServer(t, test.status, test.body)
+			lf := NewLastFM(server.URL, "key", "secret", server.Client())
+			err := lf.Scrobble(context.Background(), Account{Token: "SESSION"}, sinnerman)
+			if err == nil || IsTemporary(err) != test.temporary {
+				t.Errorf("got %v, temporary %t, want temporary %t", err, IsTemporary(err), test.temporary)
+			}
+		})
+	}
+}
+
+func TestLastFMAuthenticate(t *testing.T) {
+	server, received := lastFMServer(t, http.StatusOK, `{"session": {"name": "nina", "key": "SESSION", "subscriber": 0}}`)
+	lf := NewLastFM(server.URL, "key", "secret", server.Client())
+	account, err := lf.Authenticate(context.Background(), "TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Token != "SESSION" || account.Username != "nina" {
+		t.Errorf("got %+v", account)
+	}
+	if received.Get("method") != "auth.getSession" || received.Get("token") != "TOKEN" {
+		t.Errorf("posted %v", *received)
+	}
+}
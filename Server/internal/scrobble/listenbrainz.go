@@ -0,0 +1,137 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ListenBrainz submits listens through the ListenBrainz v1 API.
+type ListenBrainz struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewListenBrainz(baseURL string, client *http.Client) *ListenBrainz {
+	return &ListenBrainz{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+func (lb *ListenBrainz) Name() string {
+	return ServiceListenBrainz
+}
+
+type listenBrainzSubmission struct {
+	ListenType string                `json:"listen_type"`
+	Payload    []listenBrainzPayload `json:"payload"`
+}
+
+type listenBrainzPayload struct {
+	ListenedAt    int64                     `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+type listenBrainzTrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo map[string]any `json:"additional_info,omitempty"`
+}
+
+func (lb *ListenBrainz) Authenticate(ctx context.Context, token string) (*Account, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lb.baseURL+"/1/validate-token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+
+	var result struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+		Message  string `json:"message"`
+	}
+	if err := lb.do(req, &result); err != nil {
+		return nil, err
+	}
+	if !result.Valid {
+		return nil, &Error{Service: ServiceListenBrainz, Err: fmt.Errorf("invalid token: %s", result.Message)}
+	}
+
+	return &Account{Token: token, Username: result.UserName}, nil
+}
+
+func (lb *ListenBrainz) NowPlaying(ctx context.Context, account Account, listen Listen) error {
+	return lb.submit(ctx, account, "playing_now", listenBrainzPayload{
+		TrackMetadata: lb.trackMetadata(listen),
+	})
+}
+
+func (lb *ListenBrainz) Scrobble(ctx context.Context, account Account, listen Listen) error {
+	return lb.submit(ctx, account, "single", listenBrainzPayload{
+		ListenedAt:    listen.ListenedAt.Unix(),
+		TrackMetadata: lb.trackMetadata(listen),
+	})
+}
+
+func (lb *ListenBrainz) trackMetadata(listen Listen) listenBrainzTrackMetadata {
+	info := map[string]any{"submission_client": clientName}
+	if listen.Duration > 0 {
+		info["duration_ms"] = listen.Duration * 1000
+	}
+	if listen.TrackNumber > 0 {
+		info["tracknumber"] = listen.TrackNumber
+	}
+	return listenBrainzTrackMetadata{
+		ArtistName:     listen.ArtistName,
+		TrackName:      listen.TrackName,
+		ReleaseName:    listen.ReleaseName,
+		AdditionalInfo: info,
+	}
+}
+
+func (lb *ListenBrainz) submit(ctx context.Context, account Account, listenType string, payload listenBrainzPayload) error {
+	body, err := json.Marshal(listenBrainzSubmission{
+		ListenType: listenType,
+		Payload:    []listenBrainzPayload{payload},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lb.baseURL+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+account.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	return lb.do(req, nil)
+}
+
+func (lb *ListenBrainz) do(req *http.Request, result any) error {
+	resp, err := lb.client.Do(req)
+	if err != nil {
+		return &Error{Service: ServiceListenBrainz, Temporary: true, Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Service: ServiceListenBrainz, Temporary: true, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(ServiceListenBrainz, resp.StatusCode, string(body))
+	}
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return &Error{Service: ServiceListenBrainz, Err: fmt.Errorf("invalid response: %w", err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var sinnerman = Listen{
+	ArtistName:  "Nina Simone",
+	TrackName:   "Sinnerman",
+	ReleaseName: "Pastel Blues",
+	TrackNumber: 9,
+	Duration:    622,
+	ListenedAt:  time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC),
+}
+
+func TestListenBrainzScrobble(t *testing.T) {
+	var submission listenBrainzSubmission
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/1/submit-listens" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization is %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, `{"status": "ok"}`)
+	}))
+	defer server.Close()
+
+	lb := NewListenBrainz(server.URL+"/", server.Client())
+	if err := lb.Scrobble(context.Background(), Account{Token: "secret"}, sinnerman); err != nil {
+		t.Fatal(err)
+	}
+
+	if submission.ListenType != "single" || len(submission.Payload) != 1 {
+		t.Fatalf("submitted %+v", submission)
+	}
+	listen := submission.Payload[0]
+	metadata := listen.TrackMetadata
+	if listen.ListenedAt != sinnerman.ListenedAt.Unix() || metadata.ArtistName != "Nina Simone" ||
+		metadata.TrackName != "Sinnerman" || metadata.ReleaseName != "Pastel Blues" {
+		t.Errorf("submitted %+v", listen)
+	}
+	if metadata.AdditionalInfo["duration_ms"] != float64(622000) || metadata.AdditionalInfo["tracknumber"] != float64(9) {
+		t.Errorf("additional info is %v", metadata.AdditionalInfo)
+	}
+}
+
+func TestListenBrainzErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		temporary bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error": "nope"}`, test.status)
+		}))
+		lb := NewListenBrainz(server.URL, server.Client())
+		err := lb.Scrobble(context.Background(), Account{Token: "secret"}, sinnerman)
+		server.Close()
+
+		if err == nil || IsTemporary(err) != test.temporary {
+			t.Errorf("status %d gave %v, temporary %t", test.status, err, IsTemporary(err))
+		}
+	}
+
+	// Unreachable servers are tried again later
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	lb := NewListenBrainz(server.URL, server.Client())
+	if err := lb.Scrobble(context.Background(), Account{Token: "secret"}, sinnerman); !IsTemporary(err) {
+		t.Errorf("unreachable server gave %v", err)
+	}
+}
+
+func TestListenBrainzAuthenticate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Token good" {
+			io.WriteString(w, `{"valid": true, "user_name": "nina"}`)
+			return
+		}
+		io.WriteString(w, `{"valid": false, "message": "Invalid token"}`)
+	}))
+	defer server.Close()
+	lb := NewListenBrainz(server.URL, server.Client())
+
+	account, err := lb.Authenticate(context.Background(), "good")
+	if err != nil || account.Username != "nina" || account.Token != "good" {
+		t.Errorf("got %+v, %v", account, err)
+	}
+	if _, err := lb.Authenticate(context.Background(), "bad"); err == nil || IsTemporary(err) {
+		t.Errorf("invalid token gave %v", err)
+	}
+}
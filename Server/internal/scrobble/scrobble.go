@@ -0,0 +1,77 @@
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	ServiceListenBrainz = "listenbrainz"
+	ServiceLastFM       = "lastfm"
+
+	clientName = "s3-music-streamer"
+)
+
+// Listen is a single play of a track as sent to a scrobbling service.
+type Listen struct {
+	ArtistName  string
+	TrackName   string
+	ReleaseName string
+	TrackNumber int
+	Duration    int // seconds
+	ListenedAt  time.Time
+}
+
+// Account holds what a service needs to submit on behalf of a user: a
+// ListenBrainz user token or a Last.fm session key.
+type Account struct {
+	Token    string
+	Username string
+}
+
+type Service interface {
+	Name() string
+	// Authenticate checks the credentials a user gave us and returns the
+	// account to store. For Last.fm the token is exchanged for a session key.
+	Authenticate(ctx context.Context, token string) (*Account, error)
+	NowPlaying(ctx context.Context, account Account, listen Listen) error
+	Scrobble(ctx context.Context, account Account, listen Listen) error
+}
+
+// Error is returned by services when a submission fails. Temporary errors
+// (network failures, rate limiting, 5xx responses) are worth retrying;
+// anything else means the submission will never succeed as is.
+type Error struct {
+	Service   string
+	Temporary bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Service, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether err is worth retrying later.
+func IsTemporary(err error) bool {
+	var scrobbleErr *Error
+	if errors.As(err, &scrobbleErr) {
+		return scrobbleErr.Temporary
+	}
+	return true
+}
+
+// statusError classifies an unsuccessful HTTP response.
+func statusError(service string, status int, message string) *Error {
+	return &Error{
+		Service:   service,
+		Temporary: status == http.StatusTooManyRequests || status >= 500,
+		Err:       fmt.Errorf("unexpected status %d: %s", status, message),
+	}
+}
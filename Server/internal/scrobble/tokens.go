@@ -0,0 +1,118 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks tokens stored encrypted, followed by the base64 of
+// the nonce and the sealed token.
+const sealedPrefix = "enc:v1:"
+
+// TokenCipher encrypts the tokens of scrobbling integrations, which let
+// anyone holding them submit as their user, before they are stored. A nil
+// *TokenCipher stores them as given: then anyone who can read the database
+// or its backups can use them.
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+// NewTokenCipher returns a TokenCipher using AES-GCM with key, the base64
+// of 16, 24 or 32 random bytes, or nil if key is empty.
+func NewTokenCipher(key string) (*TokenCipher, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("token key is not base64: %w", err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenCipher{aead: aead}, nil
+}
+
+// Seal returns token as it is to be stored.
+func (c *TokenCipher) Seal(token string) (string, error) {
+	if c == nil {
+		return token, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the token stored as stored, which may have been stored
+// before a key was configured and so not be encrypted.
+func (c *TokenCipher) Open(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.New("token is encrypted, but no key is configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted token is malformed")
+	}
+	nonceSize := c.aead.NonceSize()
+	token, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("token was encrypted with another key")
+	}
+	return string(token), nil
+}
+
+// SealTokens encrypts the tokens stored before a key was configured. It
+// does nothing without a key.
+func (f *Forwarder) SealTokens(ctx context.Context) error {
+	if f.tokens == nil {
+		return nil
+	}
+	rows, err := f.db.QueryContext(ctx, "SELECT id, token FROM scrobble_integrations WHERE token NOT LIKE ?", sealedPrefix+"%")
+	if err != nil {
+		return err
+	}
+	plain := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[id] = token
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, token := range plain {
+		sealed, err := f.tokens.Seal(token)
+		if err != nil {
+			return err
+		}
+		// Unless the token was replaced meanwhile
+		if _, err := f.db.ExecContext(ctx, "UPDATE scrobble_integrations SET token = ? WHERE id = ? AND token = ?", sealed, id, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package scrobble
+
+import (
+	"strings"
+	"testing"
+)
+
+// testKey is a base64 AES-256 key.
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestTokenCipher(t *testing.T) {
+	tokens, err := NewTokenCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := tokens.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "secret") {
+		t.Errorf("sealed as %q", sealed)
+	}
+	if again, _ := tokens.Seal("secret"); again == sealed {
+		t.Error("sealing twice gave the same result")
+	}
+	if token, err := tokens.Open(sealed); err != nil || token != "secret" {
+		t.Errorf("opened %q, %v", token, err)
+	}
+
+	// Tokens stored in plain text are still read
+	if token, err := tokens.Open("plain"); err != nil || token != "plain" {
+		t.Errorf("opened %q, %v", token, err)
+	}
+
+	other, err := NewTokenCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("opened a token sealed with another key")
+	}
+	var none *TokenCipher
+	if _, err := none.Open(sealed); err == nil {
+		t.Error("opened a sealed token without a key")
+	}
+	if _, err := tokens.Open(sealedPrefix + "!!"); err == nil {
+		t.Error("opened a malformed token")
+	}
+}
+
+func TestTokenCipherWithoutKey(t *testing.T) {
+	tokens, err := NewTokenCipher("")
+	if err != nil || tokens != nil {
+		t.Fatalf("got %v, %v, want no cipher", tokens, err)
+	}
+	if sealed, err := tokens.Seal("secret"); err != nil || sealed != "secret" {
+		t.Errorf("sealed as %q, %v", sealed, err)
+	}
+}
+
+func TestNewTokenCipherInvalidKey(t *testing.T) {
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := NewTokenCipher(key); err == nil {
+			t.Errorf("key %q was accepted", key)
+		}
+	}
+}
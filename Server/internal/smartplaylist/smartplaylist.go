@@ -0,0 +1,321 @@
+// Package smartplaylist compiles smart playlist rules into SQL.
+//
+// Rules are JSON documents such as
+//
+//	{
+//	  "all": [
+//	    {"field": "rating", "op": "gte", "value": 4},
+//	    {"field": "added", "op": "in_last", "value": 30},
+//	    {"any": [
+//	      {"field": "last_played", "op": "not_in_last", "value": 60},
+//	      {"field": "play_count", "op": "lt", "value": 3}
+//	    ]}
+//	  ],
+//	  "sort": "random",
+//	  "limit": 50
+//	}
+//
+// Field names and operators are looked up in fixed tables and every value is
+// passed as a query parameter, so rules can never inject SQL.
+package smartplaylist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	MaxLimit = 1000
+	maxDepth = 8
+)
+
+// Condition is either a group of conditions that must all (All) or any (Any)
+// hold, or a single comparison of Field against Value.
+type Condition struct {
+	All   []Condition     `json:"all,omitempty"`
+	Any   []Condition     `json:"any,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Definition is a complete smart playlist: which songs match, how they are
+// ordered and how many are kept.
+type Definition struct {
+	Condition
+	Sort  string `json:"sort,omitempty"`  // a field name or "random"
+	Order string `json:"order,omitempty"` // "asc" (default) or "desc"
+	Limit int    `json:"limit,omitempty"`
+}
+
+type fieldType int
+
+const (
+	stringField fieldType = iota
+	numberField
+	boolField
+	dateField
+)
+
+type field struct {
+	expr string
+	typ  fieldType
+}
+
+// fields maps rule field names to SQL expressions. The compiled query must
+// select from songs s joined with artists ar, albums al, the user's
+// annotations an and their per-song play aggregates ps (play_count,
+// last_played).
+var fields = map[string]field{
+	"title":        {"s.title", stringField},
+	"artist":       {"ar.name", stringField},
+	"album":        {"al.title", stringField},
+	"disc_number":  {"COALESCE(s.disc_number, 1)", numberField},
+	"track_number": {"s.track_number", numberField},
+	"duration":     {"s.duration", numberField},
+	"year":         {"al.year", numberField},
+	"rating":       {"COALESCE(an.rating, 0)", numberField},
+	"starred":      {"COALESCE(an.starred, 0)", boolField},
+	"play_count":   {"COALESCE(ps.play_count, 0)", numberField},
+	"last_played":  {"ps.last_played", dateField},
+	"added":        {"s.created_at", dateField},
+}
+
+var (
+	comparisons = map[string]string{
+		"is":     "=",
+		"is_not": "<>",
+		"gt":     ">",
+		"gte":    ">=",
+		"lt":     "<",
+		"lte":    "<=",
+		"before": "<",
+		"after":  ">",
+	}
+	likePatterns = map[string]string{
+		"contains":     "%%%s%%",
+		"not_contains": "%%%s%%",
+		"starts_with":  "%s%%",
+		"ends_with":    "%%%s",
+	}
+)
+
+// Compiled is a Definition translated to SQL fragments.
+type Compiled struct {
+	Where   string // condition without the WHERE keyword, "1 = 1" when empty
+	OrderBy string // ORDER BY clause without the keywords
+	Limit   int    // 0 means no limit
+	Args    []any  // parameters for Where, in order
+}
+
+// Parse decodes and validates a rules document.
+func Parse(data []byte) (*Definition, error) {
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	if _, err := def.Compile(time.Now()); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// Compile translates the definition to SQL. Relative dates such as
+// "in_last 30 days" are resolved against now.
+func (d *Definition) Compile(now time.Time) (*Compiled, error) {
+	c := &compiler{now: now.UTC()}
+
+	where, err := c.condition(d.Condition, 0)
+	if err != nil {
+		return nil, err
+	}
+	if where == "" {
+		where = "1 = 1"
+	}
+
+	orderBy := "s.title ASC"
+	if d.Sort == "random" {
+		orderBy = "RANDOM()"
+	} else if d.Sort != "" {
+		f, ok := fields[d.Sort]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", d.Sort)
+		}
+		direction := "ASC"
+		switch strings.ToLower(d.Order) {
+		case "", "asc":
+		case "desc":
+			direction = "DESC"
+		default:
+			return nil, fmt.Errorf("order must be asc or desc")
+		}
+		// Songs of the same album stay in disc and track order
+		orderBy = f.expr + " " + direction + ", COALESCE(s.disc_number, 1) ASC, s.track_number ASC, s.id ASC"
+	}
+
+	if d.Limit < 0 || d.Limit > MaxLimit {
+		return nil, fmt.Errorf("limit must be between 0 and %d", MaxLimit)
+	}
+
+	return &Compiled{Where: where, OrderBy: orderBy, Limit: d.Limit, Args: c.args}, nil
+}
+
+type compiler struct {
+	now  time.Time
+	args []any
+}
+
+func (c *compiler) condition(cond Condition, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", errors.New("rules are nested too deeply")
+	}
+
+	isGroup := cond.All != nil || cond.Any != nil
+	if isGroup && cond.Field != "" {
+		return "", errors.New("a condition is either a group or a comparison, not both")
+	}
+	if cond.All != nil && cond.Any != nil {
+		return "", errors.New("a group has either all or any conditions, not both")
+	}
+
+	switch {
+	case cond.All != nil:
+		return c.group(cond.All, " AND ", depth)
+	case cond.Any != nil:
+		return c.group(cond.Any, " OR ", depth)
+	case cond.Field != "":
+		return c.comparison(cond)
+	case depth == 0:
+		return "", nil // no conditions: every song matches
+	default:
+		return "", errors.New("empty condition")
+	}
+}
+
+func (c *compiler) group(conds []Condition, join string, depth int) (string, error) {
+	if len(conds) == 0 {
+		return "", errors.New("empty condition group")
+	}
+	parts := make([]string, len(conds))
+	for i, cond := range conds {
+		part, err := c.condition(cond, depth+1)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = part
+	}
+	return "(" + strings.Join(parts, join) + ")", nil
+}
+
+func (c *compiler) comparison(cond Condition) (string, error) {
+	f, ok := fields[cond.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown field %q", cond.Field)
+	}
+	invalid := func() (string, error) {
+		return "", fmt.Errorf("operator %q is not valid for field %q", cond.Op, cond.Field)
+	}
+
+	switch f.typ {
+	case stringField:
+		var value string
+		if err := c.value(cond, &value); err != nil {
+			return "", err
+		}
+		if pattern, ok := likePatterns[cond.Op]; ok {
+			c.args = append(c.args, fmt.Sprintf(pattern, escapeLike(value)))
+			not := ""
+			if strings.HasPrefix(cond.Op, "not_") {
+				not = "NOT "
+			}
+			// LIKE is case-sensitive in PostgreSQL, not in SQLite
+			return fmt.Sprintf("LOWER(COALESCE(%s, '')) %sLIKE LOWER(?) ESCAPE '\\'", f.expr, not), nil
+		}
+		if cond.Op != "is" && cond.Op != "is_not" {
+			return invalid()
+		}
+		c.args = append(c.args, value)
+		return fmt.Sprintf("COALESCE(%s, '') %s ?", f.expr, comparisons[cond.Op]), nil
+
+	case numberField:
+		if cond.Op == "in_range" {
+			var bounds [2]float64
+			if err := c.value(cond, &bounds); err != nil {
+				return "", err
+			}
+			c.args = append(c.args, bounds[0], bounds[1])
+			return fmt.Sprintf("%s BETWEEN ? AND ?", f.expr), nil
+		}
+		op, ok := comparisons[cond.Op]
+		if !ok || cond.Op == "before" || cond.Op == "after" {
+			return invalid()
+		}
+		var value float64
+		if err := c.value(cond, &value); err != nil {
+			return "", err
+		}
+		c.args = append(c.args, value)
+		return fmt.Sprintf("%s %s ?", f.expr, op), nil
+
+	case boolField:
+		if cond.Op != "is" {
+			return invalid()
+		}
+		var value bool
+		if err := c.value(cond, &value); err != nil {
+			return "", err
+		}
+		if value {
+			return fmt.Sprintf("%s = 1", f.expr), nil
+		}
+		return fmt.Sprintf("%s = 0", f.expr), nil
+
+	case dateField:
+		switch cond.Op {
+		case "in_last", "not_in_last":
+			var days int
+			if err := c.value(cond, &days); err != nil {
+				return "", err
+			}
+			if days <= 0 {
+				return "", fmt.Errorf("%s for field %q needs a positive number of days", cond.Op, cond.Field)
+			}
+			c.args = append(c.args, c.now.AddDate(0, 0, -days))
+			if cond.Op == "in_last" {
+				return fmt.Sprintf("%s >= ?", f.expr), nil
+			}
+			// Never played counts as not played recently
+			return fmt.Sprintf("(%s IS NULL OR %s < ?)", f.expr, f.expr), nil
+		case "before", "after":
+			var value string
+			if err := c.value(cond, &value); err != nil {
+				return "", err
+			}
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return "", fmt.Errorf("value for field %q must be a YYYY-MM-DD date", cond.Field)
+			}
+			c.args = append(c.args, date)
+			return fmt.Sprintf("%s %s ?", f.expr, comparisons[cond.Op]), nil
+		}
+		return invalid()
+	}
+	return invalid()
+}
+
+func (c *compiler) value(cond Condition, dest any) error {
+	if len(cond.Value) == 0 {
+		return fmt.Errorf("missing value for field %q", cond.Field)
+	}
+	if err := json.Unmarshal(cond.Value, dest); err != nil {
+		return fmt.Errorf("invalid value for field %q", cond.Field)
+	}
+	return nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
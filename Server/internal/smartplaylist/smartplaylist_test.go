@@ -0,0 +1,276 @@
+package smartplaylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+
+// render substitutes args for the placeholders of where, in order, failing
+// if their numbers differ.
+func render(t *testing.T, where string, args []any) string {
+	t.Helper()
+	if n := strings.Count(where, "?"); n != len(args) {
+		t.Fatalf("%s has %d placeholders for %d args", where, n, len(args))
+	}
+	for _, arg := range args {
+		var value string
+		switch arg := arg.(type) {
+		case string:
+			value = "'" + arg + "'"
+		case time.Time:
+			value = arg.Format(time.DateOnly)
+		default:
+			value = fmt.Sprint(arg)
+		}
+		where = strings.Replace(where, "?", value, 1)
+	}
+	return where
+}
+
+func compile(t *testing.T, rules string) (*Compiled, error) {
+	t.Helper()
+	var def Definition
+	if err := json.Unmarshal([]byte(rules), &def); err != nil {
+		t.Fatal(err)
+	}
+	return def.Compile(now)
+}
+
+// operators holds, for each field type, its valid operators and a value
+// for each.
+var operators = map[fieldType]map[string]string{
+	stringField: {
+		"is": `"Nina"`, "is_not": `"Nina"`, "contains": `"Nina"`, "not_contains": `"Nina"`,
+		"starts_with": `"Nina"`, "ends_with": `"Nina"`,
+	},
+	numberField: {
+		"is": "3", "is_not": "3", "gt": "3", "gte": "3", "lt": "3", "lte": "3", "in_range": "[1, 3]",
+	},
+	boolField: {"is": "true"},
+	dateField: {"in_last": "30", "not_in_last": "30", "before": `"2024-01-01"`, "after": `"2024-01-01"`},
+}
+
+func TestFieldOperators(t *testing.T) {
+	allOps := map[string]bool{"in_the_key_of": true}
+	for _, ops := range operators {
+		for op := range ops {
+			allOps[op] = true
+		}
+	}
+
+	for name, f := range fields {
+		for op := range allOps {
+			value, valid := operators[f.typ][op]
+			if !valid {
+				value = "1"
+			}
+			rules := fmt.Sprintf(`{"field": %q, "op": %q, "value": %s}`, name, op, value)
+			compiled, err := compile(t, rules)
+			if !valid {
+				if err == nil {
+					t.Errorf("%s was accepted, as %s", rules, compiled.Where)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %v", rules, err)
+				continue
+			}
+			if !strings.Contains(compiled.Where, f.expr) {
+				t.Errorf("%s compiled to %s, which doesn't compare %s", rules, compiled.Where, f.expr)
+			}
+			render(t, compiled.Where, compiled.Args)
+		}
+	}
+
+	if _, err := compile(t, `{"field": "mood", "op": "is", "value": "sad"}`); err == nil {
+		t.Error("an unknown field was accepted")
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  string
+	}{
+		{`{"field": "title", "op": "is", "value": "Sinnerman"}`, "COALESCE(s.title, '') = 'Sinnerman'"},
+		{`{"field": "artist", "op": "is_not", "value": "Nina"}`, "COALESCE(ar.name, '') <> 'Nina'"},
+		{`{"field": "album", "op": "contains", "value": "Blue"}`, `LOWER(COALESCE(al.title, '')) LIKE LOWER('%Blue%') ESCAPE '\'`},
+		{`{"field": "album", "op": "not_contains", "value": "Blue"}`, `LOWER(COALESCE(al.title, '')) NOT LIKE LOWER('%Blue%') ESCAPE '\'`},
+		{`{"field": "title", "op": "starts_with", "value": "Sin"}`, `LOWER(COALESCE(s.title, '')) LIKE LOWER('Sin%') ESCAPE '\'`},
+		{`{"field": "title", "op": "ends_with", "value": "man"}`, `LOWER(COALESCE(s.title, '')) LIKE LOWER('%man') ESCAPE '\'`},
+		{`{"field": "rating", "op": "gte", "value": 4}`, "COALESCE(an.rating, 0) >= 4"},
+		{`{"field": "duration", "op": "lt", "value": 180.5}`, "s.duration < 180.5"},
+		{`{"field": "year", "op": "in_range", "value": [1960, 1969]}`, "al.year BETWEEN 1960 AND 1969"},
+		{`{"field": "starred", "op": "is", "value": true}`, "COALESCE(an.starred, 0) = 1"},
+		{`{"field": "starred", "op": "is", "value": false}`, "COALESCE(an.starred, 0) = 0"},
+		{`{"field": "added", "op": "in_last", "value": 30}`, "s.created_at >= 2024-04-01"},
+		{`{"field": "last_played", "op": "not_in_last", "value": 7}`, "(ps.last_played IS NULL OR ps.last_played < 2024-04-24)"},
+		{`{"field": "added", "op": "before", "value": "2024-01-01"}`, "s.created_at < 2024-01-01"},
+		{`{"field": "last_played", "op": "after", "value": "2023-12-31"}`, "ps.last_played > 2023-12-31"},
+		{`{}`, "1 = 1"},
+	}
+	for _, test := range tests {
+		compiled, err := compile(t, test.rules)
+		if err != nil {
+			t.Errorf("%s: %v", test.rules, err)
+			continue
+		}
+		if got := render(t, compiled.Where, compiled.Args); got != test.want {
+			t.Errorf("%s compiled to\n\t%s\nwant\n\t%s", test.rules, got, test.want)
+		}
+	}
+}
+
+func TestInvalidValues(t *testing.T) {
+	tests := []string{
+		`{"field": "title", "op": "is"}`,
+		`{"field": "title", "op": "is", "value": 3}`,
+		`{"field": "rating", "op": "gte", "value": "four"}`,
+		`{"field": "year", "op": "in_range", "value": 1960}`,
+		`{"field": "starred", "op": "is", "value": "yes"}`,
+		`{"field": "added", "op": "in_last", "value": 0}`,
+		`{"field": "added", "op": "in_last", "value": -3}`,
+		`{"field": "added", "op": "before", "value": "last week"}`,
+		`{"field": "title", "op": "is", "value": "x", "all": [{"field": "rating", "op": "gte", "value": 4}]}`,
+		`{"all": [{"field": "rating", "op": "gte", "value": 4}], "any": [{"field": "rating", "op": "lt", "value": 2}]}`,
+		`{"all": []}`,
+		`{"any": [{}]}`,
+	}
+	for _, rules := range tests {
+		if compiled, err := compile(t, rules); err == nil {
+			t.Errorf("%s was accepted, as %s", rules, compiled.Where)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Sinnerman", "Sinnerman"},
+		{"100%", `100\%`},
+		{"track_1", `track\_1`},
+		{`AC\DC`, `AC\\DC`},
+		{`%_\`, `\%\_\\`},
+	}
+	for _, test := range tests {
+		if got := escapeLike(test.in); got != test.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	compiled, err := compile(t, `{"field": "title", "op": "contains", "value": "50%_off"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compiled.Args) != 1 || compiled.Args[0] != `%50\%\_off%` {
+		t.Errorf("contains 50%%_off binds %q", compiled.Args)
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	nest := func(depth int) string {
+		rules := `{"field": "rating", "op": "gte", "value": 4}`
+		for range depth {
+			rules = `{"any": [` + rules + `]}`
+		}
+		return rules
+	}
+
+	compiled, err := compile(t, nest(maxDepth))
+	if err != nil {
+		t.Fatalf("rules nested %d deep: %v", maxDepth, err)
+	}
+	want := strings.Repeat("(", maxDepth) + "COALESCE(an.rating, 0) >= 4" + strings.Repeat(")", maxDepth)
+	if got := render(t, compiled.Where, compiled.Args); got != want {
+		t.Errorf("compiled to %s", got)
+	}
+	if _, err := compile(t, nest(maxDepth+1)); err == nil {
+		t.Errorf("rules nested %d deep were accepted", maxDepth+1)
+	}
+}
+
+func TestSortAndLimit(t *testing.T) {
+	const tracks = ", COALESCE(s.disc_number, 1) ASC, s.track_number ASC, s.id ASC"
+	tests := []struct {
+		rules   string
+		orderBy string
+		limit   int
+	}{
+		{`{}`, "s.title ASC", 0},
+		{`{"sort": "random", "limit": 50}`, "RANDOM()", 50},
+		{`{"sort": "play_count", "order": "desc"}`, "COALESCE(ps.play_count, 0) DESC" + tracks, 0},
+		{`{"sort": "added", "order": "ASC"}`, "s.created_at ASC" + tracks, 0},
+		{`{"sort": "year", "limit": 1000}`, "al.year ASC" + tracks, MaxLimit},
+	}
+	for _, test := range tests {
+		compiled, err := compile(t, test.rules)
+		if err != nil {
+			t.Errorf("%s: %v", test.rules, err)
+			continue
+		}
+		if compiled.OrderBy != test.orderBy || compiled.Limit != test.limit {
+			t.Errorf("%s orders by %s, limited to %d", test.rules, compiled.OrderBy, compiled.Limit)
+		}
+	}
+
+	for _, rules := range []string{
+		`{"limit": -1}`,
+		`{"limit": 1001}`,
+		`{"sort": "mood"}`,
+		`{"sort": "s.id; DROP TABLE songs"}`,
+		`{"sort": "title", "order": "sideways"}`,
+	} {
+		if _, err := compile(t, rules); err == nil {
+			t.Errorf("%s was accepted", rules)
+		}
+	}
+}
+
+func TestArgsOrder(t *testing.T) {
+	// Arguments are bound in the order their placeholders appear, after
+	// those of the query around the condition
+	compiled, err := compile(t, `{
+		"all": [
+			{"field": "title", "op": "starts_with", "value": "S"},
+			{"any": [
+				{"field": "rating", "op": "in_range", "value": [3, 5]},
+				{"field": "added", "op": "in_last", "value": 1}
+			]},
+			{"field": "artist", "op": "is", "value": "Nina"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "SELECT ... WHERE an.user_id = ? ... WHERE p.user_id = ? ... WHERE " + compiled.Where
+	args := append([]any{"hal", "hal"}, compiled.Args...)
+	want := "SELECT ... WHERE an.user_id = 'hal' ... WHERE p.user_id = 'hal' ... WHERE " +
+		`(LOWER(COALESCE(s.title, '')) LIKE LOWER('S%') ESCAPE '\'` +
+		" AND (COALESCE(an.rating, 0) BETWEEN 3 AND 5 OR s.created_at >= 2024-04-30)" +
+		" AND COALESCE(ar.name, '') = 'Nina')"
+	if got := render(t, query, args); got != want {
+		t.Errorf("bound as\n\t%s\nwant\n\t%s", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	def, err := Parse([]byte(`{"all": [{"field": "rating", "op": "gte", "value": 4}], "sort": "random", "limit": 50}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def.All) != 1 || def.Sort != "random" || def.Limit != 50 {
+		t.Errorf("parsed %+v", def)
+	}
+	for _, rules := range []string{`[]`, `{"all": "rating"}`, `{"field": "rating", "op": "near", "value": 4}`} {
+		if _, err := Parse([]byte(rules)); err == nil {
+			t.Errorf("%s was parsed", rules)
+		}
+	}
+}
@@ -2,15 +2,22 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
 type S3Client struct {
 	client *s3.Client
 	bucket string
@@ -53,6 +60,64 @@ func (s *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, er
 	return result.Body, nil
 }
 
+// Object is an object's body along with the metadata S3 returned for it.
+type Object struct {
+	Body          io.ReadCloser
+	ContentType   string
+	ContentLength int64
+	ETag          string
+	LastModified  time.Time
+}
+
+// OpenObject is like GetObject but also returns the object's metadata. It
+// returns ErrNotFound if there is no object at key.
+func (s *S3Client) OpenObject(ctx context.Context, key string) (*Object, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+
+	return &Object{
+		Body:          result.Body,
+		ContentType:   aws.ToString(result.ContentType),
+		ContentLength: aws.ToInt64(result.ContentLength),
+		ETag:          aws.ToString(result.ETag),
+		LastModified:  aws.ToTime(result.LastModified),
+	}, nil
+}
+
+// HeadObject returns an object's metadata without its body, which is nil. It
+// returns ErrNotFound if there is no object at key.
+func (s *S3Client) HeadObject(ctx context.Context, key string) (*Object, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object metadata from S3: %w", err)
+	}
+
+	return &Object{
+		ContentType:   aws.ToString(result.ContentType),
+		ContentLength: aws.ToInt64(result.ContentLength),
+		ETag:          aws.ToString(result.ETag),
+		LastModified:  aws.ToTime(result.LastModified),
+	}, nil
+}
+
 func (s *S3Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
 	input := &s3.PutObjectInput{
 		Bucket:      aws.String(s.bucket),
@@ -82,3 +147,77 @@ func (s *S3Client) DeleteObject(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// ObjectInfo describes an object listed by ListObjects.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects lists the objects whose key starts with prefix, in order of
+// key.
+func (s *S3Client) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// DeletePrefix deletes every object whose key starts with prefix.
+func (s *S3Client) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, len(page.Contents))
+		for i, object := range page.Contents {
+			objects[i] = types.ObjectIdentifier{Key: object.Key}
+		}
+		_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects from S3: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound")
+}
@@ -0,0 +1,226 @@
+// Package tags reads metadata embedded in audio files (ID3, MP4, FLAC and
+// Ogg Vorbis comments).
+package tags
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"s3-music-streamer/internal/lyrics"
+
+	"github.com/dhowden/tag"
+)
+
+// ErrNoTags is returned by Read when a file carries no metadata.
+var ErrNoTags = errors.New("no tags found")
+
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+type Metadata struct {
+	Title        string
+	Artist       string
+	AlbumArtist  string
+	Album        string
+	Year         int
+	Track        int
+	TrackTotal   int // Tracks on the disc
+	Disc         int
+	DiscTotal    int
+	DiscSubtitle string
+	Genres       []string // Split from multi-valued genre tags, e.g. "Rock; Pop"
+	Compilation  bool     // iTunes compilation flag (TCMP, cpil or COMPILATION)
+	Picture      *Picture // embedded APIC frame, MP4 covr atom or FLAC PICTURE block
+
+	// Lyrics holds LRC text when the file has synced lyrics (a SYLT frame),
+	// and otherwise the unsynced lyrics, which may be LRC text themselves.
+	Lyrics         string
+	LyricsLanguage string // ISO 639-2 code from ID3 frames, e.g. "eng"
+}
+
+// Read parses the tags of the file in r. The read position of r is left
+// undefined, so callers that go on to use r must seek back to the start.
+func Read(r io.ReadSeeker) (*Metadata, error) {
+	m, err := tag.ReadFrom(r)
+	if errors.Is(err, tag.ErrNoTagsFound) {
+		return nil, ErrNoTags
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+
+	track, trackTotal := m.Track()
+	disc, discTotal := m.Disc()
+	raw := m.Raw()
+	metadata := &Metadata{
+		Title:        m.Title(),
+		Artist:       m.Artist(),
+		AlbumArtist:  m.AlbumArtist(),
+		Album:        m.Album(),
+		Year:         m.Year(),
+		Track:        track,
+		TrackTotal:   trackTotal,
+		Disc:         disc,
+		DiscTotal:    discTotal,
+		DiscSubtitle: rawString(raw, "TSST", "discsubtitle"),
+		Genres:       splitGenres(m.Genre()),
+		Compilation:  isCompilation(raw),
+	}
+	if picture := m.Picture(); picture != nil && len(picture.Data) > 0 {
+		metadata.Picture = &Picture{MIMEType: picture.MIMEType, Data: picture.Data}
+	}
+
+	if synced, ok := raw["SYLT"].([]byte); ok {
+		if lines, language := parseSYLT(synced); len(lines) > 0 {
+			metadata.Lyrics = lyrics.Format(lines)
+			metadata.LyricsLanguage = language
+		}
+	}
+	if metadata.Lyrics == "" {
+		metadata.Lyrics = strings.TrimSpace(m.Lyrics())
+		for _, key := range []string{"USLT", "ULT"} {
+			if comm, ok := raw[key].(*tag.Comm); ok {
+				metadata.LyricsLanguage = comm.Language
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// parseSYLT reads the lines of an ID3v2 synchronised lyrics frame, which
+// dhowden/tag leaves unparsed, and their language. Only frames timed in
+// milliseconds are supported; the other format counts MPEG frames.
+//
+//	Text encoding    $xx
+//	Language         $xx xx xx
+//	Time stamp format $xx
+//	Content type     $xx
+//	Content descriptor <text> $00 (00)
+//	Then, repeatedly: <text> $00 (00) and a 32 bit time stamp
+func parseSYLT(b []byte) ([]lyrics.Line, string) {
+	const millisecondTimestamps = 2
+	if len(b) < 6 || b[4] != millisecondTimestamps {
+		return nil, ""
+	}
+	encoding, language := b[0], strings.TrimRight(string(b[1:4]), "\x00")
+
+	_, rest := readSYLTText(encoding, b[6:]) // content descriptor
+	var lines []lyrics.Line
+	for len(rest) > 0 {
+		var text string
+		text, rest = readSYLTText(encoding, rest)
+		if len(rest) < 4 {
+			break
+		}
+		ms := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		lines = append(lines, lyrics.Line{
+			Time: time.Duration(ms) * time.Millisecond,
+			Text: strings.TrimSpace(text),
+		})
+	}
+	return lines, language
+}
+
+// readSYLTText reads a NUL-terminated string in an ID3v2 text encoding and
+// returns it with the bytes following it.
+func readSYLTText(encoding byte, b []byte) (string, []byte) {
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		end := len(b) &^ 1
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		data, rest := b[:end], b[min(end+2, len(b)):]
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(data) >= 2 {
+			if data[0] == 0xff && data[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			if (data[0] == 0xff && data[1] == 0xfe) || (data[0] == 0xfe && data[1] == 0xff) {
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return string(utf16.Decode(units)), rest
+
+	default:
+		end := len(b)
+		for i, c := range b {
+			if c == 0 {
+				end = i
+				break
+			}
+		}
+		data, rest := b[:end], b[min(end+1, len(b)):]
+		if encoding == 0 {
+			// ISO-8859-1 bytes are the first 256 code points
+			runes := make([]rune, len(data))
+			for i, c := range data {
+				runes[i] = rune(c)
+			}
+			return string(runes), rest
+		}
+		return string(data), rest
+	}
+}
+
+// isCompilation reads the compilation flag, which dhowden/tag only exposes
+// through the raw tag values.
+func isCompilation(raw map[string]any) bool {
+	for _, key := range []string{"TCMP", "TCP", "cpil", "compilation"} {
+		switch value := raw[key].(type) {
+		case string:
+			if strings.TrimSpace(value) == "1" {
+				return true
+			}
+		case int:
+			if value == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitGenres splits a genre tag holding several values. ID3v2.4 separates
+// them with NUL bytes, while taggers commonly use ";" elsewhere. "/" is left
+// alone, as it appears in genre names such as "Hip-Hop/Rap".
+func splitGenres(genre string) []string {
+	var genres []string
+	seen := make(map[string]bool)
+	for _, name := range strings.FieldsFunc(genre, func(r rune) bool {
+		return r == 0 || r == ';'
+	}) {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		genres = append(genres, name)
+	}
+	return genres
+}
+
+// rawString returns the first non-empty text value among keys.
+func rawString(raw map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := raw[key].(string); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
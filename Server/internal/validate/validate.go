@@ -0,0 +1,254 @@
+// Package validate checks request payloads against rules declared in the
+// validate tags of their fields, reporting every field that fails.
+//
+// Rules are separated by commas:
+//
+//	required      not the zero value, nor a blank string
+//	min=N, max=N  bounds of numbers, or of the length of strings, in
+//	              characters, and of slices
+//	oneof=a b c   one of the values separated by spaces
+//	url           an absolute http or https URL
+//	exists=table  the id of a row of table; for slices, of every element
+//
+// Rules other than required pass zero values and nil pointers, so that
+// optional fields are only checked when set. Fields of nested structs and
+// slices of structs are checked too. Fields are named after their JSON
+// keys, as in "artists[1].role".
+package validate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError is a rule that a field failed.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"` // e.g. "is required"
+}
+
+// Errors are the fields of a payload that failed their rules.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Field + " " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Field returns Errors for one field, for checks made outside of tags such
+// as those comparing fields.
+func Field(field, format string, args ...any) Errors {
+	return Errors{{Field: field, Message: fmt.Sprintf(format, args...)}}
+}
+
+// ExistsFunc reports whether table has a row with id.
+type ExistsFunc func(ctx context.Context, table string, id int64) (bool, error)
+
+// Validator checks structs against their validate tags, looking up the ids
+// of exists rules with its ExistsFunc.
+type Validator struct {
+	exists ExistsFunc
+}
+
+func New(exists ExistsFunc) *Validator {
+	return &Validator{exists: exists}
+}
+
+// Struct checks v, a struct or a pointer to one. It returns Errors listing
+// every rule that failed, or another error if looking up an id failed.
+func (v *Validator) Struct(ctx context.Context, s any) error {
+	var errs Errors
+	if err := v.walk(ctx, reflect.ValueOf(s), "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (v *Validator) walk(ctx context.Context, value reflect.Value, path string, errs *Errors) error {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch {
+	case value.Kind() == reflect.Struct && value.Type() != timeType:
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			if tag := field.Tag.Get("validate"); tag != "" {
+				message, err := v.check(ctx, value.Field(i), tag)
+				if err != nil {
+					return err
+				}
+				if message != "" {
+					*errs = append(*errs, FieldError{Field: fieldPath, Message: message})
+					continue
+				}
+			}
+			if err := v.walk(ctx, value.Field(i), fieldPath, errs); err != nil {
+				return err
+			}
+		}
+	case value.Kind() == reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := v.walk(ctx, value.Index(i), fmt.Sprintf("%s[%d]", path, i), errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonName returns the JSON key of an exported field, if it has one.
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// check applies the rules of tag to value, returning how the first that
+// failed did, if one did.
+func (v *Validator) check(ctx context.Context, value reflect.Value, tag string) (string, error) {
+	rules := strings.Split(tag, ",")
+	if isZero(value) {
+		if slices.Contains(rules, "required") {
+			return "is required", nil
+		}
+		return "", nil
+	}
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		var message string
+		switch name {
+		case "required":
+		case "min", "max":
+			message = checkBound(value, name, mustInt(rule, arg))
+		case "oneof":
+			if options := strings.Fields(arg); !slices.Contains(options, value.String()) {
+				message = "must be one of " + strings.Join(options, ", ")
+			}
+		case "url":
+			if u, err := url.Parse(value.String()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				message = "must be an http or https URL"
+			}
+		case "exists":
+			var err error
+			if message, err = v.checkExists(ctx, value, arg); err != nil {
+				return "", err
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q", rule))
+		}
+		if message != "" {
+			return message, nil
+		}
+	}
+	return "", nil
+}
+
+// isZero reports whether value is unset: a nil pointer, a blank string, or
+// any other zero value.
+func isZero(value reflect.Value) bool {
+	if value.Kind() == reflect.String {
+		return strings.TrimSpace(value.String()) == ""
+	}
+	if value.Kind() == reflect.Slice {
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func checkBound(value reflect.Value, rule string, bound int64) string {
+	var n int64
+	var unit string
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int64(value.Uint())
+	case reflect.String:
+		n, unit = int64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice:
+		n, unit = int64(value.Len()), " items"
+	default:
+		panic(fmt.Sprintf("validate: %s on %s", rule, value.Type()))
+	}
+
+	if rule == "min" && n < bound {
+		return fmt.Sprintf("must be at least %d%s", bound, unit)
+	}
+	if rule == "max" && n > bound {
+		return fmt.Sprintf("must be at most %d%s", bound, unit)
+	}
+	return ""
+}
+
+func (v *Validator) checkExists(ctx context.Context, value reflect.Value, table string) (string, error) {
+	var ids []int64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		ids = []int64{value.Int()}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			ids = append(ids, value.Index(i).Int())
+		}
+	default:
+		panic(fmt.Sprintf("validate: exists on %s", value.Type()))
+	}
+
+	for _, id := range ids {
+		found, err := v.exists(ctx, table, id)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return fmt.Sprintf("refers to %d, which doesn't exist", id), nil
+		}
+	}
+	return "", nil
+}
+
+func mustInt(rule, arg string) int64 {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: invalid rule %q", rule))
+	}
+	return n
+}
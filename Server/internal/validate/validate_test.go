@@ -0,0 +1,117 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type link struct {
+	URL  string `json:"url" validate:"required,url"`
+	Role string `json:"role" validate:"oneof=main featured"`
+}
+
+type payload struct {
+	Name     string  `json:"name" validate:"required,max=5"`
+	Bio      string  `json:"bio,omitempty" validate:"min=2"`
+	Year     *int    `json:"year" validate:"min=1000,max=2100"`
+	Count    int     `json:"count" validate:"max=3"`
+	ArtistID *int64  `json:"artist_id" validate:"exists=artists"`
+	SongIDs  []int64 `json:"song_ids" validate:"max=3,exists=songs"`
+	Links    []link  `json:"links"`
+	Ignored  string  `json:"-" validate:"required"`
+	internal string
+}
+
+// rows are the ids in each table, for the exists rules.
+var rows = map[string][]int64{"artists": {1}, "songs": {1, 2}}
+
+func exists(ctx context.Context, table string, id int64) (bool, error) {
+	for _, row := range rows[table] {
+		if row == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func intPtr(n int) *int       { return &n }
+func int64Ptr(n int64) *int64 { return &n }
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload payload
+		want    Errors
+	}{
+		{"valid", payload{Name: "Nina", Year: intPtr(1965), ArtistID: int64Ptr(1), SongIDs: []int64{1, 2}}, nil},
+		{"only the required field", payload{Name: "Nina"}, nil},
+		{"required missing", payload{}, Errors{{"name", "is required"}}},
+		{"required blank", payload{Name: "  \t"}, Errors{{"name", "is required"}}},
+		{"too long", payload{Name: "Simone"}, Errors{{"name", "must be at most 5 characters"}}},
+		{"length in characters", payload{Name: "Björk"}, nil},
+		{"too short", payload{Name: "Nina", Bio: "x"}, Errors{{"bio", "must be at least 2 characters"}}},
+		{"year too early", payload{Name: "Nina", Year: intPtr(999)}, Errors{{"year", "must be at least 1000"}}},
+		{"year too late", payload{Name: "Nina", Year: intPtr(2101)}, Errors{{"year", "must be at most 2100"}}},
+		{"year at the bounds", payload{Name: "Nina", Year: intPtr(2100)}, nil},
+		{"number too large", payload{Name: "Nina", Count: 4}, Errors{{"count", "must be at most 3"}}},
+		{"missing id", payload{Name: "Nina", ArtistID: int64Ptr(7)}, Errors{{"artist_id", "refers to 7, which doesn't exist"}}},
+		{"missing id in a slice", payload{Name: "Nina", SongIDs: []int64{1, 9}}, Errors{{"song_ids", "refers to 9, which doesn't exist"}}},
+		{"too many items", payload{Name: "Nina", SongIDs: []int64{1, 1, 2, 2}}, Errors{{"song_ids", "must be at most 3 items"}}},
+		{
+			"nested structs",
+			payload{Name: "Nina", Links: []link{{URL: "https://nina.example", Role: "main"}, {URL: "ftp://x", Role: "guest"}, {}}},
+			Errors{
+				{"links[1].url", "must be an http or https URL"},
+				{"links[1].role", "must be one of main, featured"},
+				{"links[2].url", "is required"},
+			},
+		},
+		{
+			"every failure",
+			payload{Name: "Nina Simone", Year: intPtr(10), ArtistID: int64Ptr(2)},
+			Errors{
+				{"name", "must be at most 5 characters"},
+				{"year", "must be at least 1000"},
+				{"artist_id", "refers to 2, which doesn't exist"},
+			},
+		},
+	}
+
+	v := New(exists)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := v.Struct(context.Background(), &test.payload)
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			var got Errors
+			if !errors.As(err, &got) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStructLookupError(t *testing.T) {
+	failed := errors.New("database is down")
+	v := New(func(context.Context, string, int64) (bool, error) { return false, failed })
+	err := v.Struct(context.Background(), payload{Name: "Nina", ArtistID: int64Ptr(1)})
+	if !errors.Is(err, failed) {
+		t.Errorf("got %v, want the lookup's error", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	err := append(Field("year", "must be before %d", 2000), FieldError{"name", "is required"})
+	if got, want := err.Error(), "year must be before 2000; name is required"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}